@@ -1,17 +1,319 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/datasources/mysql/migrations"
+	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+	"github.com/amirnep/shop/src/domain/login_attempts"
+	"github.com/amirnep/shop/src/domain/password_resets"
+	"github.com/amirnep/shop/src/domain/refresh_tokens"
+	"github.com/amirnep/shop/src/domain/revocations"
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/health"
+	"github.com/amirnep/shop/src/images"
+	"github.com/amirnep/shop/src/jwt"
 	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/metrics"
+	"github.com/amirnep/shop/src/notifications"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/amirnep/shop/src/tracing"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	revocationsSyncInterval = 30 * time.Second
+	rolesSyncInterval       = time.Minute
+	jwtKeysReloadInterval   = 5 * time.Minute
+	// stopTimeout bounds stopping the components once the server drained.
+	stopTimeout = 10 * time.Second
+)
+
 var (
-	router = gin.Default()
+	router = gin.New()
 )
 
-func StartApplication() {
-	mapUrls()
+// StartApplication starts every component from cfg and serves until SIGTERM
+// or SIGINT, then drains the requests in flight and stops the components in
+// reverse order. A second signal exits immediately.
+func StartApplication(cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// On a signal the instance first reports not ready and keeps serving for
+	// the shutdown delay, so the load balancer stops routing to it before the
+	// listener closes.
+	serveCtx, cancelServe := context.WithCancel(context.Background())
+	defer cancelServe()
+	go func() {
+		<-ctx.Done()
+		stop()
+		health.Readiness.ShutDown()
+		time.Sleep(cfg.Server.ShutdownDelay)
+		cancelServe()
+	}()
+
+	lifecycle := NewLifecycle()
+	appendHooks(lifecycle, cfg)
+	if err := lifecycle.Start(ctx); err != nil {
+		logger.Sync()
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	var serveErr error
+	listener, err := net.Listen("tcp", cfg.Server.Address)
+	if err != nil {
+		serveErr = err
+	} else {
+		logger.Info("listening on " + listener.Addr().String())
+		serveErr = Serve(serveCtx, server, listener, cfg.Server.ShutdownTimeout)
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := errors.Join(serveErr, lifecycle.Stop(stopCtx)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// appendHooks registers the components in dependency order. The logger is
+// first, so it is flushed after everything else has stopped.
+func appendHooks(lifecycle *Lifecycle, cfg *config.Config) {
+	lifecycle.Append(Hook{
+		Name: "logger",
+		OnStop: func(ctx context.Context) error {
+			logger.Info("stopped")
+			logger.Sync()
+			return nil
+		},
+	})
+
+	// Tracing stops after every component that could still end a span.
+	var shutdownTracing func(context.Context) error
+	lifecycle.Append(Hook{
+		Name: "tracing",
+		OnStart: func(ctx context.Context) (err error) {
+			shutdownTracing, err = tracing.Setup(ctx, cfg.Tracing)
+			return err
+		},
+		OnStop: func(ctx context.Context) error {
+			return shutdownTracing(ctx)
+		},
+	})
+
+	lifecycle.Append(Hook{
+		Name: "database",
+		OnStart: func(ctx context.Context) error {
+			if err := users_db.Init(cfg.Database); err != nil {
+				return err
+			}
+			if err := metrics.RegisterDBStats(users_db.Client, "users"); err != nil {
+				return err
+			}
+			return checkSchema(ctx, cfg.Database)
+		},
+		OnStop: func(ctx context.Context) error {
+			return users_db.Close()
+		},
+	})
+
+	var imageStore storage.ImageStore
+	lifecycle.Append(Hook{
+		Name: "image storage",
+		OnStart: func(ctx context.Context) (err error) {
+			imageStore, err = storage.NewImageStore(cfg.Storage)
+			return err
+		},
+	})
+
+	lifecycle.Append(Hook{
+		Name: "services",
+		OnStart: func(ctx context.Context) error {
+			hasher, err := crypto_utils.NewPasswordHasher(cfg.Password.Hasher)
+			if err != nil {
+				return err
+			}
+			crypto_utils.Hasher = hasher
+
+			notifier, err := notifications.NewNotifier(cfg.Notifier.Name, cfg.Notifier.File)
+			if err != nil {
+				return err
+			}
+			notifications.Sender = notifier
+
+			jwt.Configure(cfg.JWT)
+			// Validate only lets an empty keys dir through with
+			// jwt.ephemeral_key.
+			if cfg.JWT.KeysDir == "" {
+				if err := jwt.LoadEphemeralKey(); err != nil {
+					return err
+				}
+			} else if err := jwt.LoadKeys(cfg.JWT.KeysDir, cfg.JWT.KeyActivationDelay); err != nil {
+				return err
+			}
+
+			services.UsersService = services.NewUsersService(users.NewMySQLRepository(users_db.Client))
+			services.TokensService = services.NewTokensService(cfg.JWT, refresh_tokens.NewMySQLRepository(users_db.Client))
+			services.RevocationsService = services.NewRevocationsService(revocations.NewMySQLRepository(users_db.Client))
+			services.PasswordResetsService = services.NewPasswordResetsService(cfg.PasswordReset, password_resets.NewMySQLRepository(users_db.Client))
+			services.EmailVerificationsService = services.NewEmailVerificationsService(cfg.EmailVerification)
+			services.LoginAttemptsService = services.NewLoginAttemptsService(cfg.Login, login_attempts.NewMySQLRepository(users_db.Client))
+			services.RolesService = services.NewRolesService(roles.NewMySQLRepository(users_db.Client))
+			services.TwoFactorService = services.NewTwoFactorService(cfg.TwoFactor, two_factor.NewMySQLRepository(users_db.Client))
+			var signer *storage.URLSigner
+			if cfg.Storage.SigningSecret != "" {
+				signer = storage.NewURLSigner(cfg.Storage.SigningSecret, cfg.Storage.SignedURLTTL)
+			}
+			services.ImagesService = services.NewImagesService(imageStore, cfg.Images, signer)
+			images.PublicURL = services.ImagesService.PublicURL
+			images.Stored = services.ImagesService.Stored
+
+			if err := services.RevocationsService.Load(ctx); err != nil {
+				return fmt.Errorf("loading token revocations: %s", err.Message)
+			}
+			if err := services.RolesService.Load(ctx); err != nil {
+				return fmt.Errorf("loading roles: %s", err.Message)
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			// Reset links still being sent need the database.
+			sent := make(chan struct{})
+			go func() {
+				services.PasswordResetsService.Wait()
+				close(sent)
+			}()
+			select {
+			case <-sent:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("password reset links still being sent: %w", ctx.Err())
+			}
+		},
+	})
+
+	// The sync loops query the database, so they stop before it closes.
+	background, cancelBackground := context.WithCancel(context.Background())
+	lifecycle.Append(Hook{
+		Name: "background sync",
+		OnStart: func(ctx context.Context) error {
+			jwt.StartKeyRotation(background, cfg.JWT.KeysDir, jwtKeysReloadInterval)
+			services.RevocationsService.StartSync(background, revocationsSyncInterval)
+			services.RolesService.StartSync(background, rolesSyncInterval)
+			if cfg.Images.SweepInterval > 0 {
+				services.ImagesService.StartSweeper(background, cfg.Images.SweepInterval, cfg.Images.OrphanGracePeriod)
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			cancelBackground()
+			return nil
+		},
+	})
+
+	lifecycle.Append(Hook{
+		Name: "health checks",
+		OnStart: func(ctx context.Context) error {
+			return registerHealthChecks(imageStore)
+		},
+	})
+
+	lifecycle.Append(Hook{
+		Name: "router",
+		OnStart: func(ctx context.Context) error {
+			// The per-IP login lockout relies on ClientIP, which must not
+			// trust X-Forwarded-For from arbitrary clients.
+			if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+				return err
+			}
+			if cfg.Login.IpLockout && len(cfg.Server.TrustedProxies) == 0 {
+				logger.Warn("login.ip_lockout is on but server.trusted_proxies is empty: behind a load balancer or " +
+					"reverse proxy every client shares its IP and a few failed logins lock everyone out. " +
+					"Set TRUSTED_PROXIES to the proxy addresses, or LOGIN_IP_LOCKOUT=false")
+			}
+			mapUrls()
+			return nil
+		},
+	})
+
+	// Metrics are served on a separate admin listener, so the API port
+	// never exposes them.
+	var metricsServer *http.Server
+	lifecycle.Append(Hook{
+		Name: "metrics server",
+		OnStart: func(ctx context.Context) error {
+			if cfg.Server.MetricsAddress == "" {
+				return nil
+			}
+			listener, err := net.Listen("tcp", cfg.Server.MetricsAddress)
+			if err != nil {
+				return err
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			metricsServer = &http.Server{
+				Handler:           mux,
+				ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			}
+			logger.Info("serving metrics on " + listener.Addr().String())
+			go func() {
+				if err := metricsServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+					logger.Error("metrics server stopped", err)
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if metricsServer == nil {
+				return nil
+			}
+			return metricsServer.Shutdown(ctx)
+		},
+	})
+}
+
+// checkSchema refuses to start on a schema older than the binary, or applies
+// the pending migrations first when migrate_on_start is set.
+func checkSchema(ctx context.Context, cfg config.Database) error {
+	migrator, err := migrations.New(users_db.Client)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MigrateOnStart {
+		if err := migrator.Up(ctx, 0); err != nil {
+			return err
+		}
+	}
 
-	logger.Info("about to start the application...")
-	router.Run(":8080")
-}
\ No newline at end of file
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if err := migrations.Supports(current, dirty); err != nil {
+		return fmt.Errorf("database %w, run the migrate subcommand", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/datasources/mysql/migrations"
+	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+	"github.com/amirnep/shop/src/health"
+	"github.com/amirnep/shop/src/storage"
+)
+
+func registerHealthChecks(images storage.ImageStore) error {
+	migrator, err := migrations.New(users_db.Client)
+	if err != nil {
+		return err
+	}
+
+	health.Readiness.Register("database", func(ctx context.Context) error {
+		return users_db.Client.PingContext(ctx)
+	})
+	health.Readiness.Register("migrations", func(ctx context.Context) error {
+		current, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		return migrations.Supports(current, dirty)
+	})
+	health.Readiness.Register("storage", images.Check)
+	return nil
+}
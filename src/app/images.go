@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+)
+
+const imagesUsage = `usage: users-api images [flags] <command>
+
+commands:
+  sweep [-dry-run]  delete the stored images no user references, once they
+                    are older than images.orphan_grace_period
+  migrate-legacy [-dry-run] [-root dir]
+                    move the images users reference by a local path, such as
+                    src/wwwroot/<uuid>.jpg, into the store and rewrite their
+                    image_url; relative paths are resolved against root`
+
+// Images runs the images subcommand and returns the process exit code.
+func Images(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, imagesUsage)
+		return 2
+	}
+
+	if err := users_db.Init(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer users_db.Close()
+
+	store, err := storage.NewImageStore(cfg.Storage)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	services.UsersService = services.NewUsersService(users.NewMySQLRepository(users_db.Client))
+	services.ImagesService = services.NewImagesService(store, cfg.Images, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if err := runImages(ctx, cfg.Images, args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runImages(ctx context.Context, cfg config.Images, args []string, out io.Writer) error {
+	switch args[0] {
+	case "sweep":
+		flags := flag.NewFlagSet("sweep", flag.ContinueOnError)
+		flags.SetOutput(out)
+		dryRun := flags.Bool("dry-run", false, "only count the orphaned images")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		result, err := services.ImagesService.Sweep(ctx, time.Now().Add(-cfg.OrphanGracePeriod), *dryRun)
+		if err != nil {
+			return fmt.Errorf("%s", err.Message)
+		}
+		fmt.Fprintf(out, "scanned %d images, %d orphaned, %d deleted\n", result.Scanned, result.Orphaned, result.Deleted)
+		return nil
+	case "migrate-legacy":
+		flags := flag.NewFlagSet("migrate-legacy", flag.ContinueOnError)
+		flags.SetOutput(out)
+		dryRun := flags.Bool("dry-run", false, "only count the legacy images")
+		root := flags.String("root", ".", "directory the legacy image paths are relative to")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		result, err := services.ImagesService.MigrateLegacy(ctx, *root, *dryRun)
+		if err != nil {
+			return fmt.Errorf("%s", err.Message)
+		}
+		fmt.Fprintf(out, "scanned %d users, %d legacy images, %d migrated, %d failed\n", result.Scanned, result.Legacy, result.Migrated, result.Failed)
+		if result.Failed > 0 {
+			return fmt.Errorf("%d legacy images could not be migrated, see the log", result.Failed)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], imagesUsage)
+	}
+}
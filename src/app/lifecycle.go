@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/amirnep/shop/src/logger"
+)
+
+// Hook is one component of the application. OnStart runs at startup and
+// OnStop at shutdown, either may be nil.
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Lifecycle starts hooks in the order they were appended and stops them in
+// reverse, so a component is stopped before the ones it depends on.
+type Lifecycle struct {
+	hooks   []Hook
+	started int
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+func (l *Lifecycle) Append(hook Hook) {
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs every OnStart in order. When one fails, the hooks that already
+// started are stopped again and the failure is returned.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	for _, hook := range l.hooks[l.started:] {
+		if hook.OnStart != nil {
+			if err := hook.OnStart(ctx); err != nil {
+				startErr := fmt.Errorf("starting %s: %w", hook.Name, err)
+				return errors.Join(startErr, l.Stop(ctx))
+			}
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop runs OnStop for every started hook in reverse order. A failing hook
+// does not keep the others from stopping, all failures are returned.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	var stopErrs []error
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+		if hook.OnStop == nil {
+			continue
+		}
+		if err := hook.OnStop(ctx); err != nil {
+			stopErrs = append(stopErrs, fmt.Errorf("stopping %s: %w", hook.Name, err))
+		}
+	}
+	return errors.Join(stopErrs...)
+}
+
+// Serve accepts connections on listener until ctx is done, then stops
+// accepting and waits up to shutdownTimeout for the requests in flight. The
+// connections still open after that are closed.
+func Serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down, draining requests in flight...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests still in flight after %s: %w", shutdownTimeout, err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
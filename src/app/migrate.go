@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/datasources/mysql/migrations"
+	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+)
+
+const migrateUsage = `usage: users-api migrate [flags] <command>
+
+commands:
+  up [version]     apply pending migrations, up to version if given
+  down [--allow-drop] [steps]
+                   revert the newest steps migrations, 1 by default;
+                   reverting migration 1 drops the users table and needs
+                   --allow-drop
+  status           list migrations and whether they are applied
+  force <version>  record version as applied after a manual repair`
+
+// Migrate runs the migrate subcommand and returns the process exit code.
+func Migrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err := users_db.Init(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer users_db.Close()
+
+	migrator, err := migrations.New(users_db.Client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := runMigrate(context.Background(), migrator, args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(ctx context.Context, migrator *migrations.Migrator, args []string, out io.Writer) error {
+	argument := func(def int64) (int64, error) {
+		if len(args) < 2 {
+			return def, nil
+		}
+		value, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid argument %q\n%s", args[1], migrateUsage)
+		}
+		return value, nil
+	}
+
+	switch args[0] {
+	case "up":
+		target, err := argument(0)
+		if err != nil {
+			return err
+		}
+		if err := migrator.Up(ctx, target); err != nil {
+			return err
+		}
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		allowDrop := flags.Bool("allow-drop", false, "")
+		if err := flags.Parse(args[1:]); err != nil {
+			return fmt.Errorf("%s\n%s", err, migrateUsage)
+		}
+		args = append([]string{args[0]}, flags.Args()...)
+
+		steps, err := argument(1)
+		if err != nil {
+			return err
+		}
+		if err := migrator.Down(ctx, int(steps), *allowDrop); err != nil {
+			return err
+		}
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("force needs a version\n%s", migrateUsage)
+		}
+		version, err := argument(0)
+		if err != nil {
+			return err
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.Dirty:
+				state = "dirty"
+			case status.Applied:
+				state = "applied " + status.AppliedAt
+			}
+			fmt.Fprintf(out, "%04d %-30s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], migrateUsage)
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema version %d", version)
+	if dirty {
+		fmt.Fprint(out, " (dirty)")
+	}
+	fmt.Fprintln(out)
+	return nil
+}
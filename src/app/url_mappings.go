@@ -2,12 +2,34 @@ package app
 
 import (
 	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/domain/roles"
 	"github.com/amirnep/shop/src/middlewares"
 )
 
 func mapUrls() {
+	// Recovery is innermost, so that a panic still shows up as a 500 in the
+	// access log, the trace and the metrics.
+	router.Use(
+		middlewares.RequestId(),
+		middlewares.AccessLog(),
+		middlewares.Tracing(),
+		middlewares.Metrics(),
+		middlewares.Recovery(),
+	)
+	router.GET("/healthz", controllers.HealthController.Healthz)
+	router.GET("/readyz", controllers.HealthController.Readyz)
+	router.GET("/images/*key", controllers.ImagesController.Get)
+	router.HEAD("/images/*key", controllers.ImagesController.Get)
+
 	router.POST("/Register", controllers.UsersController.Create)
 	router.POST("/Login", controllers.UsersController.Login)
+	router.POST("/Login/2FA", controllers.TwoFactorController.CompleteLogin)
+	router.POST("/Token/Refresh", controllers.TokensController.Refresh)
+	router.POST("/ForgotPassword", controllers.PasswordResetsController.ForgotPassword)
+	router.POST("/ResetPassword", controllers.PasswordResetsController.ResetPassword)
+	router.GET("/VerifyEmail", controllers.EmailVerificationsController.VerifyEmail)
+	router.POST("/ResendVerification", controllers.EmailVerificationsController.ResendVerification)
+	router.GET("/.well-known/jwks.json", controllers.KeysController.GetJWKS)
 
 	protected := router.Group("/api")
 	protected.Use(middlewares.JWTAuthCustomerMiddleware())
@@ -16,13 +38,25 @@ func mapUrls() {
 	protected.PUT("/EditProfile", controllers.UsersController.Update)
 	protected.PATCH("/EditProfile", controllers.UsersController.Update)
 	protected.PUT("/ChangePassword", controllers.UsersController.ChangePassword)
+	protected.POST("/Logout", controllers.TokensController.Logout)
+	protected.POST("/2FA/Enroll", controllers.TwoFactorController.Enroll)
+	protected.POST("/2FA/Confirm", controllers.TwoFactorController.Confirm)
+	protected.POST("/2FA/Disable", controllers.TwoFactorController.Disable)
 
 
 	admin := router.Group("/api/admin")
-	admin.Use(middlewares.JWTAuthMiddleware())
+	admin.Use(middlewares.JWTAuthCustomerMiddleware())
 
-	admin.GET("/GetUsers", controllers.UsersController.GetUsers)
-	admin.GET("/GetUser/:user_id", controllers.UsersController.Get)
-	admin.DELETE("/DeleteUser/:user_id", controllers.UsersController.Delete)
-	admin.PUT("/EditRole/:user_id", controllers.UsersController.UpdateRole)
+	admin.GET("/GetUsers", middlewares.RequirePermission(roles.PermUsersRead), controllers.UsersController.GetUsers)
+	admin.GET("/ExportUsers", middlewares.RequirePermission(roles.PermUsersRead), controllers.UsersController.ExportUsers)
+	admin.GET("/GetUser/:user_id", middlewares.RequirePermission(roles.PermUsersRead), controllers.UsersController.Get)
+	admin.DELETE("/DeleteUser/:user_id", middlewares.RequirePermission(roles.PermUsersDelete), controllers.UsersController.Delete)
+	admin.PUT("/EditRole/:user_id", middlewares.RequirePermission(roles.PermUsersAssignRole), controllers.UsersController.UpdateRole)
+	admin.GET("/Lockouts", middlewares.RequirePermission(roles.PermLockoutsRead), controllers.LoginAttemptsController.GetLockouts)
+	admin.DELETE("/Lockouts/:kind/:key", middlewares.RequirePermission(roles.PermLockoutsClear), controllers.LoginAttemptsController.ClearLockout)
+	admin.GET("/Roles", middlewares.RequirePermission(roles.PermRolesRead), controllers.RolesController.GetRoles)
+	admin.GET("/Permissions", middlewares.RequirePermission(roles.PermRolesRead), controllers.RolesController.GetPermissions)
+	admin.POST("/Roles", middlewares.RequirePermission(roles.PermRolesManage), controllers.RolesController.CreateRole)
+	admin.PUT("/Roles/:role/Permissions", middlewares.RequirePermission(roles.PermRolesManage), controllers.RolesController.SetPermissions)
+	admin.DELETE("/Roles/:role", middlewares.RequirePermission(roles.PermRolesManage), controllers.RolesController.DeleteRole)
 }
\ No newline at end of file
@@ -0,0 +1,297 @@
+// Package config holds the typed configuration of the service. It is loaded
+// once at startup, from defaults, an optional YAML or TOML file, environment
+// variables and flags, in increasing order of precedence, and then handed to
+// every component that needs it.
+//
+// Every setting is described by struct tags: key is its name in the file and
+// the flag name, env the environment variable and default its default value.
+// Durations accept Go duration strings such as "15m", or a number of seconds.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Server            Server
+	Database          Database
+	JWT               JWT
+	Password          Password
+	PasswordReset     PasswordReset
+	EmailVerification EmailVerification
+	Login             Login
+	TwoFactor         TwoFactor
+	Notifier          Notifier
+	Tracing           Tracing
+	Storage           Storage
+	Images            Images
+}
+
+type Server struct {
+	Address string `key:"server.address" env:"HTTP_ADDR" default:":8080"`
+	// MetricsAddress serves /metrics on its own listener, kept off the
+	// public API. It listens on loopback only by default; bind it to other
+	// interfaces only when they are not reachable from outside the cluster,
+	// and leave it empty to disable metrics.
+	MetricsAddress string `key:"server.metrics_address" env:"METRICS_ADDR" default:"127.0.0.1:9090"`
+	// TrustedProxies may set X-Forwarded-For. The per-IP login lockout
+	// relies on it, so no proxy is trusted by default.
+	TrustedProxies []string `key:"server.trusted_proxies" env:"TRUSTED_PROXIES"`
+
+	ReadHeaderTimeout time.Duration `key:"server.read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" default:"5s"`
+	// ReadTimeout covers the whole request, including image uploads.
+	ReadTimeout time.Duration `key:"server.read_timeout" env:"HTTP_READ_TIMEOUT" default:"30s"`
+	// WriteTimeout bounds a response. Streaming exports extend it as they
+	// make progress.
+	WriteTimeout time.Duration `key:"server.write_timeout" env:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout  time.Duration `key:"server.idle_timeout" env:"HTTP_IDLE_TIMEOUT" default:"2m"`
+	// ShutdownDelay is how long the server keeps accepting requests after
+	// SIGTERM while /readyz already fails. On Kubernetes it should cover a
+	// few readiness probe periods.
+	ShutdownDelay time.Duration `key:"server.shutdown_delay" env:"HTTP_SHUTDOWN_DELAY" default:"0s"`
+	// ShutdownTimeout is how long in-flight requests may drain after
+	// SIGTERM. It must stay below the pod's terminationGracePeriodSeconds.
+	ShutdownTimeout time.Duration `key:"server.shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" default:"20s"`
+}
+
+type Database struct {
+	Username string `key:"database.username" env:"mysql_users_username"`
+	Password string `key:"database.password" env:"mysql_users_password"`
+	Host     string `key:"database.host" env:"mysql_users_host"`
+	Schema   string `key:"database.schema" env:"mysql_users_schema"`
+	// MigrateOnStart applies pending migrations before the server starts.
+	MigrateOnStart bool `key:"database.migrate_on_start" env:"MIGRATE_ON_START"`
+}
+
+type JWT struct {
+	Issuer          string        `key:"jwt.issuer" env:"JWT_ISSUER" default:"shop-users-api"`
+	Audience        string        `key:"jwt.audience" env:"JWT_AUDIENCE" default:"shop"`
+	ClockSkew       time.Duration `key:"jwt.clock_skew" env:"JWT_CLOCK_SKEW" default:"30s"`
+	AccessTokenTTL  time.Duration `key:"jwt.access_token_ttl" env:"TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL time.Duration `key:"jwt.refresh_token_ttl" env:"REFRESH_TOKEN_TTL" default:"720h"`
+	// KeysDir holds the PEM signing keys. It is required unless EphemeralKey
+	// is set.
+	KeysDir string `key:"jwt.keys_dir" env:"JWT_KEYS_DIR"`
+	// EphemeralKey lets the server start without KeysDir and sign with a key
+	// generated at startup. Tokens then die with the process and cannot be
+	// verified by other replicas, so it is only meant for development.
+	EphemeralKey bool `key:"jwt.ephemeral_key" env:"JWT_EPHEMERAL_KEY"`
+	// KeyActivationDelay is how long a new key is only published in the
+	// JWKS before it is used for signing.
+	KeyActivationDelay time.Duration `key:"jwt.key_activation_delay" env:"JWT_KEY_ACTIVATION_DELAY" default:"1h"`
+}
+
+type Password struct {
+	Hasher string `key:"password.hasher" env:"PASSWORD_HASHER" default:"argon2id"`
+}
+
+type PasswordReset struct {
+	TTL time.Duration `key:"password_reset.ttl" env:"PASSWORD_RESET_TTL" default:"30m"`
+	URL string        `key:"password_reset.url" env:"PASSWORD_RESET_URL" default:"http://localhost:8080/ResetPassword"`
+}
+
+type EmailVerification struct {
+	// Required rejects logins until the email address is verified.
+	Required bool `key:"email_verification.required" env:"REQUIRE_VERIFIED_EMAIL"`
+	// Secret signs the verification links. It is required and must be at
+	// least 32 characters long, every replica needs the same one.
+	Secret         string        `key:"email_verification.secret" env:"EMAIL_VERIFICATION_SECRET"`
+	TTL            time.Duration `key:"email_verification.ttl" env:"EMAIL_VERIFICATION_TTL" default:"24h"`
+	URL            string        `key:"email_verification.url" env:"EMAIL_VERIFICATION_URL" default:"http://localhost:8080/VerifyEmail"`
+	ResendInterval time.Duration `key:"email_verification.resend_interval" env:"EMAIL_VERIFICATION_RESEND_INTERVAL" default:"1m"`
+}
+
+type Login struct {
+	// IpLockout also locks client IPs that fail too often. The IP is the
+	// one gin.Context.ClientIP reports, so behind a load balancer
+	// server.trusted_proxies must be set or every client shares its IP.
+	IpLockout          bool          `key:"login.ip_lockout" env:"LOGIN_IP_LOCKOUT" default:"true"`
+	MaxAccountFailures int           `key:"login.max_account_failures" env:"LOGIN_MAX_ACCOUNT_FAILURES" default:"5"`
+	MaxIpFailures      int           `key:"login.max_ip_failures" env:"LOGIN_MAX_IP_FAILURES" default:"20"`
+	LockoutBase        time.Duration `key:"login.lockout_base" env:"LOGIN_LOCKOUT_BASE" default:"1m"`
+	LockoutMax         time.Duration `key:"login.lockout_max" env:"LOGIN_LOCKOUT_MAX" default:"1h"`
+	FailureWindow      time.Duration `key:"login.failure_window" env:"LOGIN_FAILURE_WINDOW" default:"15m"`
+}
+
+type TwoFactor struct {
+	Issuer string `key:"two_factor.issuer" env:"TOTP_ISSUER" default:"Shop"`
+	// RequireForAdmin requires two-factor authentication from every role
+	// granted a privileged permission, see roles.PrivilegedPermissions.
+	RequireForAdmin bool `key:"two_factor.require_for_admin" env:"REQUIRE_ADMIN_2FA"`
+}
+
+type Notifier struct {
+	Name string `key:"notifier.name" env:"NOTIFIER" default:"log"`
+	File string `key:"notifier.file" env:"NOTIFIER_FILE"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout or otlp. With none the trace context of
+	// incoming requests is still propagated to the logs.
+	Exporter string `key:"tracing.exporter" env:"TRACING_EXPORTER" default:"none"`
+	// Endpoint is the OTLP/HTTP endpoint of the collector.
+	Endpoint    string  `key:"tracing.endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318"`
+	ServiceName string  `key:"tracing.service_name" env:"OTEL_SERVICE_NAME" default:"shop-users-api"`
+	SampleRatio float64 `key:"tracing.sample_ratio" env:"TRACING_SAMPLE_RATIO" default:"1"`
+}
+
+type Storage struct {
+	// Backend is local or s3.
+	Backend string `key:"storage.backend" env:"IMAGE_STORAGE" default:"local"`
+	// LocalDir holds the images of the local backend. A relative path is
+	// resolved against the working directory at startup.
+	LocalDir string `key:"storage.local_dir" env:"IMAGE_STORAGE_DIR" default:"wwwroot"`
+	// LocalPublicURL is where the local backend's images are served from.
+	LocalPublicURL string `key:"storage.local_public_url" env:"IMAGE_PUBLIC_URL" default:"http://localhost:8080/images"`
+	// S3Endpoint is the host, and optionally port, of an S3 compatible
+	// service such as AWS S3 or MinIO.
+	S3Endpoint  string `key:"storage.s3_endpoint" env:"S3_ENDPOINT"`
+	S3Bucket    string `key:"storage.s3_bucket" env:"S3_BUCKET"`
+	S3Region    string `key:"storage.s3_region" env:"S3_REGION" default:"us-east-1"`
+	S3AccessKey string `key:"storage.s3_access_key" env:"S3_ACCESS_KEY"`
+	S3SecretKey string `key:"storage.s3_secret_key" env:"S3_SECRET_KEY"`
+	S3UseSSL    bool   `key:"storage.s3_use_ssl" env:"S3_USE_SSL" default:"true"`
+	// S3PublicURL is where the bucket's objects are publicly readable, e.g. a
+	// CDN. It defaults to the bucket's path-style URL on the endpoint.
+	S3PublicURL string `key:"storage.s3_public_url" env:"S3_PUBLIC_URL"`
+
+	// SigningSecret turns on signed image URLs: clients are handed URLs that
+	// expire after SignedURLTTL, and the /images route refuses any other. The
+	// public URL must then point at that route, also with the s3 backend.
+	SigningSecret string        `key:"storage.signing_secret" env:"IMAGE_SIGNING_SECRET"`
+	SignedURLTTL  time.Duration `key:"storage.signed_url_ttl" env:"IMAGE_SIGNED_URL_TTL" default:"1h"`
+}
+
+type Images struct {
+	// MaxUploadSize bounds an uploaded file, in bytes.
+	MaxUploadSize int `key:"images.max_upload_size" env:"IMAGE_MAX_UPLOAD_SIZE" default:"3145728"`
+	// MaxPixels bounds width times height. It is checked against the image
+	// header before decoding, so a small file cannot expand into gigabytes of
+	// pixels.
+	MaxPixels    int `key:"images.max_pixels" env:"IMAGE_MAX_PIXELS" default:"25000000"`
+	MaxDimension int `key:"images.max_dimension" env:"IMAGE_MAX_DIMENSION" default:"8192"`
+	JPEGQuality  int `key:"images.jpeg_quality" env:"IMAGE_JPEG_QUALITY" default:"85"`
+
+	// OrphanGracePeriod is how old an image no user references must be
+	// before the sweeper deletes it, it covers uploads whose user is not
+	// saved yet.
+	OrphanGracePeriod time.Duration `key:"images.orphan_grace_period" env:"IMAGE_ORPHAN_GRACE_PERIOD" default:"24h"`
+	// SweepInterval runs the sweeper in the background, 0 leaves it to the
+	// images sweep command. The sweeper looks the images up by their public
+	// URL, so the image_url of the users must be rewritten before the public
+	// URL changes, or every image looks orphaned.
+	SweepInterval time.Duration `key:"images.sweep_interval" env:"IMAGE_SWEEP_INTERVAL" default:"0s"`
+}
+
+// Default returns the configuration made of the default values only.
+func Default() *Config {
+	cfg := &Config{}
+	if err := applyDefaults(cfg); err != nil {
+		// The defaults are part of the code, a broken one is a bug.
+		panic(err)
+	}
+	return cfg
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Address != "", "server.address must not be empty")
+	check(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	check(c.Database.Host != "", "database.host (mysql_users_host) is required")
+	check(c.Database.Schema != "", "database.schema (mysql_users_schema) is required")
+	check(c.Database.Username != "", "database.username (mysql_users_username) is required")
+
+	check(c.JWT.Issuer != "", "jwt.issuer must not be empty")
+	check(c.JWT.Audience != "", "jwt.audience must not be empty")
+	check(c.JWT.ClockSkew >= 0, "jwt.clock_skew must not be negative")
+	check(c.JWT.AccessTokenTTL > 0, "jwt.access_token_ttl must be positive")
+	check(c.JWT.RefreshTokenTTL > c.JWT.AccessTokenTTL, "jwt.refresh_token_ttl must be longer than jwt.access_token_ttl")
+	check(c.JWT.KeysDir != "" || c.JWT.EphemeralKey, "jwt.keys_dir (JWT_KEYS_DIR) is required, set jwt.ephemeral_key to sign with a throwaway key in development")
+	check(c.JWT.KeyActivationDelay >= 0, "jwt.key_activation_delay must not be negative")
+
+	check(c.Password.Hasher == "argon2id" || c.Password.Hasher == "bcrypt", "password.hasher must be argon2id or bcrypt, got %q", c.Password.Hasher)
+
+	check(c.PasswordReset.TTL > 0, "password_reset.ttl must be positive")
+	check(isAbsoluteURL(c.PasswordReset.URL), "password_reset.url must be an absolute URL, got %q", c.PasswordReset.URL)
+
+	check(c.EmailVerification.Secret != "", "email_verification.secret (EMAIL_VERIFICATION_SECRET) is required")
+	check(c.EmailVerification.Secret == "" || len(c.EmailVerification.Secret) >= 32, "email_verification.secret must be at least 32 characters")
+	check(c.EmailVerification.TTL > 0, "email_verification.ttl must be positive")
+	check(c.EmailVerification.ResendInterval >= 0, "email_verification.resend_interval must not be negative")
+	check(isAbsoluteURL(c.EmailVerification.URL), "email_verification.url must be an absolute URL, got %q", c.EmailVerification.URL)
+
+	check(c.Login.MaxAccountFailures > 0, "login.max_account_failures must be positive")
+	check(c.Login.MaxIpFailures > 0, "login.max_ip_failures must be positive")
+	check(c.Login.LockoutBase > 0, "login.lockout_base must be positive")
+	check(c.Login.LockoutMax >= c.Login.LockoutBase, "login.lockout_max must not be shorter than login.lockout_base")
+	check(c.Login.FailureWindow > 0, "login.failure_window must be positive")
+
+	check(c.TwoFactor.Issuer != "", "two_factor.issuer must not be empty")
+
+	switch strings.ToLower(c.Notifier.Name) {
+	case "log":
+	case "file":
+		check(c.Notifier.File != "", "notifier.file is required by the file notifier")
+	default:
+		check(false, "notifier.name must be log or file, got %q", c.Notifier.Name)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		check(isAbsoluteURL(c.Tracing.Endpoint), "tracing.endpoint must be an absolute URL, got %q", c.Tracing.Endpoint)
+	default:
+		check(false, "tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	switch c.Storage.Backend {
+	case "local":
+		check(c.Storage.LocalDir != "", "storage.local_dir is required by the local backend")
+		check(isAbsoluteURL(c.Storage.LocalPublicURL), "storage.local_public_url must be an absolute URL, got %q", c.Storage.LocalPublicURL)
+	case "s3":
+		check(c.Storage.S3Endpoint != "", "storage.s3_endpoint is required by the s3 backend")
+		check(c.Storage.S3Bucket != "", "storage.s3_bucket is required by the s3 backend")
+		check(c.Storage.S3PublicURL == "" || isAbsoluteURL(c.Storage.S3PublicURL), "storage.s3_public_url must be an absolute URL, got %q", c.Storage.S3PublicURL)
+	default:
+		check(false, "storage.backend must be local or s3, got %q", c.Storage.Backend)
+	}
+
+	check(c.Storage.SigningSecret == "" || len(c.Storage.SigningSecret) >= 32, "storage.signing_secret must be at least 32 characters")
+	check(c.Storage.SignedURLTTL > 0, "storage.signed_url_ttl must be positive")
+
+	check(c.Images.MaxUploadSize > 0, "images.max_upload_size must be positive")
+	check(c.Images.MaxPixels > 0, "images.max_pixels must be positive")
+	check(c.Images.MaxDimension > 0, "images.max_dimension must be positive")
+	check(c.Images.OrphanGracePeriod > 0, "images.orphan_grace_period must be positive")
+	check(c.Images.SweepInterval >= 0, "images.sweep_interval must not be negative")
+	check(c.Images.JPEGQuality >= 1 && c.Images.JPEGQuality <= 100, "images.jpeg_quality must be between 1 and 100")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(problems...))
+	}
+	return nil
+}
+
+func isAbsoluteURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileFlag = "config"
+	configFileEnv  = "CONFIG_FILE"
+)
+
+// Load builds the configuration for the command line args and validates it.
+// A .env file in the working directory is read into the environment when it
+// exists, without overriding variables that are already set. The remaining
+// positional arguments are returned.
+func Load(args []string) (*Config, []string, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("reading .env: %w", err)
+	}
+	return load(args, os.LookupEnv, os.Stderr)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, []string, error) {
+	cfg := &Config{}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, nil, err
+	}
+	settings := settingsOf(cfg)
+
+	flags := flag.NewFlagSet("users-api", flag.ContinueOnError)
+	flags.SetOutput(output)
+	configFile := flags.String(configFileFlag, "", "YAML or TOML configuration file, also read from "+configFileEnv)
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.key] = flags.String(s.key, "", s.usage())
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(configFileEnv)
+	}
+	if *configFile != "" {
+		fileValues, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range settings {
+			if value, ok := fileValues[s.key]; ok {
+				if err := s.set(value); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", *configFile, err)
+				}
+				delete(fileValues, s.key)
+			}
+		}
+		if len(fileValues) > 0 {
+			return nil, nil, fmt.Errorf("%s: unknown settings %s", *configFile, strings.Join(sortedKeys(fileValues), ", "))
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && s.env != "" {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("environment: %w", err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.key == f.Name && flagErr == nil {
+				flagErr = s.set(*values[s.key])
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, fmt.Errorf("flags: %w", flagErr)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// setting is one configurable field of Config.
+type setting struct {
+	key   string
+	env   string
+	def   string
+	field reflect.Value
+}
+
+func (s setting) usage() string {
+	usage := "sets " + s.key
+	if s.env != "" {
+		usage += ", also read from " + s.env
+	}
+	if s.def != "" {
+		usage += " (default " + s.def + ")"
+	}
+	return usage
+}
+
+func (s setting) set(value string) error {
+	value = strings.TrimSpace(value)
+	switch s.field.Interface().(type) {
+	case string:
+		s.field.SetString(value)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		s.field.Set(reflect.ValueOf(list))
+	case bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", s.key, value)
+		}
+		s.field.SetBool(parsed)
+	case int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number, got %q", s.key, value)
+		}
+		s.field.SetInt(int64(parsed))
+	case float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", s.key, value)
+		}
+		s.field.SetFloat(parsed)
+	case time.Duration:
+		parsed, err := parseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration like 15m or a number of seconds, got %q", s.key, value)
+		}
+		s.field.SetInt(int64(parsed))
+	default:
+		return fmt.Errorf("%s has an unsupported type %s", s.key, s.field.Type())
+	}
+	return nil
+}
+
+// parseDuration accepts a number of seconds, which the environment variables
+// have always used, or a Go duration string.
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func settingsOf(cfg *Config) []setting {
+	var settings []setting
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+			settings = append(settings, setting{
+				key:   tag.Get("key"),
+				env:   tag.Get("env"),
+				def:   tag.Get("default"),
+				field: section.Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+func applyDefaults(cfg *Config) error {
+	for _, s := range settingsOf(cfg) {
+		if s.def == "" {
+			continue
+		}
+		if err := s.set(s.def); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	return nil
+}
+
+// readFile flattens a YAML or TOML file into dotted keys, so that
+// {"jwt": {"issuer": "x"}} becomes "jwt.issuer".
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &tree)
+	case ".toml":
+		err = toml.Unmarshal(content, &tree)
+	default:
+		return nil, fmt.Errorf("%s: configuration files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) {
+	for name, value := range tree {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			flatten(key, typed, values)
+		case []interface{}:
+			items := make([]string, len(typed))
+			for i, item := range typed {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(typed)
+		}
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	EmailVerificationsController emailVerificationsControllerInterface = &emailVerificationsController{}
+)
+
+type emailVerificationsController struct{}
+
+type emailVerificationsControllerInterface interface {
+	VerifyEmail(c *gin.Context)
+	ResendVerification(c *gin.Context)
+}
+
+func (e *emailVerificationsController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		restErr := errors.NewBadRequestError("verification token is required")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	if err := services.EmailVerificationsService.VerifyEmail(c.Request.Context(), token); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified successfully"})
+}
+
+func (e *emailVerificationsController) ResendVerification(c *gin.Context) {
+	var input users.EmailInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	if err := services.EmailVerificationsService.ResendVerification(c.Request.Context(), input.Email); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the email is registered and not verified yet, a verification link has been sent"})
+}
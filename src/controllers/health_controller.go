@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/health"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	HealthController healthControllerInterface = &healthController{}
+)
+
+type healthController struct{}
+
+type healthControllerInterface interface {
+	Healthz(c *gin.Context)
+	Readyz(c *gin.Context)
+}
+
+// Healthz only tells that the process is serving requests. It does not look
+// at any dependency, so a database outage does not get the pod restarted.
+func (h *healthController) Healthz(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, health.Report{Status: health.StatusOk})
+}
+
+// Readyz runs every registered check and answers 503 when one of them fails
+// or the application is shutting down.
+func (h *healthController) Readyz(c *gin.Context) {
+	report := health.Readiness.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if !report.Ok() {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
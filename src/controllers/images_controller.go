@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ImagesController imagesControllerInterface = &imagesController{}
+)
+
+type imagesController struct{}
+
+type imagesControllerInterface interface {
+	Get(c *gin.Context)
+}
+
+// Get serves a stored image. Conditional and range requests are answered
+// from the ETag and modification time of the stored image.
+func (i *imagesController) Get(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	image, err := services.ImagesService.Open(c.Request.Context(), key, c.Query(storage.ExpiresParam), c.Query(storage.SignatureParam))
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	defer image.Content.Close()
+
+	contentType := image.Info.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", image.CacheControl)
+	if image.Info.ETag != "" {
+		header.Set("ETag", image.Info.ETag)
+	}
+
+	if content, ok := image.Content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", image.Info.ModTime, content)
+		return
+	}
+
+	// Without seeking there are no ranges, the image is sent whole.
+	header.Set("Content-Length", strconv.FormatInt(image.Info.Size, 10))
+	c.Status(http.StatusOK)
+	if c.Request.Method != http.MethodHead {
+		io.Copy(c.Writer, image.Content)
+	}
+}
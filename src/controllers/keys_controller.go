@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	KeysController keysControllerInterface = &keysController{}
+)
+
+type keysController struct{}
+
+type keysControllerInterface interface {
+	GetJWKS(c *gin.Context)
+}
+
+func (k *keysController) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.JWKS())
+}
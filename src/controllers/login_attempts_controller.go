@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/services"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	LoginAttemptsController loginAttemptsControllerInterface = &loginAttemptsController{}
+)
+
+type loginAttemptsController struct{}
+
+type loginAttemptsControllerInterface interface {
+	GetLockouts(c *gin.Context)
+	ClearLockout(c *gin.Context)
+}
+
+func (l *loginAttemptsController) GetLockouts(c *gin.Context) {
+	result, err := services.LoginAttemptsService.GetLockouts(c.Request.Context())
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (l *loginAttemptsController) ClearLockout(c *gin.Context) {
+	if err := services.LoginAttemptsService.ClearLockout(c.Request.Context(), c.Param("kind"), c.Param("key")); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]string{"status": "cleared"})
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/domain/password_resets"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	PasswordResetsController passwordResetsControllerInterface = &passwordResetsController{}
+)
+
+type passwordResetsController struct{}
+
+type passwordResetsControllerInterface interface {
+	ForgotPassword(c *gin.Context)
+	ResetPassword(c *gin.Context)
+}
+
+func (p *passwordResetsController) ForgotPassword(c *gin.Context) {
+	var input password_resets.ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	if err := services.PasswordResetsService.RequestReset(c.Request.Context(), input.Email); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the email is registered, a reset link has been sent"})
+}
+
+func (p *passwordResetsController) ResetPassword(c *gin.Context) {
+	var input password_resets.ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	if err := services.PasswordResetsService.ResetPassword(c.Request.Context(), input); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset successfully"})
+}
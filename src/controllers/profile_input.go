@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxProfileBody bounds a JSON profile, images are uploaded as
+	// multipart.
+	maxProfileBody = 64 << 10
+	// maxFormMemory is how much of a multipart form is kept in memory, the
+	// rest goes to temporary files.
+	maxFormMemory = 8 << 20
+	// formOverhead is what a form may hold besides the image: the other
+	// fields, the boundaries and the part headers.
+	formOverhead = 64 << 10
+
+	imageFormField       = "Image"
+	removeImageFormField = "remove_image"
+)
+
+// profileFormFields are the form fields the names of the profile are read
+// from. Multipart clients used to send the Go field names.
+var profileFormFields = map[string][]string{
+	"first_name": {"first_name", "FirstName"},
+	"last_name":  {"last_name", "LastName"},
+}
+
+// readProfilePatch reads the profile change of an EditProfile request, a JSON
+// merge patch or a form with an optional image, and returns it as a merge
+// patch along with the uploaded image. A form removes the image with
+// remove_image=true. With replace, as for a PUT, the names that are left out
+// are removed.
+func readProfilePatch(c *gin.Context, replace bool) (json.RawMessage, *multipart.FileHeader, *errors.RestErr) {
+	members := make(map[string]interface{})
+	var image *multipart.FileHeader
+
+	switch c.ContentType() {
+	case "application/json", "application/merge-patch+json":
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxProfileBody))
+		if err != nil {
+			return nil, nil, errors.NewBadRequestError("invalid json body")
+		}
+		if err := json.Unmarshal(body, &members); err != nil || members == nil {
+			return nil, nil, errors.NewBadRequestError("profile must be a JSON object")
+		}
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		limitUploadBody(c)
+		if err := c.Request.ParseMultipartForm(maxFormMemory); err != nil && err != http.ErrNotMultipart {
+			return nil, nil, errors.NewBadRequestError("invalid form")
+		}
+		for member, fields := range profileFormFields {
+			for _, field := range fields {
+				if values, ok := c.Request.PostForm[field]; ok {
+					members[member] = values[0]
+					break
+				}
+			}
+		}
+		if remove, _ := strconv.ParseBool(c.Request.PostForm.Get(removeImageFormField)); remove {
+			members["image_url"] = nil
+		}
+		image = uploadedImage(c)
+	default:
+		return nil, nil, errors.NewUnsupportedMediaTypeError("profile must be sent as JSON or as a form")
+	}
+
+	if replace {
+		for member := range profileFormFields {
+			if _, ok := members[member]; !ok {
+				members[member] = nil
+			}
+		}
+	}
+	document, _ := json.Marshal(members)
+	return document, image, nil
+}
+
+// limitUploadBody caps the request body at the largest image and the rest of
+// the form, so that an oversized upload is cut off before it is parsed
+// rather than spooled to disk.
+func limitUploadBody(c *gin.Context) {
+	limit := int64(services.ImagesService.MaxUploadSize()) + formOverhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+}
+
+// uploadedImage returns the image of a multipart request, nil when there is
+// none.
+func uploadedImage(c *gin.Context) *multipart.FileHeader {
+	if c.Request.MultipartForm == nil {
+		return nil
+	}
+	if files := c.Request.MultipartForm.File[imageFormField]; len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	RolesController rolesControllerInterface = &rolesController{}
+)
+
+type rolesController struct{}
+
+type rolesControllerInterface interface {
+	GetRoles(c *gin.Context)
+	GetPermissions(c *gin.Context)
+	CreateRole(c *gin.Context)
+	SetPermissions(c *gin.Context)
+	DeleteRole(c *gin.Context)
+}
+
+func (r *rolesController) GetRoles(c *gin.Context) {
+	result, err := services.RolesService.GetRoles(c.Request.Context())
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (r *rolesController) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, roles.Permissions)
+}
+
+func (r *rolesController) CreateRole(c *gin.Context) {
+	var role roles.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	claims, claimsErr := jwt.GetClaims(c)
+	if claimsErr != nil {
+		c.JSON(claimsErr.Status, claimsErr)
+		return
+	}
+
+	result, err := services.RolesService.CreateRole(c.Request.Context(), claims.Role, role)
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (r *rolesController) SetPermissions(c *gin.Context) {
+	var input roles.PermissionsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	claims, claimsErr := jwt.GetClaims(c)
+	if claimsErr != nil {
+		c.JSON(claimsErr.Status, claimsErr)
+		return
+	}
+
+	result, err := services.RolesService.SetPermissions(c.Request.Context(), claims.Role, c.Param("role"), input.Permissions)
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (r *rolesController) DeleteRole(c *gin.Context) {
+	if err := services.RolesService.DeleteRole(c.Request.Context(), c.Param("role")); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
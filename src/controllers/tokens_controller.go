@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/domain/refresh_tokens"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	TokensController tokensControllerInterface = &tokensController{}
+)
+
+type tokensController struct{}
+
+type tokensControllerInterface interface {
+	Refresh(c *gin.Context)
+	Logout(c *gin.Context)
+}
+
+func (t *tokensController) Refresh(c *gin.Context) {
+	var input refresh_tokens.RefreshInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	user, refreshToken, mfa, rotateErr := services.TokensService.RotateRefreshToken(c.Request.Context(), input.RefreshToken)
+	if rotateErr != nil {
+		c.JSON(rotateErr.Status, rotateErr)
+		return
+	}
+
+	respondWithTokens(c, user, refreshToken, mfa)
+}
+
+// Logout revokes the access token of the request and, when one is sent in the
+// body, the whole family of the given refresh token.
+func (t *tokensController) Logout(c *gin.Context) {
+	claims, claimsErr := jwt.GetClaims(c)
+	if claimsErr != nil {
+		c.JSON(claimsErr.Status, claimsErr)
+		return
+	}
+	userId, _ := claims.UserId()
+
+	var input refresh_tokens.LogoutInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			restErr := errors.NewBadRequestError("invalid json body")
+			c.JSON(restErr.Status, restErr)
+			return
+		}
+	}
+
+	if err := services.RevocationsService.RevokeToken(c.Request.Context(), claims.Id, userId, claims.ExpiresAt); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+
+	if input.RefreshToken != "" {
+		if err := services.TokensService.RevokeRefreshToken(c.Request.Context(), userId, input.RefreshToken); err != nil {
+			c.JSON(err.Status, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+}
+
+// respondWithTokens signs a new access token for user and writes it together
+// with the already issued refresh token.
+func respondWithTokens(c *gin.Context, user *users.User, refreshToken string, mfa bool) {
+	token, tokenErr := jwt.GenerateJWT(*user, mfa)
+	if tokenErr != nil {
+		restErr := errors.NewInternalServerError("error when trying to generate access token")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":            user.Id,
+		"token_type":         "Bearer",
+		"expires_in":         int(jwt.AccessTokenTTL().Seconds()),
+		"access_token":       token,
+		"refresh_token":      refreshToken,
+		"refresh_expires_in": int(services.TokensService.RefreshTokenTTL().Seconds()),
+	})
+}
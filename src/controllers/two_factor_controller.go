@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	TwoFactorController twoFactorControllerInterface = &twoFactorController{}
+)
+
+type twoFactorController struct{}
+
+type twoFactorControllerInterface interface {
+	Enroll(c *gin.Context)
+	Confirm(c *gin.Context)
+	Disable(c *gin.Context)
+	CompleteLogin(c *gin.Context)
+}
+
+func (t *twoFactorController) Enroll(c *gin.Context) {
+	userId, idErr := jwt.JWTUserId(c)
+	if idErr != nil {
+		c.JSON(idErr.Status, idErr)
+		return
+	}
+
+	secret, uri, err := services.TwoFactorService.Enroll(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": uri})
+}
+
+func (t *twoFactorController) Confirm(c *gin.Context) {
+	userId, idErr := jwt.JWTUserId(c)
+	if idErr != nil {
+		c.JSON(idErr.Status, idErr)
+		return
+	}
+
+	var input two_factor.CodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	codes, err := services.TwoFactorService.Confirm(c.Request.Context(), userId, input.Code, c.ClientIP())
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+func (t *twoFactorController) Disable(c *gin.Context) {
+	userId, idErr := jwt.JWTUserId(c)
+	if idErr != nil {
+		c.JSON(idErr.Status, idErr)
+		return
+	}
+
+	var input two_factor.VerifyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	if err := services.TwoFactorService.Disable(c.Request.Context(), userId, input.Code, input.RecoveryCode, c.ClientIP()); err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// CompleteLogin trades the challenge returned by Login and a TOTP or recovery
+// code for the access and refresh tokens.
+func (t *twoFactorController) CompleteLogin(c *gin.Context) {
+	var input two_factor.ChallengeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	challenge, challengeErr := jwt.ParseMFAChallenge(input.MfaToken)
+	if challengeErr != nil {
+		c.JSON(challengeErr.Status, challengeErr)
+		return
+	}
+
+	code := two_factor.VerifyInput{Code: input.Code, RecoveryCode: input.RecoveryCode}
+	user, err := services.TwoFactorService.CompleteLogin(c.Request.Context(), *challenge, code, c.ClientIP())
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+
+	refreshToken, refreshErr := services.TokensService.CreateRefreshToken(c.Request.Context(), user.Id, true)
+	if refreshErr != nil {
+		c.JSON(refreshErr.Status, refreshErr)
+		return
+	}
+
+	respondWithTokens(c, user, refreshToken, true)
+}
+
+func respondWithChallenge(c *gin.Context, user *users.User) {
+	token, tokenErr := jwt.GenerateMFAChallenge(*user)
+	if tokenErr != nil {
+		restErr := errors.NewInternalServerError("error when trying to generate mfa token")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      user.Id,
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(jwt.ChallengeTTL.Seconds()),
+	})
+}
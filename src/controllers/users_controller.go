@@ -2,17 +2,16 @@ package controllers
 
 import (
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/amirnep/shop/src/domain/roles"
 	"github.com/amirnep/shop/src/domain/users"
 	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/logger"
 	"github.com/amirnep/shop/src/services"
-	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
 	"github.com/amirnep/shop/src/utils/errors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 var (
@@ -24,6 +23,7 @@ type usersController struct {}
 type usersControllerInterface interface {
 	getUserId(string) (int64, *errors.RestErr)
 	GetUsers(c *gin.Context)
+	ExportUsers(c *gin.Context)
 	Create(c *gin.Context)
 	Get(c *gin.Context)
 	Update(c *gin.Context)
@@ -43,43 +43,117 @@ func (u *usersController) getUserId(userIdParam string) (int64, *errors.RestErr)
 }
 
 func (u *usersController) GetUsers(c *gin.Context) {
-	result, getErr := services.UsersService.GetAll()
+	var query users.ListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		restErr := errors.NewBadRequestError("invalid query parameters")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	result, getErr := services.UsersService.ListUsers(c.Request.Context(), query)
 	if getErr != nil {
 		c.JSON(getErr.Status, getErr)
 		return
 	}
-	c.JSON(http.StatusOK, result)
+	c.JSON(http.StatusOK, result.Marshall(c.GetHeader("X-Public") == "true"))
 }
 
-func (u *usersController) Create(c *gin.Context) {
-	var user *users.User
-	if err := c.ShouldBind(&user); err != nil {
-		restErr := *errors.NewBadRequestError("invalid json body")
+// ExportUsers streams every user matching the list filters as NDJSON or CSV.
+// Rows are written as they are read, so the export runs in constant memory.
+// Once streaming started the status cannot change anymore, a failure is
+// reported in the X-Export-Error trailer instead.
+func (u *usersController) ExportUsers(c *gin.Context) {
+	var query users.ListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		restErr := errors.NewBadRequestError("invalid query parameters")
 		c.JSON(restErr.Status, restErr)
 		return
 	}
 
-	file := c.Request.MultipartForm.File["Image"][0]
-
-	if file.Size > 3<<20 {
-		restErr := *errors.NewBadRequestError("image size must less then 3mb")
+	format := c.DefaultQuery("format", exportFormatNDJSON)
+	var writer exportWriter
+	switch format {
+	case exportFormatNDJSON:
+		writer = newNDJSONExportWriter(c.Writer)
+	case exportFormatCSV:
+		writer = newCSVExportWriter(c.Writer)
+	default:
+		restErr := errors.NewBadRequestError("format must be ndjson or csv")
 		c.JSON(restErr.Status, restErr)
 		return
 	}
 
-	uniqueId := uuid.New().String()
-	dst := "wwwroot/" + filepath.Base(uniqueId + ".jpg")
+	it, err := services.UsersService.ExportUsers(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(err.Status, err)
+		return
+	}
+	defer it.Close()
+
+	c.Header("Content-Type", writer.contentType())
+	c.Header("Content-Disposition", `attachment; filename="users.`+format+`"`)
+	c.Header("Trailer", exportErrorTrailer)
+	c.Status(http.StatusOK)
+
+	// Not every ResponseWriter supports deadlines, the server's write
+	// timeout applies unchanged then.
+	deadline := http.NewResponseController(c.Writer)
+	deadline.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+	for count := 1; it.Next(); count++ {
+		if writeErr := writer.write(it.User()); writeErr != nil {
+			exportFailed(c, writeErr)
+			return
+		}
+		if count%exportFlushRows == 0 {
+			if flushErr := writer.flush(); flushErr != nil {
+				exportFailed(c, flushErr)
+				return
+			}
+			c.Writer.Flush()
+			deadline.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		}
+	}
+	if flushErr := writer.flush(); flushErr != nil {
+		exportFailed(c, flushErr)
+		return
+	}
 
-	if err := c.SaveUploadedFile(file, dst); err != nil {
-		restErr := *errors.NewBadRequestError("error in uploading and saving file")
+	if err := it.Err(); err != nil {
+		c.Writer.Header().Set(exportErrorTrailer, err.Message)
+	}
+}
+
+// exportFailed reports an error writing the export in the trailer, in case
+// the client still receives it.
+func exportFailed(c *gin.Context, err error) {
+	logger.ErrorContext(c.Request.Context(), "error when trying to write user export", err)
+	c.Writer.Header().Set(exportErrorTrailer, "error when trying to write user export")
+}
+
+func (u *usersController) Create(c *gin.Context) {
+	var user *users.User
+	limitUploadBody(c)
+	if err := c.ShouldBind(&user); err != nil {
+		restErr := *errors.NewBadRequestError("invalid json body")
 		c.JSON(restErr.Status, restErr)
 		return
 	}
 
-	user.ImageUrl = "src/wwwroot/" + uniqueId + ".jpg"
+	// The image URL is set by the images service, never by the client.
+	user.ImageUrl = ""
+	if image := uploadedImage(c); image != nil {
+		imageUrl, imageErr := services.ImagesService.SaveUpload(c.Request.Context(), image)
+		if imageErr != nil {
+			c.JSON(imageErr.Status, imageErr)
+			return
+		}
+		user.ImageUrl = imageUrl
+	}
 
-	result, saveErr := services.UsersService.CreateUser(user)
+	result, saveErr := services.UsersService.CreateUser(c.Request.Context(), user)
 	if saveErr != nil {
+		services.ImagesService.DeleteImage(c.Request.Context(), user.ImageUrl)
 		c.JSON(http.StatusBadRequest,saveErr)
 		return
 	}
@@ -93,7 +167,7 @@ func (u *usersController) Get(c *gin.Context) {
 		return
 	}
 
-	result, getErr := services.UsersService.GetUser(userId)
+	result, getErr := services.UsersService.GetUser(c.Request.Context(), userId)
 	if getErr != nil {
 		c.JSON(getErr.Status, getErr)
 		return
@@ -108,38 +182,25 @@ func (u *usersController) Update(c *gin.Context) {
 		return
 	}
 
-	var user users.User
-
-	if err := c.ShouldBind(&user); err != nil {
-		restErr := errors.NewBadRequestError("invalid json body")
-		c.JSON(restErr.Status, restErr)
-		return
-	}
-
-	file := c.Request.MultipartForm.File["Image"][0]
-
-	if file.Size > 3<<20 {
-		restErr := *errors.NewBadRequestError("image size must less then 3mb")
-		c.JSON(restErr.Status, restErr)
+	document, image, readErr := readProfilePatch(c, c.Request.Method == http.MethodPut)
+	if readErr != nil {
+		c.JSON(readErr.Status, readErr)
 		return
 	}
 
-	uniqueId := uuid.New().String()
-	dst := "wwwroot/" + filepath.Base(uniqueId + ".jpg")
-
-	if err := c.SaveUploadedFile(file, dst); err != nil {
-		restErr := *errors.NewBadRequestError("error in uploading and saving file")
-		c.JSON(restErr.Status, restErr)
-		return
+	patch := users.ProfilePatch{Document: document}
+	if image != nil {
+		imageUrl, imageErr := services.ImagesService.SaveUpload(c.Request.Context(), image)
+		if imageErr != nil {
+			c.JSON(imageErr.Status, imageErr)
+			return
+		}
+		patch.ImageUrl = imageUrl
 	}
 
-	user.Id = userId
-	user.ImageUrl = "src/wwwroot/" + uniqueId + ".jpg"
-
-	isPartial := c.Request.Method == http.MethodPatch
-
-	result, err := services.UsersService.UpdateUser(isPartial, user)
+	result, err := services.UsersService.UpdateUser(c.Request.Context(), userId, patch)
 	if err != nil {
+		services.ImagesService.DeleteImage(c.Request.Context(), patch.ImageUrl)
 		c.JSON(err.Status, err)
 		return
 	}
@@ -153,7 +214,7 @@ func (u *usersController) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := services.UsersService.DeleteUser(userId); err != nil {
+	if err := services.UsersService.DeleteUser(c.Request.Context(), userId); err != nil {
 		c.JSON(err.Status, err)
 		return
 	}
@@ -162,33 +223,35 @@ func (u *usersController) Delete(c *gin.Context) {
 
 func (u *usersController) Login(c *gin.Context){
 	input := users.LoginInput{}
-	tokenTTL, _ := strconv.Atoi(os.Getenv("TOKEN_TTL"))
 
 	if inputErr := c.ShouldBindJSON(&input); inputErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": inputErr.Error()})
 		return
 	}
 
-	result, loginErr := services.UsersService.Login(input.Email)
+	result, loginErr := services.UsersService.Login(c.Request.Context(), input, c.ClientIP())
 	if loginErr!= nil {
         c.JSON(loginErr.Status, loginErr)
 		return
 	}
 
-	inputPassword := crypto_utils.GetMd5(input.Password)
-	if inputPassword != result.Password{
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password is incorrect"})
+	twoFactorEnabled, twoFactorErr := services.TwoFactorService.IsEnabled(c.Request.Context(), result.Id)
+	if twoFactorErr != nil {
+		c.JSON(twoFactorErr.Status, twoFactorErr)
+		return
+	}
+	if twoFactorEnabled {
+		respondWithChallenge(c, result)
 		return
 	}
 
-	token, tokenErr := jwt.GenerateJWT(*result)
-
-	if tokenErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "username or password is incorrect."})
+	refreshToken, refreshErr := services.TokensService.CreateRefreshToken(c.Request.Context(), result.Id, false)
+	if refreshErr != nil {
+		c.JSON(refreshErr.Status, refreshErr)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user_id": result.Id, "token_type": "Bearer", "expires_in": tokenTTL,"access_token": token})
+	respondWithTokens(c, result, refreshToken, false)
 }
 
 func (u *usersController) GetProfile(c *gin.Context) {
@@ -197,7 +260,7 @@ func (u *usersController) GetProfile(c *gin.Context) {
 		c.JSON(idErr.Status, userId)
 		return
 	}
-	result, getErr := services.UsersService.GetUser(userId)
+	result, getErr := services.UsersService.GetUser(c.Request.Context(), userId)
 	if getErr != nil {
 		c.JSON(getErr.Status, getErr)
 		return
@@ -206,18 +269,31 @@ func (u *usersController) GetProfile(c *gin.Context) {
 }
 
 func (u *usersController) UpdateRole(c *gin.Context) {
+	callerId, callerErr := jwt.JWTUserId(c)
+	if callerErr != nil {
+		c.JSON(callerErr.Status, callerErr)
+		return
+	}
+
 	userId, idErr := UsersController.getUserId(c.Param("user_id"))
 	if idErr != nil {
 		c.JSON(idErr.Status, idErr)
 		return
 	}
 
-	result := services.UsersService.EditRole(userId)
+	var input roles.RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		restErr := errors.NewBadRequestError("invalid json body")
+		c.JSON(restErr.Status, restErr)
+		return
+	}
+
+	result := services.UsersService.EditRole(c.Request.Context(), callerId, userId, input.Role)
 	if result != nil {
-		c.JSON(http.StatusBadRequest, result)
+		c.JSON(result.Status, result)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Role edited to Admin successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Role edited to " + input.Role + " successfully"})
 }
 
 func (u *usersController) ChangePassword(c *gin.Context) {
@@ -237,7 +313,7 @@ func (u *usersController) ChangePassword(c *gin.Context) {
 
 	user.Id = userId
 
-	result := services.UsersService.EditPassword(userId, user)
+	result := services.UsersService.EditPassword(c.Request.Context(), userId, user)
 	if result != nil {
 		c.JSON(result.Status, result)
 		return
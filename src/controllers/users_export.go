@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/users"
+)
+
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+	exportErrorTrailer = "X-Export-Error"
+	// exportFlushRows is how many rows are buffered before they are pushed to
+	// the client.
+	exportFlushRows = 500
+	// exportWriteTimeout is how long the client may take to accept the next
+	// batch of rows. The deadline is pushed back on every flush, so a large
+	// export is not cut off by the server's write timeout.
+	exportWriteTimeout = 30 * time.Second
+)
+
+// exportWriter encodes users for ExportUsers. Exports always contain the
+// private view of a user.
+type exportWriter interface {
+	contentType() string
+	write(users.User) error
+	// flush pushes buffered rows to the underlying writer and reports the
+	// first error any write or flush ran into.
+	flush() error
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONExportWriter(w io.Writer) exportWriter {
+	return &ndjsonExportWriter{encoder: json.NewEncoder(w)}
+}
+
+func (w *ndjsonExportWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (w *ndjsonExportWriter) write(user users.User) error {
+	return w.encoder.Encode(user.Marshall(false))
+}
+
+func (w *ndjsonExportWriter) flush() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+var csvExportHeader = []string{"id", "first_name", "last_name", "email", "role", "date_created", "image_url", "email_verified"}
+
+func newCSVExportWriter(w io.Writer) exportWriter {
+	return &csvExportWriter{writer: csv.NewWriter(w)}
+}
+
+func (w *csvExportWriter) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (w *csvExportWriter) write(user users.User) error {
+	if !w.headerWritten {
+		if err := w.writer.Write(csvExportHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	return w.writer.Write([]string{
+		strconv.FormatInt(user.Id, 10),
+		csvCell(user.FirstName),
+		csvCell(user.LastName),
+		csvCell(user.Email),
+		csvCell(user.Role),
+		user.DateCreated,
+		csvCell(user.ImageUrl),
+		strconv.FormatBool(user.EmailVerified),
+	})
+}
+
+// flush also writes the header of an empty export.
+func (w *csvExportWriter) flush() error {
+	if !w.headerWritten {
+		w.writer.Write(csvExportHeader)
+		w.headerWritten = true
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// csvCell keeps spreadsheets from evaluating value as a formula when the
+// export is opened, by prefixing the characters that start one with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,400 @@
+// Package migrations versions the MySQL schema. Migrations are SQL files
+// embedded in the binary, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, and the applied versions are recorded in the
+// schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	lockName    = "shop_users_schema_migrations"
+	lockTimeout = 60
+	// baselineVersion creates the users table, reverting it drops every
+	// account.
+	baselineVersion = 1
+	// mysqlNoSuchTable is returned before the first migration created
+	// schema_migrations.
+	mysqlNoSuchTable = 1146
+
+	queryCreateTable   = "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL, name VARCHAR(255) NOT NULL, dirty TINYINT(1) NOT NULL DEFAULT 0, applied_at DATETIME NOT NULL, PRIMARY KEY (version)) ENGINE=InnoDB;"
+	queryGetApplied    = "SELECT version, name, dirty, applied_at FROM schema_migrations ORDER BY version;"
+	queryInsertApplied = "INSERT INTO schema_migrations(version, name, dirty, applied_at) VALUES (?,?,1,?);"
+	queryGetVersion    = "SELECT version, dirty FROM schema_migrations ORDER BY version DESC LIMIT 1;"
+	queryMarkClean     = "UPDATE schema_migrations SET dirty=0 WHERE version = ?;"
+	queryMarkDirty     = "UPDATE schema_migrations SET dirty=1 WHERE version = ?;"
+	queryDeleteApplied = "DELETE FROM schema_migrations WHERE version = ?;"
+	queryForgetAbove   = "DELETE FROM schema_migrations WHERE version > ?;"
+	queryForceVersion  = "INSERT INTO schema_migrations(version, name, dirty, applied_at) VALUES (?,?,0,?) ON DUPLICATE KEY UPDATE dirty = 0;"
+	queryGetLock       = "SELECT GET_LOCK(?, ?);"
+	queryReleaseLock   = "SELECT RELEASE_LOCK(?);"
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration together with whether, and when, it was applied.
+type Status struct {
+	Migration
+	Applied   bool
+	Dirty     bool
+	AppliedAt string
+}
+
+// Load returns the embedded migrations ordered by version. Every migration
+// must have both an up and a down file.
+func Load() ([]Migration, error) {
+	names, err := fs.Glob(files, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range names {
+		base := path.Base(file)
+		direction := ""
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither .up.sql nor .down.sql", base)
+		}
+
+		versionPart, name, found := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		version, parseErr := strconv.ParseInt(versionPart, 10, 64)
+		if !found || parseErr != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a version", base)
+		}
+
+		content, readErr := files.ReadFile(file)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// Latest returns the version of the newest embedded migration.
+func Latest() (int64, error) {
+	all, err := Load()
+	if err != nil || len(all) == 0 {
+		return 0, err
+	}
+	return all[len(all)-1].Version, nil
+}
+
+// Supports reports whether this binary can run against a schema at current.
+// A newer clean schema is fine, so a rolling deploy can migrate ahead of the
+// old replicas; an older or dirty one is not.
+func Supports(current int64, dirty bool) error {
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+	if dirty || current < latest {
+		return fmt.Errorf("schema is at version %d (dirty: %t) but %d is required", current, dirty, latest)
+	}
+	return nil
+}
+
+// Statements splits a migration into the statements it runs one by one. A
+// statement ends with a semicolon at the end of a line, lines starting with
+// -- are comments.
+func Statements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: all}, nil
+}
+
+// Version returns the newest applied version and whether it is dirty, which
+// means it failed halfway and the schema has to be repaired by hand. It only
+// reads, so it is safe for probes; a database that was never migrated is at
+// version 0.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	return version(ctx, conn)
+}
+
+// Up applies every pending migration up to and including target, or all of
+// them when target is 0.
+func (m *Migrator) Up(ctx context.Context, target int64) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := m.checkClean(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current || (target > 0 && migration.Version > target) {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the newest steps applied migrations. Reverting the baseline
+// migration is refused unless allowDrop is set, since it drops the users
+// table.
+func (m *Migrator) Down(ctx context.Context, steps int, allowDrop bool) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := m.checkClean(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		var reverted []Migration
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			if m.migrations[i].Version <= current {
+				reverted = append(reverted, m.migrations[i])
+			}
+		}
+		if len(reverted) > 0 && reverted[len(reverted)-1].Version == baselineVersion && !allowDrop {
+			return fmt.Errorf("reverting migration %d drops the users table with every account, pass --allow-drop to do it anyway", baselineVersion)
+		}
+
+		for _, migration := range reverted {
+			if err := m.apply(ctx, conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Force records version as the clean current version without running any
+// SQL, 0 forgets every migration. It is meant to recover from a dirty
+// migration once the schema has been repaired by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	name := ""
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			name = migration.Name
+		}
+	}
+	if version != 0 && name == "" {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, queryForgetAbove, version); err != nil || version == 0 {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, queryForceVersion, version, name, date_utils.GetNowDBFormat())
+		return err
+	})
+}
+
+// Status lists every embedded migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedStatuses(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := applied[migration.Version]
+		status.Migration = migration
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// locked runs fn on a single connection holding a named MySQL lock, so
+// replicas starting at the same time do not migrate concurrently.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, queryGetLock, lockName, lockTimeout).Scan(&acquired); err != nil {
+		return err
+	}
+	if acquired.Int64 != 1 {
+		return fmt.Errorf("timed out after %ds waiting for the migration lock", lockTimeout)
+	}
+	defer conn.ExecContext(context.Background(), queryReleaseLock, lockName)
+
+	if _, err := conn.ExecContext(ctx, queryCreateTable); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) checkClean(ctx context.Context, conn *sql.Conn) (int64, error) {
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("migration %d is dirty, repair the schema and run migrate force <version>", current)
+	}
+	return current, nil
+}
+
+// apply runs one direction of a migration. MySQL commits DDL implicitly, so
+// instead of a transaction the version is marked dirty while it runs.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+		if _, err := conn.ExecContext(ctx, queryInsertApplied, migration.Version, migration.Name, date_utils.GetNowDBFormat()); err != nil {
+			return err
+		}
+	}
+
+	started := time.Now()
+	for _, statement := range Statements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			if !up {
+				// Leave a trace that the down migration broke off.
+				conn.ExecContext(ctx, queryMarkDirty, migration.Version)
+			}
+			return fmt.Errorf("migration %d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+		}
+	}
+
+	query := queryMarkClean
+	if !up {
+		query = queryDeleteApplied
+	}
+	if _, err := conn.ExecContext(ctx, query, migration.Version); err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("migration %d_%s %s applied in %s", migration.Version, migration.Name, direction, time.Since(started).Round(time.Millisecond)))
+	return nil
+}
+
+func version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var current int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, queryGetVersion).Scan(&current, &dirty)
+	if err == sql.ErrNoRows || noSuchTable(err) {
+		return 0, false, nil
+	}
+	return current, dirty, err
+}
+
+// appliedStatuses returns the recorded migrations by version, none when
+// schema_migrations does not exist yet.
+func appliedStatuses(ctx context.Context, conn *sql.Conn) (map[int64]Status, error) {
+	applied := make(map[int64]Status)
+	rows, err := conn.QueryContext(ctx, queryGetApplied)
+	if noSuchTable(err) {
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status Status
+		if err := rows.Scan(&status.Version, &status.Name, &status.Dirty, &status.AppliedAt); err != nil {
+			return nil, err
+		}
+		status.Applied = true
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+// noSuchTable reports whether err says schema_migrations does not exist yet.
+// Only Up, Down and Force create it, under the migration lock.
+func noSuchTable(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == mysqlNoSuchTable
+}
@@ -0,0 +1,15 @@
+-- Baseline schema of the users table. IF NOT EXISTS lets databases created
+-- before migrations existed adopt this version.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    first_name VARCHAR(100) NOT NULL DEFAULT '',
+    last_name VARCHAR(100) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    confirm_password VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
+    date_created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    image_url VARCHAR(512) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE KEY users_email_unique (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
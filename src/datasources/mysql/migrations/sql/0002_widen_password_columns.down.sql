@@ -0,0 +1,2 @@
+-- Narrowing the columns again would truncate the stored hashes.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- argon2id and bcrypt hashes do not fit the 32 characters of an MD5 digest.
+ALTER TABLE users
+    MODIFY password VARCHAR(255) NOT NULL,
+    MODIFY confirm_password VARCHAR(255) NOT NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE refresh_tokens (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    user_id BIGINT NOT NULL,
+    family_id CHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used TINYINT(1) NOT NULL DEFAULT 0,
+    revoked TINYINT(1) NOT NULL DEFAULT 0,
+    mfa TINYINT(1) NOT NULL DEFAULT 0,
+    date_created DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY refresh_tokens_hash_unique (token_hash),
+    KEY refresh_tokens_family (family_id),
+    KEY refresh_tokens_user (user_id),
+    CONSTRAINT refresh_tokens_user_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
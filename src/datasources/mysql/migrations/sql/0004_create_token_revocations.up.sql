@@ -0,0 +1,15 @@
+-- Revocations have no foreign key on users, they must outlive deleted users
+-- until the revoked tokens expire.
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) NOT NULL,
+    user_id BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (jti),
+    KEY revoked_tokens_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE user_token_revocations (
+    user_id BIGINT NOT NULL,
+    revoked_before BIGINT NOT NULL,
+    PRIMARY KEY (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,12 @@
+CREATE TABLE password_resets (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    user_id BIGINT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used TINYINT(1) NOT NULL DEFAULT 0,
+    date_created DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY password_resets_hash_unique (token_hash),
+    KEY password_resets_user (user_id),
+    CONSTRAINT password_resets_user_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN verification_sent_at,
+    DROP COLUMN email_verified;
@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN email_verified TINYINT(1) NOT NULL DEFAULT 0,
+    ADD COLUMN verification_sent_at DATETIME NULL;
+
+-- Accounts created before verification existed are trusted, otherwise
+-- REQUIRE_VERIFIED_EMAIL would lock all of them out.
+UPDATE users SET email_verified = 1;
@@ -0,0 +1,18 @@
+CREATE TABLE user_two_factor (
+    user_id BIGINT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    enabled TINYINT(1) NOT NULL DEFAULT 0,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    date_created DATETIME NOT NULL,
+    PRIMARY KEY (user_id),
+    CONSTRAINT user_two_factor_user_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE totp_recovery_codes (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    user_id BIGINT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (id),
+    KEY totp_recovery_codes_user_code (user_id, code_hash),
+    CONSTRAINT totp_recovery_codes_user_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
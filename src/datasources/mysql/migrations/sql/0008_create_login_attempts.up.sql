@@ -0,0 +1,10 @@
+-- Times are unix seconds.
+CREATE TABLE login_attempts (
+    kind VARCHAR(16) NOT NULL,
+    attempt_key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    locked_until BIGINT NOT NULL DEFAULT 0,
+    last_failure BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, attempt_key),
+    KEY login_attempts_locked_until (locked_until)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,28 @@
+CREATE TABLE roles (
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE role_permissions (
+    role VARCHAR(50) NOT NULL,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role, permission),
+    CONSTRAINT role_permissions_role_fk FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to user, role and lockout management'),
+    ('user', 'Regular customer'),
+    ('support', 'Read-only access for customer support');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:read'),
+    ('admin', 'users:delete'),
+    ('admin', 'users:assign_role'),
+    ('admin', 'roles:read'),
+    ('admin', 'roles:manage'),
+    ('admin', 'lockouts:read'),
+    ('admin', 'lockouts:clear'),
+    ('support', 'users:read'),
+    ('support', 'lockouts:read');
@@ -0,0 +1,4 @@
+DROP INDEX users_last_name ON users;
+DROP INDEX users_first_name ON users;
+DROP INDEX users_date_created_id ON users;
+DROP INDEX users_role_id ON users;
@@ -0,0 +1,6 @@
+-- Every column the admin user list can be sorted by is indexed, with the id
+-- as tie breaker for keyset pagination.
+CREATE INDEX users_role_id ON users (role, id);
+CREATE INDEX users_date_created_id ON users (date_created, id);
+CREATE INDEX users_first_name ON users (first_name);
+CREATE INDEX users_last_name ON users (last_name);
@@ -0,0 +1 @@
+DROP INDEX users_image_url ON users;
@@ -0,0 +1,2 @@
+-- The image sweeper looks up every stored image by its URL.
+CREATE INDEX users_image_url ON users (image_url);
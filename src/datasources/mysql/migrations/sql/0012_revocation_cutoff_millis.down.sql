@@ -0,0 +1 @@
+UPDATE user_token_revocations SET revoked_before = (revoked_before - 1) DIV 1000;
@@ -0,0 +1,4 @@
+-- user_token_revocations.revoked_before moves from unix seconds to unix
+-- milliseconds. Existing cutoffs keep revoking every token issued in their
+-- second.
+UPDATE user_token_revocations SET revoked_before = (revoked_before + 1) * 1000;
@@ -3,37 +3,46 @@ package users_db
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
 
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
+	"github.com/amirnep/shop/src/config"
+	"github.com/go-sql-driver/mysql"
 )
 
 var (
 	Client *sql.DB
 )
 
-func init() {
-	enverr := godotenv.Load()
-	if enverr != nil {
-    	log.Fatal("Error loading .env file")
+// Init connects Client. It is called by the application at startup instead of
+// on import, so packages that only need the domain types can be used without
+// a database.
+func Init(cfg config.Database) error {
+	dsn := mysql.NewConfig()
+	dsn.User = cfg.Username
+	dsn.Passwd = cfg.Password
+	dsn.Net = "tcp"
+	dsn.Addr = cfg.Host
+	dsn.DBName = cfg.Schema
+	dsn.Params = map[string]string{"charset": "utf8mb4"}
+
+	client, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return err
 	}
 
-	username := os.Getenv("mysql_users_username")
-	password := os.Getenv("mysql_users_password")
-	host := os.Getenv("mysql_users_host")
-	schema := os.Getenv("mysql_users_schema")
-
-	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8", username, password, host, schema)
-	var err error
-	Client , err = sql.Open("mysql", dataSourceName)
-
-	if err != nil {
-		panic(err)
+	if err := client.Ping(); err != nil {
+		client.Close()
+		return fmt.Errorf("connecting to mysql at %s: %w", cfg.Host, err)
 	}
 
-	if err = Client.Ping(); err != nil {
-		panic(err)
+	Client = client
+	return nil
+}
+
+// Close waits for the queries in flight and closes the pool. It is safe to
+// call when Init never succeeded.
+func Close() error {
+	if Client == nil {
+		return nil
 	}
-}
\ No newline at end of file
+	return Client.Close()
+}
@@ -0,0 +1,128 @@
+package login_attempts
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryGetAttempt = "SELECT kind, attempt_key, failures, locked_until, last_failure FROM login_attempts WHERE kind = ? AND attempt_key = ?;"
+
+	queryRegisterFailure = "INSERT INTO login_attempts(kind, attempt_key, failures, locked_until, last_failure) VALUES (?,?,1,0,?) ON DUPLICATE KEY UPDATE failures = IF(last_failure < ?, 1, failures + 1), last_failure = VALUES(last_failure);"
+
+	querySetLockedUntil = "UPDATE login_attempts SET locked_until=? WHERE kind = ? AND attempt_key = ?;"
+
+	queryDeleteAttempt = "DELETE FROM login_attempts WHERE kind = ? AND attempt_key = ?;"
+
+	queryGetLockedAttempts = "SELECT kind, attempt_key, failures, locked_until, last_failure FROM login_attempts WHERE locked_until > ? ORDER BY locked_until DESC;"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a LoginAttemptRepository backed by the
+// login_attempts table.
+func NewMySQLRepository(db *sql.DB) LoginAttemptRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) Get(ctx context.Context, kind string, key string) (*LoginAttempt, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetAttempt)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get login attempt statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	var attempt LoginAttempt
+	result := stmt.QueryRowContext(ctx, kind, key)
+	if getErr := result.Scan(&attempt.Kind, &attempt.Key, &attempt.Failures, &attempt.LockedUntil, &attempt.LastFailure); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, noAttemptsError()
+		}
+		logger.ErrorContext(ctx, "error when trying to get login attempt", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return &attempt, nil
+}
+
+func (r *mysqlRepository) RegisterFailure(ctx context.Context, kind string, key string, now int64, resetBefore int64) (*LoginAttempt, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryRegisterFailure)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare register login failure statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, saveErr := stmt.ExecContext(ctx, kind, key, now, resetBefore); saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to register login failure", saveErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return r.Get(ctx, kind, key)
+}
+
+func (r *mysqlRepository) SetLockedUntil(ctx context.Context, kind string, key string, lockedUntil int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, querySetLockedUntil)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare lock login attempt statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, updateErr := stmt.ExecContext(ctx, lockedUntil, kind, key); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to lock login attempt", updateErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) Delete(ctx context.Context, kind string, key string) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteAttempt)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare delete login attempt statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, deleteErr := stmt.ExecContext(ctx, kind, key); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete login attempt", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) GetLocked(ctx context.Context, now int64) ([]LoginAttempt, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetLockedAttempts)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get locked login attempts statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	rows, queryErr := stmt.QueryContext(ctx, now)
+	if queryErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get locked login attempts", queryErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer rows.Close()
+
+	result := make([]LoginAttempt, 0)
+	for rows.Next() {
+		var current LoginAttempt
+		if scanErr := rows.Scan(&current.Kind, &current.Key, &current.Failures, &current.LockedUntil, &current.LastFailure); scanErr != nil {
+			logger.ErrorContext(ctx, "error when trying to scan login attempt", scanErr)
+			return nil, errors.NewInternalServerError("database error")
+		}
+		result = append(result, current)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		logger.ErrorContext(ctx, "error when trying to iterate login attempts", rowsErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return result, nil
+}
@@ -0,0 +1,16 @@
+package login_attempts
+
+const (
+	KindAccount = "account"
+	KindIp      = "ip"
+)
+
+// LoginAttempt counts consecutive failed logins for an email address or a
+// client IP. Times are unix seconds.
+type LoginAttempt struct {
+	Kind        string `json:"kind"`
+	Key         string `json:"key"`
+	Failures    int    `json:"failures"`
+	LockedUntil int64  `json:"locked_until"`
+	LastFailure int64  `json:"last_failure"`
+}
@@ -0,0 +1,85 @@
+package login_attempts
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+type attemptKey struct {
+	kind string
+	key  string
+}
+
+// memoryRepository keeps the failed login counters in a map, following the
+// semantics of the MySQL repository.
+type memoryRepository struct {
+	mu       sync.Mutex
+	attempts map[attemptKey]LoginAttempt
+}
+
+// NewMemoryRepository returns an empty LoginAttemptRepository that lives in
+// memory.
+func NewMemoryRepository() LoginAttemptRepository {
+	return &memoryRepository{attempts: make(map[attemptKey]LoginAttempt)}
+}
+
+func (r *memoryRepository) Get(ctx context.Context, kind string, key string) (*LoginAttempt, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[attemptKey{kind, key}]
+	if !ok {
+		return nil, noAttemptsError()
+	}
+	return &attempt, nil
+}
+
+func (r *memoryRepository) RegisterFailure(ctx context.Context, kind string, key string, now int64, resetBefore int64) (*LoginAttempt, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[attemptKey{kind, key}]
+	if !ok || attempt.LastFailure < resetBefore {
+		attempt = LoginAttempt{Kind: kind, Key: key, LockedUntil: attempt.LockedUntil}
+	}
+	attempt.Failures++
+	attempt.LastFailure = now
+	r.attempts[attemptKey{kind, key}] = attempt
+	return &attempt, nil
+}
+
+func (r *memoryRepository) SetLockedUntil(ctx context.Context, kind string, key string, lockedUntil int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attempt, ok := r.attempts[attemptKey{kind, key}]; ok {
+		attempt.LockedUntil = lockedUntil
+		r.attempts[attemptKey{kind, key}] = attempt
+	}
+	return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, kind string, key string) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, attemptKey{kind, key})
+	return nil
+}
+
+func (r *memoryRepository) GetLocked(ctx context.Context, now int64) ([]LoginAttempt, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]LoginAttempt, 0)
+	for _, attempt := range r.attempts {
+		if attempt.LockedUntil > now {
+			result = append(result, attempt)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LockedUntil > result[j].LockedUntil })
+	return result, nil
+}
@@ -0,0 +1,26 @@
+package login_attempts
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// LoginAttemptRepository stores the failed login counters by kind and key.
+type LoginAttemptRepository interface {
+	// Get returns a not found error when the key has no failed logins.
+	Get(context.Context, string, string) (*LoginAttempt, *errors.RestErr)
+	// RegisterFailure increments the failure counter at now, starting over
+	// when the previous failure happened before resetBefore, and returns the
+	// updated entry.
+	RegisterFailure(ctx context.Context, kind string, key string, now int64, resetBefore int64) (*LoginAttempt, *errors.RestErr)
+	SetLockedUntil(ctx context.Context, kind string, key string, lockedUntil int64) *errors.RestErr
+	Delete(context.Context, string, string) *errors.RestErr
+	// GetLocked returns the entries locked after now, the longest locked
+	// first.
+	GetLocked(context.Context, int64) ([]LoginAttempt, *errors.RestErr)
+}
+
+func noAttemptsError() *errors.RestErr {
+	return errors.NewNotFoundError("no failed login attempts")
+}
@@ -0,0 +1,110 @@
+package password_resets
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryInsertReset = "INSERT INTO password_resets(user_id, token_hash, expires_at, date_created) VALUES (?,?,?,?);"
+
+	queryGetResetByHash = "SELECT id, user_id, expires_at, used, date_created FROM password_resets WHERE token_hash = ?;"
+
+	queryMarkResetUsed = "UPDATE password_resets SET used=1 WHERE id = ? AND used=0;"
+
+	queryInvalidateUserResets = "UPDATE password_resets SET used=1 WHERE user_id = ? AND used=0;"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a PasswordResetRepository backed by the
+// password_resets table.
+func NewMySQLRepository(db *sql.DB) PasswordResetRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) Save(ctx context.Context, reset *PasswordReset) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryInsertReset)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save password reset statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	insertResult, saveErr := stmt.ExecContext(ctx, reset.UserId, reset.TokenHash, reset.ExpiresAt, reset.DateCreated)
+	if saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save password reset", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	resetId, insertErr := insertResult.LastInsertId()
+	if insertErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get last insert id after creating a password reset", insertErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	reset.Id = resetId
+	return nil
+}
+
+func (r *mysqlRepository) GetByHash(ctx context.Context, tokenHash string) (*PasswordReset, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetResetByHash)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get password reset statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	reset := &PasswordReset{TokenHash: tokenHash}
+	result := stmt.QueryRowContext(ctx, tokenHash)
+	if getErr := result.Scan(&reset.Id, &reset.UserId, &reset.ExpiresAt, &reset.Used, &reset.DateCreated); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, invalidResetError()
+		}
+		logger.ErrorContext(ctx, "error when trying to get password reset by hash", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return reset, nil
+}
+
+func (r *mysqlRepository) MarkUsed(ctx context.Context, resetId int64) (bool, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryMarkResetUsed)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare mark password reset statement", err)
+		return false, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	updateResult, updateErr := stmt.ExecContext(ctx, resetId)
+	if updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to mark password reset as used", updateErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after marking password reset", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
+}
+
+func (r *mysqlRepository) InvalidateForUser(ctx context.Context, userId int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryInvalidateUserResets)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare invalidate password resets statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, updateErr := stmt.ExecContext(ctx, userId); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to invalidate password resets", updateErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
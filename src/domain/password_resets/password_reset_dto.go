@@ -0,0 +1,20 @@
+package password_resets
+
+type PasswordReset struct {
+	Id          int64  `json:"id"`
+	UserId      int64  `json:"user_id"`
+	TokenHash   string `json:"-"`
+	ExpiresAt   string `json:"expires_at"`
+	Used        bool   `json:"used"`
+	DateCreated string `json:"date_created"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordInput struct {
+	Token           string `json:"token" binding:"required"`
+	Password        string `json:"password" binding:"required"`
+	ConfirmPassword string `json:"confirm_password" binding:"required"`
+}
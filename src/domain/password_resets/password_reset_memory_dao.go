@@ -0,0 +1,70 @@
+package password_resets
+
+import (
+	"context"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps password resets in a map, following the semantics
+// of the MySQL repository.
+type memoryRepository struct {
+	mu     sync.Mutex
+	lastId int64
+	resets map[int64]PasswordReset
+}
+
+// NewMemoryRepository returns an empty PasswordResetRepository that lives in
+// memory.
+func NewMemoryRepository() PasswordResetRepository {
+	return &memoryRepository{resets: make(map[int64]PasswordReset)}
+}
+
+func (r *memoryRepository) Save(ctx context.Context, reset *PasswordReset) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	reset.Id = r.lastId
+	r.resets[reset.Id] = *reset
+	return nil
+}
+
+func (r *memoryRepository) GetByHash(ctx context.Context, tokenHash string) (*PasswordReset, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reset := range r.resets {
+		if reset.TokenHash == tokenHash {
+			return &reset, nil
+		}
+	}
+	return nil, invalidResetError()
+}
+
+func (r *memoryRepository) MarkUsed(ctx context.Context, resetId int64) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.resets[resetId]
+	if !ok || reset.Used {
+		return false, nil
+	}
+	reset.Used = true
+	r.resets[resetId] = reset
+	return true, nil
+}
+
+func (r *memoryRepository) InvalidateForUser(ctx context.Context, userId int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, reset := range r.resets {
+		if reset.UserId == userId {
+			reset.Used = true
+			r.resets[id] = reset
+		}
+	}
+	return nil
+}
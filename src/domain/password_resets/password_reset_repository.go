@@ -0,0 +1,25 @@
+package password_resets
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// PasswordResetRepository stores password resets by the hash of the token
+// sent to the user.
+type PasswordResetRepository interface {
+	// Save stores a new reset and sets its id.
+	Save(context.Context, *PasswordReset) *errors.RestErr
+	// GetByHash returns a bad request error when no reset has the hash.
+	GetByHash(context.Context, string) (*PasswordReset, *errors.RestErr)
+	// MarkUsed consumes the reset. It reports false when it had already been
+	// used.
+	MarkUsed(context.Context, int64) (bool, *errors.RestErr)
+	// InvalidateForUser consumes every unused reset of the user.
+	InvalidateForUser(context.Context, int64) *errors.RestErr
+}
+
+func invalidResetError() *errors.RestErr {
+	return errors.NewBadRequestError("invalid or expired reset token")
+}
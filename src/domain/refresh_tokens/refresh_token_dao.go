@@ -0,0 +1,127 @@
+package refresh_tokens
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryInsertToken = "INSERT INTO refresh_tokens(user_id, family_id, token_hash, expires_at, mfa, date_created) VALUES (?,?,?,?,?,?);"
+
+	queryGetTokenByHash = "SELECT id, user_id, family_id, expires_at, used, revoked, mfa, date_created FROM refresh_tokens WHERE token_hash = ?;"
+
+	queryMarkTokenUsed = "UPDATE refresh_tokens SET used=1 WHERE id = ? AND used=0 AND revoked=0;"
+
+	queryRevokeFamily = "UPDATE refresh_tokens SET revoked=1 WHERE family_id = ?;"
+
+	queryRevokeUserTokens = "UPDATE refresh_tokens SET revoked=1 WHERE user_id = ?;"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a RefreshTokenRepository backed by the
+// refresh_tokens table.
+func NewMySQLRepository(db *sql.DB) RefreshTokenRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) Save(ctx context.Context, token *RefreshToken) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryInsertToken)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save refresh token statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	insertResult, saveErr := stmt.ExecContext(ctx, token.UserId, token.FamilyId, token.TokenHash, token.ExpiresAt, token.Mfa, token.DateCreated)
+	if saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save refresh token", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	tokenId, insertErr := insertResult.LastInsertId()
+	if insertErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get last insert id after creating a new refresh token", insertErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	token.Id = tokenId
+	return nil
+}
+
+func (r *mysqlRepository) GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetTokenByHash)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get refresh token statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	token := &RefreshToken{TokenHash: tokenHash}
+	result := stmt.QueryRowContext(ctx, tokenHash)
+	if getErr := result.Scan(&token.Id, &token.UserId, &token.FamilyId, &token.ExpiresAt, &token.Used, &token.Revoked, &token.Mfa, &token.DateCreated); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, invalidTokenError()
+		}
+		logger.ErrorContext(ctx, "error when trying to get refresh token by hash", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return token, nil
+}
+
+func (r *mysqlRepository) MarkUsed(ctx context.Context, tokenId int64) (bool, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryMarkTokenUsed)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare mark refresh token statement", err)
+		return false, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	updateResult, updateErr := stmt.ExecContext(ctx, tokenId)
+	if updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to mark refresh token as used", updateErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after marking refresh token", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
+}
+
+func (r *mysqlRepository) RevokeFamily(ctx context.Context, familyId string) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryRevokeFamily)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare revoke refresh token family statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, revokeErr := stmt.ExecContext(ctx, familyId); revokeErr != nil {
+		logger.ErrorContext(ctx, "error when trying to revoke refresh token family", revokeErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) RevokeAllForUser(ctx context.Context, userId int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryRevokeUserTokens)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare revoke user refresh tokens statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, revokeErr := stmt.ExecContext(ctx, userId); revokeErr != nil {
+		logger.ErrorContext(ctx, "error when trying to revoke user refresh tokens", revokeErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package refresh_tokens
+
+type RefreshToken struct {
+	Id          int64  `json:"id"`
+	UserId      int64  `json:"user_id"`
+	FamilyId    string `json:"family_id"`
+	TokenHash   string `json:"-"`
+	ExpiresAt   string `json:"expires_at"`
+	Used        bool   `json:"used"`
+	Revoked     bool   `json:"revoked"`
+	Mfa         bool   `json:"mfa"`
+	DateCreated string `json:"date_created"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,79 @@
+package refresh_tokens
+
+import (
+	"context"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps refresh tokens in a map, following the semantics of
+// the MySQL repository.
+type memoryRepository struct {
+	mu     sync.Mutex
+	lastId int64
+	tokens map[int64]RefreshToken
+}
+
+// NewMemoryRepository returns an empty RefreshTokenRepository that lives in
+// memory.
+func NewMemoryRepository() RefreshTokenRepository {
+	return &memoryRepository{tokens: make(map[int64]RefreshToken)}
+}
+
+func (r *memoryRepository) Save(ctx context.Context, token *RefreshToken) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	token.Id = r.lastId
+	r.tokens[token.Id] = *token
+	return nil
+}
+
+func (r *memoryRepository) GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, invalidTokenError()
+}
+
+func (r *memoryRepository) MarkUsed(ctx context.Context, tokenId int64) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenId]
+	if !ok || token.Used || token.Revoked {
+		return false, nil
+	}
+	token.Used = true
+	r.tokens[tokenId] = token
+	return true, nil
+}
+
+func (r *memoryRepository) RevokeFamily(ctx context.Context, familyId string) *errors.RestErr {
+	r.revokeWhere(func(token RefreshToken) bool { return token.FamilyId == familyId })
+	return nil
+}
+
+func (r *memoryRepository) RevokeAllForUser(ctx context.Context, userId int64) *errors.RestErr {
+	r.revokeWhere(func(token RefreshToken) bool { return token.UserId == userId })
+	return nil
+}
+
+func (r *memoryRepository) revokeWhere(match func(RefreshToken) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if match(token) {
+			token.Revoked = true
+			r.tokens[id] = token
+		}
+	}
+}
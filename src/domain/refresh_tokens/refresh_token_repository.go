@@ -0,0 +1,26 @@
+package refresh_tokens
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// RefreshTokenRepository stores refresh tokens by the hash of the opaque
+// token handed out to clients.
+type RefreshTokenRepository interface {
+	// Save stores a new token and sets its id.
+	Save(context.Context, *RefreshToken) *errors.RestErr
+	// GetByHash returns an unauthorized error when no token has the hash.
+	GetByHash(context.Context, string) (*RefreshToken, *errors.RestErr)
+	// MarkUsed flags the token as rotated. It reports false when the token
+	// was already used or revoked, which happens when two requests race to
+	// rotate it.
+	MarkUsed(context.Context, int64) (bool, *errors.RestErr)
+	RevokeFamily(context.Context, string) *errors.RestErr
+	RevokeAllForUser(context.Context, int64) *errors.RestErr
+}
+
+func invalidTokenError() *errors.RestErr {
+	return errors.NewUnauthorizedError("invalid refresh token")
+}
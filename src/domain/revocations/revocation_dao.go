@@ -0,0 +1,140 @@
+package revocations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryInsertRevokedToken = "INSERT IGNORE INTO revoked_tokens(jti, user_id, expires_at) VALUES (?,?,?);"
+
+	queryGetRevokedTokens = "SELECT jti, user_id, expires_at FROM revoked_tokens WHERE expires_at > ?;"
+
+	queryDeleteExpiredTokens = "DELETE FROM revoked_tokens WHERE expires_at <= ?;"
+
+	queryUpsertUserRevocation = "INSERT INTO user_token_revocations(user_id, revoked_before) VALUES (?,?) ON DUPLICATE KEY UPDATE revoked_before = VALUES(revoked_before);"
+
+	queryGetUserRevocations = "SELECT user_id, revoked_before FROM user_token_revocations;"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a RevocationRepository backed by the
+// revoked_tokens and user_token_revocations tables.
+func NewMySQLRepository(db *sql.DB) RevocationRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) SaveToken(ctx context.Context, token RevokedToken) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryInsertRevokedToken)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save revoked token statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, saveErr := stmt.ExecContext(ctx, token.Jti, token.UserId, token.ExpiresAt); saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save revoked token", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) GetActiveTokens(ctx context.Context, now int64) ([]RevokedToken, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetRevokedTokens)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get revoked tokens statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	rows, queryErr := stmt.QueryContext(ctx, now)
+	if queryErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get revoked tokens", queryErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer rows.Close()
+
+	var tokens []RevokedToken
+	for rows.Next() {
+		var current RevokedToken
+		if scanErr := rows.Scan(&current.Jti, &current.UserId, &current.ExpiresAt); scanErr != nil {
+			logger.ErrorContext(ctx, "error when trying to scan revoked token", scanErr)
+			return nil, errors.NewInternalServerError("database error")
+		}
+		tokens = append(tokens, current)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		logger.ErrorContext(ctx, "error when trying to iterate revoked tokens", rowsErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return tokens, nil
+}
+
+func (r *mysqlRepository) DeleteExpiredTokens(ctx context.Context, now int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteExpiredTokens)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare delete expired revoked tokens statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, deleteErr := stmt.ExecContext(ctx, now); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete expired revoked tokens", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) SaveUserRevocation(ctx context.Context, revocation UserRevocation) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryUpsertUserRevocation)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save user revocation statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, saveErr := stmt.ExecContext(ctx, revocation.UserId, revocation.RevokedBefore); saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save user revocation", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) GetUserRevocations(ctx context.Context) ([]UserRevocation, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetUserRevocations)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get user revocations statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	rows, queryErr := stmt.QueryContext(ctx)
+	if queryErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get user revocations", queryErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer rows.Close()
+
+	var result []UserRevocation
+	for rows.Next() {
+		var current UserRevocation
+		if scanErr := rows.Scan(&current.UserId, &current.RevokedBefore); scanErr != nil {
+			logger.ErrorContext(ctx, "error when trying to scan user revocation", scanErr)
+			return nil, errors.NewInternalServerError("database error")
+		}
+		result = append(result, current)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		logger.ErrorContext(ctx, "error when trying to iterate user revocations", rowsErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return result, nil
+}
@@ -0,0 +1,16 @@
+package revocations
+
+// RevokedToken blacklists a single access token until it would have expired
+// on its own.
+type RevokedToken struct {
+	Jti       string `json:"jti"`
+	UserId    int64  `json:"user_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// UserRevocation invalidates every access token of a user issued before
+// RevokedBefore (unix milliseconds).
+type UserRevocation struct {
+	UserId        int64 `json:"user_id"`
+	RevokedBefore int64 `json:"revoked_before"`
+}
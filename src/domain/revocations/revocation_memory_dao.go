@@ -0,0 +1,79 @@
+package revocations
+
+import (
+	"context"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps revocations in maps, following the semantics of the
+// MySQL repository.
+type memoryRepository struct {
+	mu     sync.Mutex
+	tokens map[string]RevokedToken
+	users  map[int64]int64
+}
+
+// NewMemoryRepository returns an empty RevocationRepository that lives in
+// memory.
+func NewMemoryRepository() RevocationRepository {
+	return &memoryRepository{
+		tokens: make(map[string]RevokedToken),
+		users:  make(map[int64]int64),
+	}
+}
+
+func (r *memoryRepository) SaveToken(ctx context.Context, token RevokedToken) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokens[token.Jti]; !ok {
+		r.tokens[token.Jti] = token
+	}
+	return nil
+}
+
+func (r *memoryRepository) GetActiveTokens(ctx context.Context, now int64) ([]RevokedToken, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []RevokedToken
+	for _, token := range r.tokens {
+		if token.ExpiresAt > now {
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+func (r *memoryRepository) DeleteExpiredTokens(ctx context.Context, now int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for jti, token := range r.tokens {
+		if token.ExpiresAt <= now {
+			delete(r.tokens, jti)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) SaveUserRevocation(ctx context.Context, revocation UserRevocation) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[revocation.UserId] = revocation.RevokedBefore
+	return nil
+}
+
+func (r *memoryRepository) GetUserRevocations(ctx context.Context) ([]UserRevocation, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]UserRevocation, 0, len(r.users))
+	for userId, revokedBefore := range r.users {
+		result = append(result, UserRevocation{UserId: userId, RevokedBefore: revokedBefore})
+	}
+	return result, nil
+}
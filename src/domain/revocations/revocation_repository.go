@@ -0,0 +1,21 @@
+package revocations
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// RevocationRepository stores revoked access tokens and per user cutoffs.
+// Times are unix seconds for tokens and unix milliseconds for cutoffs, like
+// the fields of RevokedToken and UserRevocation.
+type RevocationRepository interface {
+	// SaveToken ignores tokens that are already revoked.
+	SaveToken(context.Context, RevokedToken) *errors.RestErr
+	// GetActiveTokens returns the revoked tokens expiring after now.
+	GetActiveTokens(ctx context.Context, now int64) ([]RevokedToken, *errors.RestErr)
+	DeleteExpiredTokens(ctx context.Context, now int64) *errors.RestErr
+	// SaveUserRevocation replaces the previous cutoff of the user.
+	SaveUserRevocation(context.Context, UserRevocation) *errors.RestErr
+	GetUserRevocations(context.Context) ([]UserRevocation, *errors.RestErr)
+}
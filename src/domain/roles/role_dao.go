@@ -0,0 +1,146 @@
+package roles
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryGetRoles = "SELECT r.name, r.description, rp.permission FROM roles r LEFT JOIN role_permissions rp ON rp.role = r.name ORDER BY r.name, rp.permission;"
+
+	queryInsertRole = "INSERT INTO roles(name, description) VALUES (?,?);"
+
+	queryDeleteRole = "DELETE FROM roles WHERE name = ?;"
+
+	queryDeleteRolePermissions = "DELETE FROM role_permissions WHERE role = ?;"
+
+	queryInsertRolePermission = "INSERT INTO role_permissions(role, permission) VALUES (?,?);"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a RoleRepository backed by the roles and
+// role_permissions tables.
+func NewMySQLRepository(db *sql.DB) RoleRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) GetAll(ctx context.Context) ([]Role, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetRoles)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get roles statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	rows, queryErr := stmt.QueryContext(ctx)
+	if queryErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get roles", queryErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer rows.Close()
+
+	result := make([]Role, 0)
+	for rows.Next() {
+		var name, description string
+		var permission sql.NullString
+		if scanErr := rows.Scan(&name, &description, &permission); scanErr != nil {
+			logger.ErrorContext(ctx, "error when trying to scan role", scanErr)
+			return nil, errors.NewInternalServerError("database error")
+		}
+
+		if len(result) == 0 || result[len(result)-1].Name != name {
+			result = append(result, Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission.Valid {
+			current := &result[len(result)-1]
+			current.Permissions = append(current.Permissions, permission.String)
+		}
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		logger.ErrorContext(ctx, "error when trying to iterate roles", rowsErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return result, nil
+}
+
+// Save inserts the role together with its permissions in one transaction.
+func (r *mysqlRepository) Save(ctx context.Context, role Role) *errors.RestErr {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to begin save role transaction", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer tx.Rollback()
+
+	if _, saveErr := tx.ExecContext(ctx, queryInsertRole, role.Name, role.Description); saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save role", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	if err := insertPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return err
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		logger.ErrorContext(ctx, "error when trying to commit save role transaction", commitErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+// SetPermissions replaces every permission of the role.
+func (r *mysqlRepository) SetPermissions(ctx context.Context, name string, permissions []string) *errors.RestErr {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to begin set role permissions transaction", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer tx.Rollback()
+
+	if _, deleteErr := tx.ExecContext(ctx, queryDeleteRolePermissions, name); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete role permissions", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	if err := insertPermissions(ctx, tx, name, permissions); err != nil {
+		return err
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		logger.ErrorContext(ctx, "error when trying to commit set role permissions transaction", commitErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) Delete(ctx context.Context, name string) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteRole)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare delete role statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, deleteErr := stmt.ExecContext(ctx, name); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete role", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func insertPermissions(ctx context.Context, tx *sql.Tx, role string, permissions []string) *errors.RestErr {
+	for _, permission := range permissions {
+		if _, err := tx.ExecContext(ctx, queryInsertRolePermission, role, permission); err != nil {
+			logger.ErrorContext(ctx, "error when trying to save role permission", err)
+			return errors.NewInternalServerError("database error")
+		}
+	}
+	return nil
+}
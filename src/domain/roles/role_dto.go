@@ -0,0 +1,58 @@
+package roles
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+
+	PermUsersRead       = "users:read"
+	PermUsersDelete     = "users:delete"
+	PermUsersAssignRole = "users:assign_role"
+	PermRolesRead       = "roles:read"
+	PermRolesManage     = "roles:manage"
+	PermLockoutsRead    = "lockouts:read"
+	PermLockoutsClear   = "lockouts:clear"
+)
+
+// Permissions lists every permission the code checks. Roles can only be
+// granted permissions from this list.
+var Permissions = []string{
+	PermUsersRead,
+	PermUsersDelete,
+	PermUsersAssignRole,
+	PermRolesRead,
+	PermRolesManage,
+	PermLockoutsRead,
+	PermLockoutsClear,
+}
+
+// PrivilegedPermissions let the holder take over other accounts, by deleting
+// them or by handing out roles. Two-factor authentication can be required for
+// every role granted one of them.
+var PrivilegedPermissions = []string{
+	PermUsersDelete,
+	PermUsersAssignRole,
+	PermRolesManage,
+}
+
+type Role struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleInput struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type PermissionsInput struct {
+	Permissions []string `json:"permissions"`
+}
+
+func IsPermission(name string) bool {
+	for _, permission := range Permissions {
+		if permission == name {
+			return true
+		}
+	}
+	return false
+}
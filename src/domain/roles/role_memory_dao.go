@@ -0,0 +1,75 @@
+package roles
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps the roles in a map, following the semantics of the
+// MySQL repository.
+type memoryRepository struct {
+	mu    sync.Mutex
+	roles map[string]Role
+}
+
+// NewMemoryRepository returns a RoleRepository that lives in memory. It
+// starts with the roles the migrations create.
+func NewMemoryRepository() RoleRepository {
+	r := &memoryRepository{roles: make(map[string]Role)}
+	for _, role := range []Role{
+		{Name: RoleAdmin, Description: "Full access to user, role and lockout management", Permissions: Permissions},
+		{Name: RoleUser, Description: "Regular customer"},
+		{Name: "support", Description: "Read-only access for customer support", Permissions: []string{PermUsersRead, PermLockoutsRead}},
+	} {
+		r.Save(context.Background(), role)
+	}
+	return r
+}
+
+func (r *memoryRepository) GetAll(ctx context.Context) ([]Role, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Role, 0, len(r.roles))
+	for _, role := range r.roles {
+		role.Permissions = append([]string{}, role.Permissions...)
+		sort.Strings(role.Permissions)
+		result = append(result, role)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (r *memoryRepository) Save(ctx context.Context, role Role) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[role.Name]; ok {
+		return errors.NewInternalServerError("database error")
+	}
+	role.Permissions = append([]string{}, role.Permissions...)
+	r.roles[role.Name] = role
+	return nil
+}
+
+func (r *memoryRepository) SetPermissions(ctx context.Context, name string, permissions []string) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if role, ok := r.roles[name]; ok {
+		role.Permissions = append([]string{}, permissions...)
+		r.roles[name] = role
+	}
+	return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, name string) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.roles, name)
+	return nil
+}
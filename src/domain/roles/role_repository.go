@@ -0,0 +1,18 @@
+package roles
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// RoleRepository stores the roles and the permissions granted to them.
+type RoleRepository interface {
+	// GetAll returns every role with its permissions, ordered by name.
+	GetAll(context.Context) ([]Role, *errors.RestErr)
+	// Save inserts the role together with its permissions.
+	Save(context.Context, Role) *errors.RestErr
+	// SetPermissions replaces every permission of the role.
+	SetPermissions(context.Context, string, []string) *errors.RestErr
+	Delete(context.Context, string) *errors.RestErr
+}
@@ -0,0 +1,188 @@
+package two_factor
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+const (
+	queryUpsertTwoFactor = "INSERT INTO user_two_factor(user_id, secret, enabled, last_step, date_created) VALUES (?,?,0,0,?) ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled = 0, last_step = 0, date_created = VALUES(date_created);"
+
+	queryGetTwoFactor = "SELECT user_id, secret, enabled, last_step, date_created FROM user_two_factor WHERE user_id = ?;"
+
+	queryEnableTwoFactor = "UPDATE user_two_factor SET enabled=1 WHERE user_id = ?;"
+
+	queryDeleteTwoFactor = "DELETE FROM user_two_factor WHERE user_id = ?;"
+
+	queryUseStep = "UPDATE user_two_factor SET last_step=? WHERE user_id = ? AND last_step < ?;"
+
+	queryInsertRecoveryCode = "INSERT INTO totp_recovery_codes(user_id, code_hash) VALUES (?,?);"
+
+	queryUseRecoveryCode = "DELETE FROM totp_recovery_codes WHERE user_id = ? AND code_hash = ?;"
+
+	queryDeleteRecoveryCodes = "DELETE FROM totp_recovery_codes WHERE user_id = ?;"
+)
+
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// NewMySQLRepository returns a TwoFactorRepository backed by the
+// user_two_factor and totp_recovery_codes tables.
+func NewMySQLRepository(db *sql.DB) TwoFactorRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) Save(ctx context.Context, twoFactor *TwoFactor) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryUpsertTwoFactor)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save two factor statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, saveErr := stmt.ExecContext(ctx, twoFactor.UserId, twoFactor.Secret, twoFactor.DateCreated); saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save two factor", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	twoFactor.Enabled = false
+	twoFactor.LastStep = 0
+	return nil
+}
+
+func (r *mysqlRepository) Get(ctx context.Context, userId int64) (*TwoFactor, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryGetTwoFactor)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get two factor statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	var twoFactor TwoFactor
+	result := stmt.QueryRowContext(ctx, userId)
+	if getErr := result.Scan(&twoFactor.UserId, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep, &twoFactor.DateCreated); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, notSetUpError()
+		}
+		logger.ErrorContext(ctx, "error when trying to get two factor", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return &twoFactor, nil
+}
+
+func (r *mysqlRepository) Enable(ctx context.Context, userId int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryEnableTwoFactor)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare enable two factor statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, updateErr := stmt.ExecContext(ctx, userId); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to enable two factor", updateErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) Delete(ctx context.Context, userId int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteTwoFactor)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare delete two factor statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, deleteErr := stmt.ExecContext(ctx, userId); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete two factor", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
+
+func (r *mysqlRepository) UseStep(ctx context.Context, userId int64, step int64) (bool, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryUseStep)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare use totp step statement", err)
+		return false, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	updateResult, updateErr := stmt.ExecContext(ctx, step, userId, step)
+	if updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to use totp step", updateErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after using totp step", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
+}
+
+func (r *mysqlRepository) SaveRecoveryCode(ctx context.Context, code *RecoveryCode) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryInsertRecoveryCode)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare save recovery code statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	insertResult, saveErr := stmt.ExecContext(ctx, code.UserId, code.CodeHash)
+	if saveErr != nil {
+		logger.ErrorContext(ctx, "error when trying to save recovery code", saveErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	codeId, insertErr := insertResult.LastInsertId()
+	if insertErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get last insert id after creating a recovery code", insertErr)
+		return errors.NewInternalServerError("database error")
+	}
+
+	code.Id = codeId
+	return nil
+}
+
+func (r *mysqlRepository) UseRecoveryCode(ctx context.Context, userId int64, codeHash string) (bool, *errors.RestErr) {
+	stmt, err := r.db.PrepareContext(ctx, queryUseRecoveryCode)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare use recovery code statement", err)
+		return false, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	deleteResult, deleteErr := stmt.ExecContext(ctx, userId, codeHash)
+	if deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to use recovery code", deleteErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+
+	affected, affectedErr := deleteResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after using recovery code", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
+}
+
+func (r *mysqlRepository) DeleteRecoveryCodes(ctx context.Context, userId int64) *errors.RestErr {
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteRecoveryCodes)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare delete recovery codes statement", err)
+		return errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	if _, deleteErr := stmt.ExecContext(ctx, userId); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete recovery codes", deleteErr)
+		return errors.NewInternalServerError("database error")
+	}
+	return nil
+}
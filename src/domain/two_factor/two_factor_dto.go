@@ -0,0 +1,38 @@
+package two_factor
+
+type TwoFactor struct {
+	UserId      int64  `json:"user_id"`
+	Secret      string `json:"-"`
+	Enabled     bool   `json:"enabled"`
+	LastStep    int64  `json:"-"`
+	DateCreated string `json:"date_created"`
+}
+
+type RecoveryCode struct {
+	Id       int64  `json:"id"`
+	UserId   int64  `json:"user_id"`
+	CodeHash string `json:"-"`
+}
+
+type CodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type VerifyInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type ChallengeInput struct {
+	MfaToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Challenge identifies the token returned by Login when the user still has to
+// pass two-factor authentication. ExpiresAt is in unix seconds.
+type Challenge struct {
+	Jti       string
+	UserId    int64
+	ExpiresAt int64
+}
@@ -0,0 +1,114 @@
+package two_factor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps enrollments and recovery codes in maps, following
+// the semantics of the MySQL repository.
+type memoryRepository struct {
+	mu            sync.Mutex
+	lastCodeId    int64
+	enrollments   map[int64]TwoFactor
+	recoveryCodes map[int64]RecoveryCode
+}
+
+// NewMemoryRepository returns an empty TwoFactorRepository that lives in
+// memory.
+func NewMemoryRepository() TwoFactorRepository {
+	return &memoryRepository{
+		enrollments:   make(map[int64]TwoFactor),
+		recoveryCodes: make(map[int64]RecoveryCode),
+	}
+}
+
+func (r *memoryRepository) Save(ctx context.Context, twoFactor *TwoFactor) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	twoFactor.Enabled = false
+	twoFactor.LastStep = 0
+	r.enrollments[twoFactor.UserId] = *twoFactor
+	return nil
+}
+
+func (r *memoryRepository) Get(ctx context.Context, userId int64) (*TwoFactor, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	twoFactor, ok := r.enrollments[userId]
+	if !ok {
+		return nil, notSetUpError()
+	}
+	return &twoFactor, nil
+}
+
+func (r *memoryRepository) Enable(ctx context.Context, userId int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if twoFactor, ok := r.enrollments[userId]; ok {
+		twoFactor.Enabled = true
+		r.enrollments[userId] = twoFactor
+	}
+	return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, userId int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.enrollments, userId)
+	return nil
+}
+
+func (r *memoryRepository) UseStep(ctx context.Context, userId int64, step int64) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	twoFactor, ok := r.enrollments[userId]
+	if !ok || twoFactor.LastStep >= step {
+		return false, nil
+	}
+	twoFactor.LastStep = step
+	r.enrollments[userId] = twoFactor
+	return true, nil
+}
+
+func (r *memoryRepository) SaveRecoveryCode(ctx context.Context, code *RecoveryCode) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastCodeId++
+	code.Id = r.lastCodeId
+	r.recoveryCodes[code.Id] = *code
+	return nil
+}
+
+func (r *memoryRepository) UseRecoveryCode(ctx context.Context, userId int64, codeHash string) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, code := range r.recoveryCodes {
+		if code.UserId == userId && code.CodeHash == codeHash {
+			delete(r.recoveryCodes, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRepository) DeleteRecoveryCodes(ctx context.Context, userId int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, code := range r.recoveryCodes {
+		if code.UserId == userId {
+			delete(r.recoveryCodes, id)
+		}
+	}
+	return nil
+}
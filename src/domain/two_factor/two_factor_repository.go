@@ -0,0 +1,33 @@
+package two_factor
+
+import (
+	"context"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// TwoFactorRepository stores the TOTP enrollments of users and their
+// hashed recovery codes.
+type TwoFactorRepository interface {
+	// Save stores a new, not yet confirmed secret. It replaces any previous
+	// enrollment of the user.
+	Save(context.Context, *TwoFactor) *errors.RestErr
+	// Get returns a not found error when the user never enrolled.
+	Get(context.Context, int64) (*TwoFactor, *errors.RestErr)
+	Enable(context.Context, int64) *errors.RestErr
+	Delete(context.Context, int64) *errors.RestErr
+	// UseStep records step as the last accepted time step of the user. It
+	// reports false when an equal or later step was accepted concurrently,
+	// i.e. the code was replayed.
+	UseStep(context.Context, int64, int64) (bool, *errors.RestErr)
+	// SaveRecoveryCode stores a new recovery code and sets its id.
+	SaveRecoveryCode(context.Context, *RecoveryCode) *errors.RestErr
+	// UseRecoveryCode consumes the recovery code with the hash and reports
+	// whether it existed.
+	UseRecoveryCode(context.Context, int64, string) (bool, *errors.RestErr)
+	DeleteRecoveryCodes(context.Context, int64) *errors.RestErr
+}
+
+func notSetUpError() *errors.RestErr {
+	return errors.NewNotFoundError("two-factor authentication is not set up")
+}
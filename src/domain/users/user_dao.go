@@ -1,62 +1,316 @@
 package users
 
 import (
-	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
 	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/metrics"
+	"github.com/amirnep/shop/src/tracing"
+	"github.com/amirnep/shop/src/utils/date_utils"
 	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	queryInsertUser = "INSERT INTO users(first_name, last_name, email, password, confirm_password, image_url) VALUES (?,?,?,?,?,?);"
+	queryInsertUser = "INSERT INTO users(first_name, last_name, email, password, confirm_password, role, date_created, image_url) VALUES (?,?,?,?,?,?,?,?);"
+
+	queryGetUser = "SELECT id, first_name, last_name, email, role, date_created, image_url, email_verified FROM users WHERE id = ?;"
 
-	queryGetUser = "SELECT id, first_name, last_name, email, role, date_created, image_url FROM users WHERE id = ?;"
+	queryGetUserByEmail = "SELECT id, first_name, last_name, email, role, date_created, image_url, email_verified, password FROM users WHERE email = ?;"
 
-	queryUpdateUser = "UPDATE users SET first_name=?, last_name=?, image_url=? WHERE id = ?;"
+	// queryUpdateUser and queryReplaceImage compare bytes: the collation is
+	// case-insensitive and ignores trailing spaces, so a plain = would let a
+	// concurrent change that only differs in those overwrite, or be
+	// overwritten by, the one being saved.
+	queryUpdateUser = "UPDATE users SET first_name=?, last_name=?, image_url=? WHERE id = ? AND first_name = CAST(? AS BINARY) AND last_name = CAST(? AS BINARY) AND image_url = CAST(? AS BINARY);"
 
 	queryDeleteUser = "DELETE FROM users WHERE id = ?;"
 
-	queryGetLoginInfo = "SELECT id, email, role, password FROM users WHERE email = ?;"
+	queryListUsers = "SELECT id, first_name, last_name, email, role, date_created, image_url, email_verified FROM users"
 
-	queryGetUsers = "SELECT id, first_name, last_name, email, role, date_created, image_url FROM users;"
+	queryCountUsers = "SELECT COUNT(*) FROM users"
 
 	queryEditRole = "UPDATE users SET role=? WHERE id = ?;"
 
 	queryEditPassword = "UPDATE users SET password=?, confirm_password=? WHERE id = ?;"
+
+	queryVerifyEmail = "UPDATE users SET email_verified=1 WHERE id = ? AND email = ?;"
+
+	queryImageReferenced = "SELECT 1 FROM users WHERE image_url LIKE CAST(? AS BINARY) LIMIT 1;"
+	queryReplaceImage    = "UPDATE users SET image_url=? WHERE id = ? AND image_url = CAST(? AS BINARY);"
+
+	queryMarkVerificationSent   = "UPDATE users SET verification_sent_at=? WHERE id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?);"
+	queryUnmarkVerificationSent = "UPDATE users SET verification_sent_at=NULL WHERE id = ? AND verification_sent_at = ?;"
+
+	mysqlDuplicateEntry = 1062
 )
 
-func (user *User) Get() *errors.RestErr {
-	stmt, err := users_db.Client.Prepare(queryGetUser)
+type mysqlRepository struct {
+	db *sql.DB
+}
+
+// startQuery starts the span of a repository method. The returned function
+// ends it and records the method's latency.
+func startQuery(ctx context.Context, method string) (context.Context, func()) {
+	ctx, span := tracing.Start(ctx, "users.mysqlRepository."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.operation.name", method),
+		),
+	)
+	started := time.Now()
+	return ctx, func() {
+		metrics.ObserveQuery(method, started)
+		span.End()
+	}
+}
+
+// NewMySQLRepository returns a UserRepository backed by the users table.
+func NewMySQLRepository(db *sql.DB) UserRepository {
+	return &mysqlRepository{db: db}
+}
+
+func (r *mysqlRepository) Get(ctx context.Context, userId int64) (*User, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "Get")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryGetUser)
 	if err != nil {
-		logger.Error("error when trying to prepare get user statement", err)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to prepare get user statement", err)
+		return nil, errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	result := stmt.QueryRow(user.Id)
-	if getErr := result.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Role, &user.DateCreated, &user.ImageUrl); getErr != nil {
-		logger.Error("error when trying to get user by id", getErr)
+	var user User
+	result := stmt.QueryRowContext(ctx, userId)
+	if getErr := result.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Role, &user.DateCreated, &user.ImageUrl, &user.EmailVerified); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, errors.NewNotFoundError("user not found")
+		}
+		logger.ErrorContext(ctx, "error when trying to get user by id", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return &user, nil
+}
+
+func (r *mysqlRepository) GetByEmail(ctx context.Context, email string) (*User, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "GetByEmail")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryGetUserByEmail)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare get user by email statement", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer stmt.Close()
+
+	var user User
+	result := stmt.QueryRowContext(ctx, email)
+	if getErr := result.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Role, &user.DateCreated, &user.ImageUrl, &user.EmailVerified, &user.Password); getErr != nil {
+		if getErr == sql.ErrNoRows {
+			return nil, errors.NewNotFoundError("user not found")
+		}
+		logger.ErrorContext(ctx, "error when trying to get user by email", getErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return &user, nil
+}
+
+// Iterate streams the matching users in id order. The rows are read from the
+// connection as the iterator advances, so the table is never held in memory.
+func (r *mysqlRepository) Iterate(ctx context.Context, filter UserFilter) (UserIterator, *errors.RestErr) {
+	// Only the query is traced and timed, reading the rows depends on the
+	// consumer.
+	ctx, done := startQuery(ctx, "Iterate")
+	defer done()
+
+	conditions, args := filterConditions(filter)
+
+	rows, err := r.db.QueryContext(ctx, queryListUsers+whereClause(conditions)+" ORDER BY id;", args...)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to iterate users", err)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return &mysqlIterator{ctx: ctx, rows: rows}, nil
+}
+
+type mysqlIterator struct {
+	ctx  context.Context
+	rows *sql.Rows
+	user User
+	err  *errors.RestErr
+}
+
+func (it *mysqlIterator) Next() bool {
+	if it.err != nil || !it.rows.Next() {
+		return false
+	}
+
+	it.user = User{}
+	if err := it.rows.Scan(&it.user.Id, &it.user.FirstName, &it.user.LastName, &it.user.Email, &it.user.Role, &it.user.DateCreated, &it.user.ImageUrl, &it.user.EmailVerified); err != nil {
+		logger.ErrorContext(it.ctx, "error when trying to scan iterated user", err)
+		it.err = errors.NewInternalServerError("database error")
+		return false
+	}
+	return true
+}
+
+func (it *mysqlIterator) User() User {
+	return it.user
+}
+
+func (it *mysqlIterator) Err() *errors.RestErr {
+	if it.err != nil {
+		return it.err
+	}
+	if err := it.rows.Err(); err != nil {
+		logger.ErrorContext(it.ctx, "error when trying to iterate users", err)
 		return errors.NewInternalServerError("database error")
 	}
 	return nil
 }
 
-func (user *User) Save() *errors.RestErr {
-	stmt, err := users_db.Client.Prepare(queryInsertUser)
+func (it *mysqlIterator) Close() {
+	it.rows.Close()
+}
+
+func (r *mysqlRepository) List(ctx context.Context, options ListOptions) ([]User, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "List")
+	defer done()
+
+	conditions, args := filterConditions(options.Filter)
+
+	// The sort column is one of SortColumns, never user input.
+	column, direction, comparison := options.Sort, "ASC", ">"
+	if options.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if after := options.After; after != nil {
+		if column == SortId {
+			conditions = append(conditions, "id "+comparison+" ?")
+			args = append(args, after.Id)
+		} else {
+			conditions = append(conditions, "("+column+" "+comparison+" ? OR ("+column+" = ? AND id "+comparison+" ?))")
+			args = append(args, after.Value, after.Value, after.Id)
+		}
+	}
+
+	query := queryListUsers + whereClause(conditions) + " ORDER BY "
+	if column != SortId {
+		query += column + " " + direction + ", "
+	}
+	query += "id " + direction + " LIMIT ? OFFSET ?;"
+	args = append(args, options.Limit, options.Offset)
+
+	result, queryErr := r.db.QueryContext(ctx, query, args...)
+	if queryErr != nil {
+		logger.ErrorContext(ctx, "error when trying to list users", queryErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	defer result.Close()
+
+	users := make([]User, 0, options.Limit)
+	for result.Next() {
+		var user User
+		if getErr := result.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Role, &user.DateCreated, &user.ImageUrl, &user.EmailVerified); getErr != nil {
+			logger.ErrorContext(ctx, "error when trying to scan listed user", getErr)
+			return nil, errors.NewInternalServerError("database error")
+		}
+		users = append(users, user)
+	}
+
+	if resultErr := result.Err(); resultErr != nil {
+		logger.ErrorContext(ctx, "error when trying to list users", resultErr)
+		return nil, errors.NewInternalServerError("database error")
+	}
+	return users, nil
+}
+
+func (r *mysqlRepository) Count(ctx context.Context, filter UserFilter) (int64, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "Count")
+	defer done()
+
+	conditions, args := filterConditions(filter)
+
+	var count int64
+	if err := r.db.QueryRowContext(ctx, queryCountUsers+whereClause(conditions)+";", args...).Scan(&count); err != nil {
+		logger.ErrorContext(ctx, "error when trying to count users", err)
+		return 0, errors.NewInternalServerError("database error")
+	}
+	return count, nil
+}
+
+func filterConditions(filter UserFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Role != "" {
+		conditions = append(conditions, "role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.EmailPrefix != "" {
+		conditions = append(conditions, "email LIKE ?")
+		args = append(args, escapeLike(filter.EmailPrefix)+"%")
+	}
+	if filter.Name != "" {
+		conditions = append(conditions, "(first_name LIKE ? OR last_name LIKE ?)")
+		args = append(args, escapeLike(filter.Name)+"%", escapeLike(filter.Name)+"%")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "date_created >= ?")
+		args = append(args, date_utils.FormatDBTime(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "date_created < ?")
+		args = append(args, date_utils.FormatDBTime(filter.CreatedBefore))
+	}
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes LIKE match value literally.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+func (r *mysqlRepository) Save(ctx context.Context, user *User) *errors.RestErr {
+	ctx, done := startQuery(ctx, "Save")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryInsertUser)
 	if err != nil {
-		logger.Error("error when trying to prepare save user statement", err)
+		logger.ErrorContext(ctx, "error when trying to prepare save user statement", err)
 		return errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	insertResult, saveErr := stmt.Exec(user.FirstName, user.LastName, user.Email, user.Password, user.ConfirmPassword, user.ImageUrl)
+	insertResult, saveErr := stmt.ExecContext(ctx, user.FirstName, user.LastName, user.Email, user.Password, user.ConfirmPassword, user.Role, user.DateCreated, user.ImageUrl)
 	if saveErr != nil {
-		logger.Error("error when trying to save user", saveErr)
+		if mysqlErr, ok := saveErr.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlDuplicateEntry {
+			return emailTakenError()
+		}
+		logger.ErrorContext(ctx, "error when trying to save user", saveErr)
 		return errors.NewInternalServerError("database error")
 	}
 
 	userId, insertErr := insertResult.LastInsertId()
 	if insertErr != nil {
-		logger.Error("error when trying to get last insert id after creating a new user", insertErr)
+		logger.ErrorContext(ctx, "error when trying to get last insert id after creating a new user", insertErr)
 		return errors.NewInternalServerError("database error")
 	}
 
@@ -64,113 +318,174 @@ func (user *User) Save() *errors.RestErr {
 	return nil
 }
 
-func (user *User) Update() *errors.RestErr {
-	stmt, err := users_db.Client.Prepare(queryUpdateUser)
+func (r *mysqlRepository) Update(ctx context.Context, user *User, previous *User) (bool, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "Update")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryUpdateUser)
 	if err != nil {
-		logger.Error("error when trying to prepare update user statement", err)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to prepare update user statement", err)
+		return false, errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	_, updateErr := stmt.Exec(user.FirstName, user.LastName, user.ImageUrl, user.Id)
+	updateResult, updateErr := stmt.ExecContext(ctx, user.FirstName, user.LastName, user.ImageUrl, user.Id, previous.FirstName, previous.LastName, previous.ImageUrl)
 	if updateErr != nil {
-		logger.Error("error when trying to update user", updateErr)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to update user", updateErr)
+		return false, errors.NewInternalServerError("database error")
 	}
-	return nil
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after updating user", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
 }
 
-func (user *User) Delete() *errors.RestErr {
-	stmt, err := users_db.Client.Prepare(queryDeleteUser)
+func (r *mysqlRepository) Delete(ctx context.Context, userId int64) *errors.RestErr {
+	ctx, done := startQuery(ctx, "Delete")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryDeleteUser)
 	if err != nil {
-		logger.Error("error when trying to prepare delete user statement", err)
+		logger.ErrorContext(ctx, "error when trying to prepare delete user statement", err)
 		return errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	if _, deleteErr := stmt.Exec(user.Id); deleteErr != nil {
-		logger.Error("error when trying to delete user", deleteErr)
+	if _, deleteErr := stmt.ExecContext(ctx, userId); deleteErr != nil {
+		logger.ErrorContext(ctx, "error when trying to delete user", deleteErr)
 		return errors.NewInternalServerError("database error")
 	}
-		
 	return nil
 }
 
-func (user *User) Login() *errors.RestErr {
-	stmt, err := users_db.Client.Prepare(queryGetLoginInfo)
+func (r *mysqlRepository) EditRole(ctx context.Context, userId int64, role string) *errors.RestErr {
+	ctx, done := startQuery(ctx, "EditRole")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryEditRole)
 	if err != nil {
-		logger.Error("error when trying to prepare delete user statement", err)
+		logger.ErrorContext(ctx, "error when trying to prepare edit role statement", err)
 		return errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	result := stmt.QueryRow(user.Email)
-	if getErr := result.Scan(&user.Id, &user.Email, &user.Role, &user.Password); getErr != nil {
-		logger.Error("error when trying to get user by email", getErr)
+	if _, updateErr := stmt.ExecContext(ctx, role, userId); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to update user role", updateErr)
 		return errors.NewInternalServerError("database error")
 	}
 	return nil
 }
 
-func (user *User) GetAll() ([]User ,*errors.RestErr) {
-	stmt, err := users_db.Client.Prepare(queryGetUsers)
+// EditPassword keeps confirm_password in sync with password.
+func (r *mysqlRepository) EditPassword(ctx context.Context, userId int64, hash string) *errors.RestErr {
+	ctx, done := startQuery(ctx, "EditPassword")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryEditPassword)
 	if err != nil {
-		logger.Error("error when trying to get users statement", err)
-		return nil, errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to prepare edit password statement", err)
+		return errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	result, queryErr := stmt.Query()
-	if queryErr != nil {
-		return nil, errors.NewInternalServerError("query error")
+	if _, updateErr := stmt.ExecContext(ctx, hash, hash, userId); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to update user password", updateErr)
+		return errors.NewInternalServerError("database error")
 	}
+	return nil
+}
 
-	var users []User
-	
-	for result.Next() {
-		if getErr := result.Scan(&user.Id, &user.FirstName, &user.LastName, &user.Email, &user.Role, &user.DateCreated, &user.ImageUrl); getErr != nil {
-			logger.Error("error when trying to get users.", getErr)
-			return users , errors.NewInternalServerError("database error")
-		}
+func (r *mysqlRepository) VerifyEmail(ctx context.Context, userId int64, email string) *errors.RestErr {
+	ctx, done := startQuery(ctx, "VerifyEmail")
+	defer done()
 
-		users = append(users, *user)
+	stmt, err := r.db.PrepareContext(ctx, queryVerifyEmail)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to prepare verify email statement", err)
+		return errors.NewInternalServerError("database error")
 	}
+	defer stmt.Close()
 
-	if resultErr := result.Err(); resultErr != nil {
-		return nil, errors.NewInternalServerError(resultErr.Error())
+	if _, updateErr := stmt.ExecContext(ctx, userId, email); updateErr != nil {
+		logger.ErrorContext(ctx, "error when trying to verify user email", updateErr)
+		return errors.NewInternalServerError("database error")
 	}
-
-	return users, nil
+	return nil
 }
 
-func (user *User) EditRole() (*errors.RestErr) {
-	stmt, err := users_db.Client.Prepare(queryEditRole)
+func (r *mysqlRepository) ImageReferenced(ctx context.Context, key string) (bool, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "ImageReferenced")
+	defer done()
+
+	// Match the key at the end of the url so a changed public URL still
+	// finds it. The leading wildcard cannot use the image_url index, which
+	// is fine for the sweeper, the only caller that runs it in bulk.
+	pattern := "%/" + escapeLike(key)
+	var found int
+	err := r.db.QueryRowContext(ctx, queryImageReferenced, pattern).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		logger.Error("error when trying to prepare update user statement", err)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to look up an image url", err)
+		return false, errors.NewInternalServerError("database error")
 	}
-	defer stmt.Close()
+	return true, nil
+}
 
-	_, updateErr := stmt.Exec(user.Role, user.Id)
+func (r *mysqlRepository) ReplaceImage(ctx context.Context, userId int64, oldURL string, newURL string) (bool, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "ReplaceImage")
+	defer done()
+
+	updateResult, updateErr := r.db.ExecContext(ctx, queryReplaceImage, newURL, userId, oldURL)
 	if updateErr != nil {
-		logger.Error("error when trying to update user role", updateErr)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to replace user image", updateErr)
+		return false, errors.NewInternalServerError("database error")
 	}
-	return nil
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after replacing user image", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
 }
 
-func (user *User) EditPassword() (*errors.RestErr) {
-	stmt, err := users_db.Client.Prepare(queryEditPassword)
+func (r *mysqlRepository) MarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time, throttleBefore time.Time) (bool, *errors.RestErr) {
+	ctx, done := startQuery(ctx, "MarkVerificationSent")
+	defer done()
+
+	stmt, err := r.db.PrepareContext(ctx, queryMarkVerificationSent)
 	if err != nil {
-		logger.Error("error when trying to prepare update user statement", err)
-		return errors.NewInternalServerError("database error")
+		logger.ErrorContext(ctx, "error when trying to prepare mark verification sent statement", err)
+		return false, errors.NewInternalServerError("database error")
 	}
 	defer stmt.Close()
 
-	_, updateErr := stmt.Exec(user.Password, user.ConfirmPassword, user.Id)
+	updateResult, updateErr := stmt.ExecContext(ctx, date_utils.FormatDBTime(sentAt), userId, date_utils.FormatDBTime(throttleBefore))
 	if updateErr != nil {
-		logger.Error("error when trying to update user role", updateErr)
+		logger.ErrorContext(ctx, "error when trying to mark verification as sent", updateErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+
+	affected, affectedErr := updateResult.RowsAffected()
+	if affectedErr != nil {
+		logger.ErrorContext(ctx, "error when trying to get affected rows after marking verification as sent", affectedErr)
+		return false, errors.NewInternalServerError("database error")
+	}
+	return affected == 1, nil
+}
+
+func (r *mysqlRepository) UnmarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time) *errors.RestErr {
+	ctx, done := startQuery(ctx, "UnmarkVerificationSent")
+	defer done()
+
+	if _, err := r.db.ExecContext(ctx, queryUnmarkVerificationSent, userId, date_utils.FormatDBTime(sentAt)); err != nil {
+		logger.ErrorContext(ctx, "error when trying to unmark verification as sent", err)
 		return errors.NewInternalServerError("database error")
 	}
 	return nil
-}
\ No newline at end of file
+}
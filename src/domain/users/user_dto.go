@@ -1,7 +1,10 @@
 package users
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"mime/multipart"
+	"time"
 )
 
 type User struct {
@@ -14,6 +17,7 @@ type User struct {
 	Password        string `json:"password" binding:"required"`
 	ConfirmPassword string `json:"confirm_password" binding:"required"`
 	ImageUrl		string `json:"image_url"`
+	EmailVerified   bool   `json:"email_verified"`
 	Image			*multipart.FileHeader `form:"file"`
 }
 
@@ -30,8 +34,125 @@ type Profile struct {
 	LastName        string `json:"last_name"`
 }
 
+// ProfileDocument is the part of a user that the user edits. Empty members
+// are left out, a merge patch removes a member by setting it to null.
+type ProfileDocument struct {
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	ImageUrl  string `json:"image_url,omitempty"`
+}
+
+// ProfilePatch changes the profile of a user.
+type ProfilePatch struct {
+	// Document is a JSON Merge Patch (RFC 7396) of the ProfileDocument.
+	// image_url can only be removed, new images are uploaded.
+	Document json.RawMessage
+	// ImageUrl is a newly stored image that replaces the user's.
+	ImageUrl string
+}
+
 type Password struct {
 	Id              int64  `json:"id"`
 	Password        string `json:"password" binding:"required"`
 	ConfirmPassword string `json:"confirm_password" binding:"required"`
-}
\ No newline at end of file
+}
+
+type EmailInput struct {
+	Email 			string `json:"email" binding:"required"`
+}
+
+const (
+	SortId          = "id"
+	SortEmail       = "email"
+	SortRole        = "role"
+	SortDateCreated = "date_created"
+)
+
+// SortColumns are the columns the user list can be sorted by. All of them are
+// indexed.
+var SortColumns = []string{SortId, SortEmail, SortRole, SortDateCreated}
+
+// ListQuery holds the query parameters of the admin user list. Either Cursor
+// or Offset may be used to page through the results, not both.
+type ListQuery struct {
+	Cursor        string `form:"cursor"`
+	Offset        int    `form:"offset"`
+	Limit         int    `form:"limit"`
+	Role          string `form:"role"`
+	Email         string `form:"email"`
+	Name          string `form:"name"`
+	CreatedAfter  string `form:"created_after"`
+	CreatedBefore string `form:"created_before"`
+	Sort          string `form:"sort"`
+	Order         string `form:"order"`
+}
+
+// UserFilter narrows down a user list. Zero values do not filter.
+type UserFilter struct {
+	Role string
+	// EmailPrefix matches the start of the email address.
+	EmailPrefix string
+	// Name matches the start of the first or the last name.
+	Name          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ListOptions select one page of users. When After is set the page starts
+// behind that cursor (keyset pagination), otherwise Offset rows are skipped.
+type ListOptions struct {
+	Filter UserFilter
+	Sort   string
+	Desc   bool
+	After  *Cursor
+	Offset int
+	Limit  int
+}
+
+// Cursor points at the last user of a page. It carries the sort it was
+// created for so it cannot be replayed against a different ordering.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v,omitempty"`
+	Id    int64  `json:"i"`
+}
+
+type UserPage struct {
+	Users      Users
+	NextCursor string
+	Total      int64
+	Limit      int
+	Offset     int
+}
+
+// SortValue returns the value of the sort column for user.
+func (user *User) SortValue(sort string) string {
+	switch sort {
+	case SortEmail:
+		return user.Email
+	case SortRole:
+		return user.Role
+	case SortDateCreated:
+		return user.DateCreated
+	}
+	return ""
+}
+
+func (cursor Cursor) Encode() string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func DecodeCursor(value string) (*Cursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
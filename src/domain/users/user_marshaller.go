@@ -2,6 +2,8 @@ package users
 
 import (
 	"encoding/json"
+
+	"github.com/amirnep/shop/src/images"
 )
 
 type PublicUser struct {
@@ -18,6 +20,9 @@ type PrivateUser struct {
 	Role 		string `json:"role"`
 	DateCreated string `json:"date_created"`
 	ImageUrl	string `json:"image_url"`
+	EmailVerified bool `json:"email_verified"`
+	// Thumbnails holds the URLs of the square thumbnails, keyed by size.
+	Thumbnails map[int]string `json:"thumbnails,omitempty"`
 }
 
 func (users Users) Marshall(isPublic bool) []interface {} {
@@ -39,5 +44,25 @@ func (user *User) Marshall(isPublic bool) interface{} {
 	userJson, _ := json.Marshal(user)
 	var privateUser PrivateUser
 	json.Unmarshal(userJson, &privateUser)
+	privateUser.ImageUrl = images.PublicURL(user.ImageUrl)
+	privateUser.Thumbnails = images.ThumbnailURLs(user.ImageUrl)
 	return privateUser
-}
\ No newline at end of file
+}
+
+type PageResponse struct {
+	Results    []interface{} `json:"results"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Total      int64         `json:"total"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset,omitempty"`
+}
+
+func (page *UserPage) Marshall(isPublic bool) interface{} {
+	return PageResponse{
+		Results:    page.Users.Marshall(isPublic),
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+		Limit:      page.Limit,
+		Offset:     page.Offset,
+	}
+}
@@ -0,0 +1,312 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// memoryRepository keeps users in a map. It follows the semantics of the
+// MySQL repository, including the defaults the users table fills in, so it
+// can stand in for it in tests and local development.
+type memoryRepository struct {
+	mu                 sync.RWMutex
+	lastId             int64
+	users              map[int64]User
+	verificationSentAt map[int64]time.Time
+}
+
+// NewMemoryRepository returns an empty UserRepository that lives in memory.
+func NewMemoryRepository() UserRepository {
+	return &memoryRepository{
+		users:              make(map[int64]User),
+		verificationSentAt: make(map[int64]time.Time),
+	}
+}
+
+func (r *memoryRepository) Get(ctx context.Context, userId int64) (*User, *errors.RestErr) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return nil, errors.NewNotFoundError("user not found")
+	}
+	user.Password = ""
+	user.ConfirmPassword = ""
+	return &user, nil
+}
+
+func (r *memoryRepository) GetByEmail(ctx context.Context, email string) (*User, *errors.RestErr) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			user.ConfirmPassword = ""
+			return &user, nil
+		}
+	}
+	return nil, errors.NewNotFoundError("user not found")
+}
+
+// snapshot returns copies of all users, without passwords, in id order.
+func (r *memoryRepository) snapshot() []User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]User, 0, len(r.users))
+	for _, user := range r.users {
+		user.Password = ""
+		user.ConfirmPassword = ""
+		result = append(result, user)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result
+}
+
+func (r *memoryRepository) Iterate(ctx context.Context, filter UserFilter) (UserIterator, *errors.RestErr) {
+	var matching []User
+	for _, user := range r.snapshot() {
+		if matches(user, filter) {
+			matching = append(matching, user)
+		}
+	}
+	return &sliceIterator{users: matching, position: -1}, nil
+}
+
+type sliceIterator struct {
+	users    []User
+	position int
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.position+1 >= len(it.users) {
+		return false
+	}
+	it.position++
+	return true
+}
+
+func (it *sliceIterator) User() User {
+	return it.users[it.position]
+}
+
+func (it *sliceIterator) Err() *errors.RestErr {
+	return nil
+}
+
+func (it *sliceIterator) Close() {}
+
+func (r *memoryRepository) List(ctx context.Context, options ListOptions) ([]User, *errors.RestErr) {
+	all := r.snapshot()
+
+	// before reports whether the row (value, id) comes first in the
+	// requested order.
+	before := func(value string, id int64, otherValue string, otherId int64) bool {
+		if value == otherValue {
+			return id != otherId && (id < otherId) != options.Desc
+		}
+		return (value < otherValue) != options.Desc
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return before(all[i].SortValue(options.Sort), all[i].Id, all[j].SortValue(options.Sort), all[j].Id)
+	})
+
+	result := make([]User, 0, options.Limit)
+	skipped := 0
+	for _, user := range all {
+		if !matches(user, options.Filter) {
+			continue
+		}
+		if after := options.After; after != nil && !before(after.Value, after.Id, user.SortValue(options.Sort), user.Id) {
+			continue
+		}
+		if skipped < options.Offset {
+			skipped++
+			continue
+		}
+		if len(result) == options.Limit {
+			break
+		}
+		result = append(result, user)
+	}
+	return result, nil
+}
+
+func (r *memoryRepository) Count(ctx context.Context, filter UserFilter) (int64, *errors.RestErr) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, user := range r.users {
+		if matches(user, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func matches(user User, filter UserFilter) bool {
+	if filter.Role != "" && user.Role != filter.Role {
+		return false
+	}
+	if filter.EmailPrefix != "" && !strings.HasPrefix(user.Email, filter.EmailPrefix) {
+		return false
+	}
+	if filter.Name != "" && !hasPrefixFold(user.FirstName, filter.Name) && !hasPrefixFold(user.LastName, filter.Name) {
+		return false
+	}
+	if !filter.CreatedAfter.IsZero() || !filter.CreatedBefore.IsZero() {
+		created, err := date_utils.ParseDBTime(user.DateCreated)
+		if err != nil {
+			return false
+		}
+		if !filter.CreatedAfter.IsZero() && created.Before(filter.CreatedAfter) {
+			return false
+		}
+		if !filter.CreatedBefore.IsZero() && !created.Before(filter.CreatedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrefixFold matches like the case insensitive collation of the users
+// table.
+func hasPrefixFold(value string, prefix string) bool {
+	return len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix)
+}
+
+func (r *memoryRepository) Save(ctx context.Context, user *User) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return emailTakenError()
+		}
+	}
+
+	r.lastId++
+	user.Id = r.lastId
+
+	// The insert leaves these to the column defaults.
+	stored := *user
+	stored.EmailVerified = false
+	stored.Image = nil
+	r.users[stored.Id] = stored
+	return nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, user *User, previous *User) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.Id]
+	if !ok || stored.FirstName != previous.FirstName || stored.LastName != previous.LastName || stored.ImageUrl != previous.ImageUrl {
+		return false, nil
+	}
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.ImageUrl = user.ImageUrl
+	r.users[user.Id] = stored
+	return true, nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, userId int64) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, userId)
+	delete(r.verificationSentAt, userId)
+	return nil
+}
+
+func (r *memoryRepository) EditRole(ctx context.Context, userId int64, role string) *errors.RestErr {
+	return r.modify(userId, func(user *User) { user.Role = role })
+}
+
+func (r *memoryRepository) EditPassword(ctx context.Context, userId int64, hash string) *errors.RestErr {
+	return r.modify(userId, func(user *User) {
+		user.Password = hash
+		user.ConfirmPassword = hash
+	})
+}
+
+func (r *memoryRepository) VerifyEmail(ctx context.Context, userId int64, email string) *errors.RestErr {
+	return r.modify(userId, func(user *User) {
+		if user.Email == email {
+			user.EmailVerified = true
+		}
+	})
+}
+
+func (r *memoryRepository) ImageReferenced(ctx context.Context, key string) (bool, *errors.RestErr) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if strings.HasSuffix(user.ImageUrl, "/"+key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRepository) ReplaceImage(ctx context.Context, userId int64, oldURL string, newURL string) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userId]
+	if !ok || stored.ImageUrl != oldURL {
+		return false, nil
+	}
+	stored.ImageUrl = newURL
+	r.users[userId] = stored
+	return true, nil
+}
+
+func (r *memoryRepository) MarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time, throttleBefore time.Time) (bool, *errors.RestErr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userId]; !ok {
+		return false, nil
+	}
+	if previous, ok := r.verificationSentAt[userId]; ok && previous.After(throttleBefore) {
+		return false, nil
+	}
+	r.verificationSentAt[userId] = sentAt
+	return true, nil
+}
+
+func (r *memoryRepository) UnmarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if previous, ok := r.verificationSentAt[userId]; ok && previous.Equal(sentAt) {
+		delete(r.verificationSentAt, userId)
+	}
+	return nil
+}
+
+// modify applies change to the stored user. Like an UPDATE matching no rows,
+// it does nothing when the user does not exist.
+func (r *memoryRepository) modify(userId int64, change func(*User)) *errors.RestErr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userId]
+	if !ok {
+		return nil
+	}
+	change(&stored)
+	r.users[userId] = stored
+	return nil
+}
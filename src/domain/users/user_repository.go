@@ -0,0 +1,74 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+// UserRepository stores users. The users service only goes through this
+// interface, so MySQL can be replaced, e.g. by the in-memory repository in
+// tests and local development. Every method takes the context of the request,
+// which carries its trace span and is cancelled when the client goes away.
+type UserRepository interface {
+	// Get returns a not found error when there is no user with the id.
+	Get(context.Context, int64) (*User, *errors.RestErr)
+	// GetByEmail returns the user including the password hash, or a not
+	// found error.
+	GetByEmail(context.Context, string) (*User, *errors.RestErr)
+	// Iterate returns the users matching the filter in id order. The iterator
+	// must be closed.
+	Iterate(context.Context, UserFilter) (UserIterator, *errors.RestErr)
+	// List returns at most options.Limit users matching the filter, in the
+	// order of options.Sort with the id as tie breaker.
+	List(context.Context, ListOptions) ([]User, *errors.RestErr)
+	// Count returns how many users match the filter.
+	Count(context.Context, UserFilter) (int64, *errors.RestErr)
+	// Save stores a new user with the role and creation date it carries, and
+	// sets its id. A bad request error is returned when the email address is
+	// already registered.
+	Save(context.Context, *User) *errors.RestErr
+	// Update saves the profile fields of the user as long as they still are
+	// those of previous, and reports whether it did. Nothing is locked, a
+	// concurrent change makes it report false instead of being overwritten.
+	Update(ctx context.Context, user *User, previous *User) (bool, *errors.RestErr)
+	Delete(context.Context, int64) *errors.RestErr
+	EditRole(context.Context, int64, string) *errors.RestErr
+	// EditPassword replaces the password hash of the user.
+	EditPassword(context.Context, int64, string) *errors.RestErr
+	// VerifyEmail marks the email of the user as verified, as long as the
+	// user still has that email.
+	VerifyEmail(context.Context, int64, string) *errors.RestErr
+	// ImageReferenced reports whether a user has the image stored under
+	// key, whatever public URL the store had when the user was saved.
+	ImageReferenced(ctx context.Context, key string) (bool, *errors.RestErr)
+	// ReplaceImage sets the image url of the user to newURL as long as it
+	// still is oldURL, and reports whether it did.
+	ReplaceImage(ctx context.Context, userId int64, oldURL string, newURL string) (bool, *errors.RestErr)
+	// MarkVerificationSent records that a verification email goes out at
+	// sentAt. It reports false, without updating anything, when the previous
+	// one was sent after throttleBefore.
+	MarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time, throttleBefore time.Time) (bool, *errors.RestErr)
+	// UnmarkVerificationSent forgets the send recorded at sentAt, when it
+	// failed, so that the user can ask again right away.
+	UnmarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time) *errors.RestErr
+}
+
+// UserIterator walks over users one at a time, in the style of sql.Rows:
+//
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+type UserIterator interface {
+	Next() bool
+	User() User
+	// Err returns the error that stopped the iteration, if any.
+	Err() *errors.RestErr
+	Close()
+}
+
+func emailTakenError() *errors.RestErr {
+	return errors.NewBadRequestError("email address is already registered")
+}
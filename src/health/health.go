@@ -0,0 +1,129 @@
+// Package health reports whether the service can take traffic. Components
+// register a check on the Readiness registry at startup, and /readyz runs all
+// of them.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOk           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+
+	// DefaultTimeout bounds a single check, a probe must answer well within
+	// the orchestrator's own timeout.
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	Readiness = NewRegistry(DefaultTimeout)
+)
+
+// CheckFunc returns nil when the dependency it checks is usable.
+type CheckFunc func(ctx context.Context) error
+
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+func (r Report) Ok() bool {
+	return r.Status == StatusOk
+}
+
+type Registry struct {
+	mu           sync.RWMutex
+	checks       map[string]CheckFunc
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{checks: make(map[string]CheckFunc), timeout: timeout}
+}
+
+// Register adds a check, replacing the one registered under the same name.
+func (r *Registry) Register(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// ShutDown makes every following report fail without running the checks, so
+// the load balancer stops routing to the instance while it drains.
+func (r *Registry) ShutDown() {
+	r.shuttingDown.Store(true)
+}
+
+// Check runs every check concurrently, each bounded by the registry timeout.
+// The results are ordered by name.
+func (r *Registry) Check(ctx context.Context) Report {
+	if r.shuttingDown.Load() {
+		return Report{Status: StatusShuttingDown}
+	}
+
+	r.mu.RLock()
+	checks := make(map[string]CheckFunc, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusOk, Checks: make([]CheckResult, 0, len(checks))}
+	results := make(chan CheckResult, len(checks))
+	for name, check := range checks {
+		go func(name string, check CheckFunc) {
+			results <- r.run(ctx, name, check)
+		}(name, check)
+	}
+	for range checks {
+		result := <-results
+		if result.Status != StatusOk {
+			report.Status = StatusFailing
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, name string, check CheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// A check that ignores its context must not hold up the probe.
+		err = fmt.Errorf("timed out after %s", r.timeout)
+	}
+
+	result := CheckResult{Name: name, Status: StatusOk, DurationMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+// Package images turns uploaded profile pictures into the images the service
+// stores. An upload is only accepted when its content is a JPEG, PNG, WebP or
+// GIF that decodes within the configured limits. It is then re-encoded, which
+// drops every metadata block such as EXIF and GPS, and scaled down to square
+// thumbnails.
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/amirnep/shop/src/config"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// ThumbnailSizes are the edges, in pixels, of the square thumbnails made of
+// every image.
+var ThumbnailSizes = []int{64, 256, 512}
+
+type decoder struct {
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+}
+
+// decoders are keyed by the content type sniffed from the upload, the file
+// name and the declared type are never trusted.
+var decoders = map[string]decoder{
+	"image/jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"image/png":  {png.Decode, png.DecodeConfig},
+	"image/gif":  {gif.Decode, gif.DecodeConfig},
+	"image/webp": {webp.Decode, webp.DecodeConfig},
+}
+
+// Format is how an image is encoded for storage.
+type Format struct {
+	ContentType string
+	Extension   string
+}
+
+var (
+	FormatJPEG = Format{ContentType: "image/jpeg", Extension: ".jpg"}
+	FormatPNG  = Format{ContentType: "image/png", Extension: ".png"}
+)
+
+// Decode validates content and decodes it, applying the EXIF orientation of
+// JPEGs. Every error it returns describes a problem with the upload and can be
+// shown to the client.
+func Decode(content []byte, limits config.Images) (image.Image, error) {
+	contentType := http.DetectContentType(content)
+	decoder, ok := decoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("image must be a JPEG, PNG, WebP or GIF")
+	}
+
+	// The header is checked before anything is allocated for the pixels.
+	header, err := decoder.decodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("image is malformed")
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if header.Width > limits.MaxDimension || header.Height > limits.MaxDimension ||
+		int64(header.Width)*int64(header.Height) > int64(limits.MaxPixels) {
+		return nil, fmt.Errorf("image must not be larger than %d pixels per side or %d pixels in total", limits.MaxDimension, limits.MaxPixels)
+	}
+
+	img, err := decoder.decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("image is malformed")
+	}
+
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(content))
+	}
+	return img, nil
+}
+
+// FormatOf picks the storage format of img. Opaque images are stored as JPEG,
+// PNG keeps the transparency of the others.
+func FormatOf(img image.Image) Format {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return FormatJPEG
+	}
+	return FormatPNG
+}
+
+// Encode encodes img in format. The encoders write no metadata.
+func Encode(img image.Image, format Format, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unknown image format %q", format.ContentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Thumbnail crops the center square of img and scales it to size pixels.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	edge := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, edge, edge).Add(bounds.Min).Add(image.Pt((bounds.Dx()-edge)/2, (bounds.Dy()-edge)/2))
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, crop, draw.Src, nil)
+	return thumbnail
+}
+
+// ThumbnailName returns the key or URL of the thumbnail of size made of the
+// image at name. Thumbnails are stored next to their image.
+func ThumbnailName(name string, size int) string {
+	extension := path.Ext(name)
+	return strings.TrimSuffix(name, extension) + "_" + strconv.Itoa(size) + extension
+}
+
+// OriginalName returns the key or URL of the image a thumbnail was made of,
+// or name itself when it is not a thumbnail.
+func OriginalName(name string) string {
+	extension := path.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for _, size := range ThumbnailSizes {
+		if original, ok := strings.CutSuffix(base, "_"+strconv.Itoa(size)); ok {
+			return original + extension
+		}
+	}
+	return name
+}
+
+// PublicURL turns the stored URL of an image into the URL handed to clients.
+// StartApplication replaces it when image URLs are signed.
+var PublicURL = func(url string) string {
+	return url
+}
+
+// Stored reports whether url is one of the image store's. Only those have
+// thumbnails. StartApplication sets it from the configured store.
+var Stored = func(url string) bool {
+	return false
+}
+
+// ThumbnailURLs returns the public URLs of the thumbnails of the image stored
+// at url, keyed by size, or nil when url is not the store's.
+func ThumbnailURLs(url string) map[int]string {
+	if url == "" || !Stored(url) {
+		return nil
+	}
+	urls := make(map[int]string, len(ThumbnailSizes))
+	for _, size := range ThumbnailSizes {
+		urls[size] = PublicURL(ThumbnailName(url, size))
+	}
+	return urls
+}
@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/dgrijalva/jwt-go"
+)
+
+type tokenSettings struct {
+	issuer         string
+	audience       string
+	clockSkew      time.Duration
+	accessTokenTTL time.Duration
+}
+
+var settings = newTokenSettings(config.Default().JWT)
+
+func newTokenSettings(cfg config.JWT) tokenSettings {
+	return tokenSettings{
+		issuer:         cfg.Issuer,
+		audience:       cfg.Audience,
+		clockSkew:      cfg.ClockSkew,
+		accessTokenTTL: cfg.AccessTokenTTL,
+	}
+}
+
+// Configure sets the issuer and audience written to and required from every
+// token, how much clock skew is tolerated when checking exp, nbf and iat, and
+// how long access tokens live.
+func Configure(cfg config.JWT) {
+	settings = newTokenSettings(cfg)
+}
+
+func AccessTokenTTL() time.Duration {
+	return settings.accessTokenTTL
+}
+
+// Claims are the claims of an access token. The user id is carried in sub and
+// the token id in jti.
+type Claims struct {
+	Role string `json:"role"`
+	// MFA is set when the user passed two-factor authentication at login.
+	MFA bool `json:"mfa,omitempty"`
+	// IssuedAtMs is iat in milliseconds. Revoking the tokens of a user must
+	// not catch the one issued right after, e.g. when logging in again after
+	// changing the password.
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
+	jwt.StandardClaims
+}
+
+// challengeClaims are carried by the short-lived token returned by Login when
+// the user still has to pass two-factor authentication. They use their own
+// audience so they can never be accepted as access tokens.
+type challengeClaims struct {
+	Claims
+}
+
+func newClaims(userId int64, role string, jti string, ttl time.Duration, audience string) *Claims {
+	now := time.Now()
+	return &Claims{
+		Role:       role,
+		IssuedAtMs: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.FormatInt(userId, 10),
+			Issuer:    settings.issuer,
+			Audience:  audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+}
+
+// Valid is called by the parser once the signature has been verified.
+func (c *Claims) Valid() error {
+	return c.validate(settings.audience)
+}
+
+func (c *challengeClaims) Valid() error {
+	return c.validate(challengeAudience())
+}
+
+func challengeAudience() string {
+	return settings.audience + "/mfa"
+}
+
+func (c *Claims) validate(audience string) error {
+	now := time.Now()
+	skew := int64(settings.clockSkew.Seconds())
+
+	switch {
+	case c.ExpiresAt == 0 || now.Unix() > c.ExpiresAt+skew:
+		return &claimsError{"expired", "token is expired"}
+	case now.Unix() < c.NotBefore-skew:
+		return &claimsError{"not_yet_valid", "token is not valid yet"}
+	case now.Unix() < c.IssuedAt-skew:
+		return &claimsError{"not_yet_valid", "token used before issued"}
+	case c.Issuer != settings.issuer:
+		return &claimsError{"issuer", fmt.Sprintf("unexpected token issuer %q", c.Issuer)}
+	case c.Audience != audience:
+		return &claimsError{"audience", fmt.Sprintf("unexpected token audience %q", c.Audience)}
+	case c.Id == "":
+		return &claimsError{"claims", "token has no jti"}
+	}
+
+	if _, err := c.UserId(); err != nil {
+		return &claimsError{"claims", err.Error()}
+	}
+	return nil
+}
+
+// claimsError is a failed claims check. reason labels it in the token
+// validation failure metric.
+type claimsError struct {
+	reason  string
+	message string
+}
+
+func (e *claimsError) Error() string {
+	return e.message
+}
+
+// IssuedAtMillis returns when the token was issued in unix milliseconds.
+// Tokens from before iat_ms was added only tell the second.
+func (c *Claims) IssuedAtMillis() int64 {
+	if c.IssuedAtMs != 0 {
+		return c.IssuedAtMs
+	}
+	return c.IssuedAt * 1000
+}
+
+func (c *Claims) UserId() (int64, error) {
+	userId, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token subject %q", c.Subject)
+	}
+	return userId, nil
+}
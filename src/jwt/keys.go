@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	minRSAKeyBits = 2048
+	ephemeralKid  = "ephemeral"
+)
+
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	private    crypto.PrivateKey
+	public     crypto.PublicKey
+	activeFrom time.Time
+}
+
+// keySet holds every key tokens may be verified with. A key found in the key
+// directory is published in the JWKS right away but only used for signing once
+// activationDelay has passed since the file was written, so that downstream
+// services can pick it up before the first token signed with it shows up.
+type keySet struct {
+	mu              sync.RWMutex
+	keys            map[string]*signingKey
+	activationDelay time.Duration
+}
+
+var keys = &keySet{keys: make(map[string]*signingKey)}
+
+// LoadKeys reads every *.pem private key (PKCS#8 RSA/Ed25519 or PKCS#1 RSA) in
+// dir and uses the file name without extension as kid.
+func LoadKeys(dir string, activationDelay time.Duration) error {
+	if dir == "" {
+		return fmt.Errorf("no jwt keys directory configured")
+	}
+
+	loaded, err := readKeyDir(dir)
+	if err != nil {
+		return err
+	}
+
+	keys.mu.Lock()
+	keys.keys = loaded
+	keys.activationDelay = activationDelay
+	keys.mu.Unlock()
+	return nil
+}
+
+// LoadEphemeralKey signs tokens with a newly generated RSA key. Tokens do not
+// survive a restart and other replicas cannot verify them, so it is only
+// meant for local development.
+func LoadEphemeralKey() error {
+	key, err := newEphemeralKey()
+	if err != nil {
+		return err
+	}
+	logger.Warn("signing tokens with an ephemeral key, set JWT_KEYS_DIR outside of development")
+
+	keys.mu.Lock()
+	keys.keys = map[string]*signingKey{key.kid: key}
+	keys.activationDelay = 0
+	keys.mu.Unlock()
+	return nil
+}
+
+// StartKeyRotation reloads dir every interval so that added keys become
+// available and deleted keys are retired without a restart. A key should only
+// be deleted once every token it signed has expired. It stops when ctx is
+// done.
+func StartKeyRotation(ctx context.Context, dir string, interval time.Duration) {
+	if dir == "" {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			loaded, err := readKeyDir(dir)
+			if err != nil {
+				logger.Error("error when trying to reload jwt keys", err)
+				continue
+			}
+
+			keys.mu.Lock()
+			keys.keys = loaded
+			keys.mu.Unlock()
+		}
+	}()
+}
+
+func readKeyDir(dir string) (map[string]*signingKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(map[string]*signingKey, len(files))
+	for _, file := range files {
+		key, err := readKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt key %s: %w", file, err)
+		}
+		loaded[key.kid] = key
+	}
+
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no jwt keys found in %s", dir)
+	}
+	return loaded, nil
+}
+
+func readKeyFile(file string) (*signingKey, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return newSigningKey(kid, private, info.ModTime())
+}
+
+func newSigningKey(kid string, private interface{}, activeFrom time.Time) (*signingKey, error) {
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("rsa keys must have at least %d bits", minRSAKeyBits)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey, activeFrom: activeFrom}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: SigningMethodEdDSA, private: key, public: key.Public(), activeFrom: activeFrom}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+func newEphemeralKey() (*signingKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(ephemeralKid, private, time.Now())
+}
+
+// current returns the newest key that has passed its activation delay, or the
+// oldest key when none has yet.
+func (s *keySet) current() (*signingKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-s.activationDelay)
+	var active, oldest *signingKey
+	for _, key := range s.keys {
+		if !key.activeFrom.After(cutoff) && (active == nil || key.activeFrom.After(active.activeFrom)) {
+			active = key
+		}
+		if oldest == nil || key.activeFrom.Before(oldest.activeFrom) {
+			oldest = key
+		}
+	}
+
+	if active != nil {
+		return active, nil
+	}
+	if oldest != nil {
+		return oldest, nil
+	}
+	return nil, fmt.Errorf("no jwt signing key loaded")
+}
+
+func (s *keySet) get(kid string) (*signingKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *keySet) all() []*signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*signingKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].kid < result[j].kid
+	})
+	return result
+}
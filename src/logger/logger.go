@@ -1,6 +1,10 @@
 package logger
 
 import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -19,7 +23,7 @@ func init() {
 			TimeKey: "time",
 			MessageKey: "msg",
 			EncodeTime: zapcore.ISO8601TimeEncoder,
-			EncodeLevel: zapcore.LowercaseColorLevelEncoder,
+			EncodeLevel: zapcore.LowercaseLevelEncoder,
 			EncodeCaller: zapcore.ShortCallerEncoder,
 		},
 	}
@@ -39,8 +43,76 @@ func Info(msg string, tags ...zap.Field) {
 	log.Sync()
 }
 
+// Warn is for setups that work but must not be used in production.
+func Warn(msg string, tags ...zap.Field) {
+	log.Warn(msg, tags...)
+	log.Sync()
+}
+
 func Error(msg string, err error, tags ...zap.Field) {
 	tags = append(tags, zap.NamedError("error",err))
 	log.Error(msg, tags...)
 	log.Sync()
-}
\ No newline at end of file
+}
+
+// Sync flushes any buffered log entries. It is the last step of a shutdown.
+// Syncing stdout fails on terminals and pipes, which is not worth reporting.
+func Sync() {
+	log.Sync()
+}
+
+type contextKey struct{}
+
+// WithFields returns a copy of ctx whose logger adds fields to every entry,
+// e.g. the request id set by the request id middleware.
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	return context.WithValue(ctx, contextKey{}, fromContext(ctx).With(fields...))
+}
+
+// FromContext returns the logger of ctx, with the fields added by WithFields
+// and the trace and span id of the span in ctx.
+func FromContext(ctx context.Context) *zap.Logger {
+	return fromContext(ctx).With(traceFields(ctx)...)
+}
+
+func fromContext(ctx context.Context) *zap.Logger {
+	if contextLog, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return contextLog
+	}
+	return log
+}
+
+// InfoContext logs like Info through the logger of ctx.
+func InfoContext(ctx context.Context, msg string, tags ...zap.Field) {
+	FromContext(ctx).Info(msg, tags...)
+}
+
+// ErrorContext logs like Error through the logger of ctx. The error is also
+// recorded on the span, which marks it as failed.
+func ErrorContext(ctx context.Context, msg string, err error, tags ...zap.Field) {
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, msg)
+	}
+	FromContext(ctx).Error(msg, append(tags, zap.NamedError("error", err))...)
+}
+
+// Replace swaps the logger, including the one of contexts that got no fields
+// yet, and returns a function restoring the previous one. Tests use it to
+// observe log entries.
+func Replace(replacement *zap.Logger) func() {
+	previous := log
+	log = replacement
+	return func() { log = previous }
+}
+
+func traceFields(ctx context.Context) []zap.Field {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("trace_id", spanContext.TraceID().String()),
+		zap.String("span_id", spanContext.SpanID().String()),
+	}
+}
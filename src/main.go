@@ -1,7 +1,34 @@
 package main
 
-import "github.com/amirnep/shop/src/app"
+import (
+	"fmt"
+	"os"
+
+	"github.com/amirnep/shop/src/app"
+	"github.com/amirnep/shop/src/config"
+)
 
 func main() {
-	app.StartApplication()
-}
\ No newline at end of file
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 && (args[0] == "migrate" || args[0] == "images") {
+		command, args = args[0], args[1:]
+	}
+
+	cfg, rest, err := config.Load(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch command {
+	case "migrate":
+		os.Exit(app.Migrate(cfg, rest))
+	case "images":
+		os.Exit(app.Images(cfg, rest))
+	}
+	if err := app.StartApplication(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
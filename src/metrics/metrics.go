@@ -0,0 +1,83 @@
+// Package metrics exposes the service's Prometheus metrics. Every collector is
+// registered on Registry, which /metrics serves, rather than on the global
+// default registry.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Login results recorded by Logins.
+const (
+	LoginSuccess    = "success"
+	LoginFailure    = "failure"
+	LoginLocked     = "locked"
+	LoginUnverified = "unverified"
+	LoginError      = "error"
+)
+
+var (
+	Registry = newRegistry()
+
+	HTTPRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, gin route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, gin route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	Logins = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "users_logins_total",
+		Help: "Password logins by result.",
+	}, []string{"result"})
+
+	TokenFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "users_token_validation_failures_total",
+		Help: "Rejected access tokens by reason.",
+	}, []string{"reason"})
+
+	QueryDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "users_db_query_duration_seconds",
+		Help:    "Latency of the user repository queries by method.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method"})
+)
+
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// Handler serves Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveQuery records the latency of a repository method. It is meant to be
+// deferred at the top of the method:
+//
+//	defer metrics.ObserveQuery("Get", time.Now())
+func ObserveQuery(method string, started time.Time) {
+	QueryDuration.WithLabelValues(method).Observe(time.Since(started).Seconds())
+}
+
+// RegisterDBStats exports the connection pool statistics of db, labelled
+// with name.
+func RegisterDBStats(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
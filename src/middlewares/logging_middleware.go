@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	RequestIdHeader = "X-Request-ID"
+	// maxRequestIdLength keeps a caller from stuffing the logs through the
+	// request id.
+	maxRequestIdLength = 128
+)
+
+// RequestId keeps the X-Request-ID sent by the caller, or assigns a new one,
+// echoes it in the response and adds it to the request's logger.
+func RequestId() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		requestId := context.GetHeader(RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = uuid.New().String()
+		}
+
+		context.Header(RequestIdHeader, requestId)
+		context.Request.Header.Set(RequestIdHeader, requestId)
+		context.Request = context.Request.WithContext(
+			logger.WithFields(context.Request.Context(), zap.String("request_id", requestId)))
+		context.Next()
+	}
+}
+
+// validRequestId accepts short ids made of printable ASCII without spaces,
+// which covers UUIDs and the ids of common proxies.
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, char := range requestId {
+		if char <= ' ' || char > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog writes one structured entry per request once it is done.
+// Requests that failed on the server side are logged as errors.
+func AccessLog() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		started := time.Now()
+		context.Next()
+
+		route := context.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := context.Writer.Status()
+
+		fields := []zap.Field{
+			zap.String("method", context.Request.Method),
+			zap.String("route", route),
+			zap.String("path", context.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Float64("latency_ms", float64(time.Since(started).Microseconds())/1000),
+			zap.Int("bytes", context.Writer.Size()),
+			zap.String("client_ip", context.ClientIP()),
+		}
+		if userId, ok := jwt.AuthenticatedUserId(context); ok {
+			fields = append(fields, zap.Int64("user_id", userId))
+		}
+		if errs := context.Errors.String(); errs != "" {
+			fields = append(fields, zap.String("errors", errs))
+		}
+
+		log := logger.FromContext(context.Request.Context())
+		if status >= http.StatusInternalServerError {
+			log.Error("request", fields...)
+			return
+		}
+		log.Info("request", fields...)
+	}
+}
+
+// Recovery turns a panic into a 500 and logs it with its stack through the
+// request's logger, instead of gin's plain-text recovery output.
+func Recovery() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					// Deliberate abort of the response, not a bug.
+					panic(recovered)
+				}
+				logger.FromContext(context.Request.Context()).Error("panic while handling request",
+					zap.Any("panic", recovered),
+					zap.Stack("stack"),
+				)
+				context.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		context.Next()
+	}
+}
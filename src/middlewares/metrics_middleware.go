@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/amirnep/shop/src/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute labels requests no route matched, so scanners probing random
+// paths cannot blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+// Metrics counts and times every request by its route template, such as
+// /api/admin/GetUser/:user_id, rather than by its path.
+func Metrics() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		started := time.Now()
+		context.Next()
+
+		route := context.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(context.Writer.Status())
+
+		metrics.HTTPRequests.WithLabelValues(context.Request.Method, route, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(context.Request.Method, route, status).Observe(time.Since(started).Seconds())
+	}
+}
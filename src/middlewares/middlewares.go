@@ -4,20 +4,27 @@ import (
 	"net/http"
 
 	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
 	"github.com/gin-gonic/gin"
 )
 
-func JWTAuthMiddleware() gin.HandlerFunc {
+// RequirePermission only lets through tokens whose role was granted
+// permission.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(context *gin.Context) {
-		err := jwt.ValidateJWT(context)
+		claims, err := jwt.GetClaims(context)
 		if err != nil {
 			context.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			context.Abort()
 			return
 		}
-		error := jwt.ValidateAdminRoleJWT(context)
-		if error != nil {
-			context.JSON(http.StatusUnauthorized, gin.H{"error": "Only Administrator is allowed to perform this action"})
+		if !services.RolesService.HasPermission(claims.Role, permission) {
+			context.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to perform this action"})
+			context.Abort()
+			return
+		}
+		if services.TwoFactorService.RequiredForRole(claims.Role) && !claims.MFA {
+			context.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for administrators"})
 			context.Abort()
 			return
 		}
@@ -41,4 +48,4 @@ func JWTAuthCustomerMiddleware() gin.HandlerFunc {
 		}
 		context.Next()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/amirnep/shop/src/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts the server span of every request, as a child of the span in
+// the W3C traceparent header when the caller sent one. Handlers find it in
+// the request context.
+func Tracing() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		route := context.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		ctx := tracing.Extract(context.Request.Context(), context.Request.Header)
+		ctx, span := tracing.Start(ctx, context.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", context.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", context.Request.URL.Path),
+				attribute.String("client.address", context.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		context.Request = context.Request.WithContext(ctx)
+		context.Next()
+
+		status := context.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package notifications
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileNotifier appends every message as a JSON line to a file, so local
+// setups and tests can pick up links without a mail server.
+type fileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) Notifier {
+	return &fileNotifier{path: path}
+}
+
+func (n *fileNotifier) Send(message Message) error {
+	line, err := json.Marshal(struct {
+		Message
+		SentAt string `json:"sent_at"`
+	}{message, time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,37 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type Notifier interface {
+	Send(Message) error
+}
+
+// Sender delivers every outgoing user notification. It logs messages by
+// default, without the tokens of their links, which is only meant for local
+// development.
+var Sender Notifier = NewLogNotifier()
+
+// NewNotifier returns the notifier registered under name. target is only used
+// by notifiers that need one, such as the file path of the file notifier.
+func NewNotifier(name string, target string) (Notifier, error) {
+	switch strings.ToLower(name) {
+	case "", "log":
+		return NewLogNotifier(), nil
+	case "file":
+		if target == "" {
+			return nil, fmt.Errorf("file notifier needs a target path")
+		}
+		return NewFileNotifier(target), nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier %q", name)
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/notifications"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+var (
+	// EmailVerificationsService is set up by StartApplication.
+	EmailVerificationsService emailVerificationsServiceInterface
+)
+
+type emailVerificationsService struct {
+	config config.EmailVerification
+	secret []byte
+}
+
+// NewEmailVerificationsService signs links with cfg.Secret, which config
+// validation requires to be at least 32 characters long.
+func NewEmailVerificationsService(cfg config.EmailVerification) emailVerificationsServiceInterface {
+	return &emailVerificationsService{config: cfg, secret: []byte(cfg.Secret)}
+}
+
+type emailVerificationsServiceInterface interface {
+	SendVerification(context.Context, *users.User) *errors.RestErr
+	ResendVerification(context.Context, string) *errors.RestErr
+	VerifyEmail(context.Context, string) *errors.RestErr
+	RequireVerifiedEmail() bool
+}
+
+// SendVerification mails a signed verification link to the user, unless one
+// was already sent within the resend interval.
+func (s *emailVerificationsService) SendVerification(ctx context.Context, user *users.User) *errors.RestErr {
+	now := date_utils.GetNow()
+	throttleBefore := now.Add(-s.config.ResendInterval)
+
+	marked, err := UsersService.MarkVerificationSent(ctx, user.Id, now, throttleBefore)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		logger.InfoContext(ctx, "verification email throttled")
+		return nil
+	}
+
+	ttl := s.config.TTL
+	token := s.signToken(user.Id, user.Email, now.Add(ttl))
+
+	message := notifications.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Confirm your email address within %d hours by opening: %s", int(ttl.Hours()), s.verificationLink(token)),
+	}
+	if err := notifications.Sender.Send(message); err != nil {
+		logger.ErrorContext(ctx, "error when trying to send verification notification", err)
+		// The send was recorded first so that concurrent requests cannot
+		// both send, a failed one must not throttle the retry.
+		UsersService.UnmarkVerificationSent(ctx, user.Id, now)
+		return errors.NewInternalServerError("error when trying to send verification email")
+	}
+	return nil
+}
+
+// ResendVerification behaves the same whether or not email is registered or
+// already verified, so it cannot be used to enumerate accounts.
+func (s *emailVerificationsService) ResendVerification(ctx context.Context, email string) *errors.RestErr {
+	user, err := UsersService.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	// Errors are already logged, reporting them would tell registered and
+	// unknown emails apart.
+	s.SendVerification(ctx, user)
+	return nil
+}
+
+func (s *emailVerificationsService) VerifyEmail(ctx context.Context, token string) *errors.RestErr {
+	userId, email, expiresAt, ok := s.parseToken(token)
+	if !ok || !expiresAt.After(date_utils.GetNow()) {
+		return errors.NewBadRequestError("invalid or expired verification token")
+	}
+
+	return UsersService.VerifyEmail(ctx, userId, email)
+}
+
+func (s *emailVerificationsService) RequireVerifiedEmail() bool {
+	return s.config.Required
+}
+
+// signToken binds the token to the email address so that it stops working
+// once the user changes it.
+func (s *emailVerificationsService) signToken(userId int64, email string, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d:%d:%s", userId, expiresAt.Unix(), email)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + crypto_utils.SignHMAC(s.secret, payload)
+}
+
+func (s *emailVerificationsService) parseToken(token string) (int64, string, time.Time, bool) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return 0, "", time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !crypto_utils.VerifyHMAC(s.secret, string(payload), signature) {
+		return 0, "", time.Time{}, false
+	}
+
+	parts := strings.SplitN(string(payload), ":", 3)
+	if len(parts) != 3 {
+		return 0, "", time.Time{}, false
+	}
+	userId, idErr := strconv.ParseInt(parts[0], 10, 64)
+	expiresAt, expErr := strconv.ParseInt(parts[1], 10, 64)
+	if idErr != nil || expErr != nil {
+		return 0, "", time.Time{}, false
+	}
+	return userId, parts[2], time.Unix(expiresAt, 0), true
+}
+
+func (s *emailVerificationsService) verificationLink(token string) string {
+	return withTokenQuery(s.config.URL, token)
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"net/url"
+)
+
+// withTokenQuery adds token as the token query parameter of link.
+func withTokenQuery(link string, token string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return link + "?token=" + url.QueryEscape(token)
+	}
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/images"
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/amirnep/shop/src/tracing"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/google/uuid"
+)
+
+const (
+	imageKeyPrefix = "users/"
+	// Keys are never reused, a new upload gets a new one, so unsigned
+	// images can be cached for good.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+var (
+	// ImagesService starts out on the default local directory,
+	// StartApplication replaces it with the configured backend.
+	ImagesService imagesServiceInterface = NewImagesService(storage.NewLocalStore(config.Default().Storage.LocalDir, config.Default().Storage.LocalPublicURL), config.Default().Images, nil)
+)
+
+type imagesService struct {
+	store  storage.ImageStore
+	limits config.Images
+	signer *storage.URLSigner
+}
+
+// NewImagesService serves the images of store. With a signer, clients are
+// handed signed URLs and Open refuses unsigned requests.
+func NewImagesService(store storage.ImageStore, limits config.Images, signer *storage.URLSigner) imagesServiceInterface {
+	return &imagesService{store: store, limits: limits, signer: signer}
+}
+
+type imagesServiceInterface interface {
+	SaveUpload(context.Context, *multipart.FileHeader) (string, *errors.RestErr)
+	Open(ctx context.Context, key string, expires string, signature string) (*Image, *errors.RestErr)
+	MaxUploadSize() int
+	PublicURL(url string) string
+	Stored(url string) bool
+	DeleteImage(ctx context.Context, url string)
+	Sweep(ctx context.Context, olderThan time.Time, dryRun bool) (*SweepResult, *errors.RestErr)
+	StartSweeper(ctx context.Context, interval time.Duration, gracePeriod time.Duration)
+	MigrateLegacy(ctx context.Context, root string, dryRun bool) (*LegacyMigrationResult, *errors.RestErr)
+}
+
+// Image is a stored image ready to be served. Content must be closed.
+type Image struct {
+	Content io.ReadCloser
+	Info    storage.ObjectInfo
+	// CacheControl tells clients and proxies how long they may keep it.
+	CacheControl string
+}
+
+// SaveUpload validates an uploaded profile image and stores it, re-encoded,
+// under a new key along with its thumbnails. It returns the public URL of the
+// image, the thumbnails' URLs derive from it.
+func (s *imagesService) SaveUpload(ctx context.Context, file *multipart.FileHeader) (string, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "imagesService.SaveUpload")
+	defer span.End()
+
+	if file.Size > int64(s.limits.MaxUploadSize) {
+		return "", errors.NewBadRequestError(fmt.Sprintf("image must not be larger than %d bytes", s.limits.MaxUploadSize))
+	}
+
+	upload, err := file.Open()
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to open uploaded image", err)
+		return "", errors.NewBadRequestError("error in uploading and saving file")
+	}
+	defer upload.Close()
+
+	content, err := io.ReadAll(io.LimitReader(upload, int64(s.limits.MaxUploadSize)+1))
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to read uploaded image", err)
+		return "", errors.NewBadRequestError("error in uploading and saving file")
+	}
+	if len(content) > s.limits.MaxUploadSize {
+		return "", errors.NewBadRequestError(fmt.Sprintf("image must not be larger than %d bytes", s.limits.MaxUploadSize))
+	}
+
+	img, err := images.Decode(content, s.limits)
+	if err != nil {
+		return "", errors.NewBadRequestError(err.Error())
+	}
+
+	url, err := s.save(ctx, img)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to store uploaded image", err)
+		return "", errors.NewInternalServerError("error in uploading and saving file")
+	}
+	return url, nil
+}
+
+// Open returns the image stored under key. When URLs are signed, expires and
+// signature are the query parameters of the signed URL.
+func (s *imagesService) Open(ctx context.Context, key string, expires string, signature string) (*Image, *errors.RestErr) {
+	cacheControl := immutableCacheControl
+	if s.signer != nil {
+		expiresAt, err := s.signer.Verify(key, expires, signature)
+		if err != nil {
+			return nil, errors.NewForbiddenError(err.Error())
+		}
+		// The URL stops working when it expires, and nobody but the client
+		// it was handed to may keep the image.
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds()))
+	}
+
+	content, info, err := s.store.Open(ctx, key)
+	if err == storage.ErrNotExist {
+		return nil, errors.NewNotFoundError("image not found")
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to open image "+key, err)
+		return nil, errors.NewInternalServerError("error when trying to get image")
+	}
+	return &Image{Content: content, Info: info, CacheControl: cacheControl}, nil
+}
+
+// MaxUploadSize is the largest image SaveUpload accepts, in bytes.
+func (s *imagesService) MaxUploadSize() int {
+	return s.limits.MaxUploadSize
+}
+
+// PublicURL returns the URL to hand to clients for the stored URL of an
+// image, signed when URLs are.
+func (s *imagesService) PublicURL(url string) string {
+	if s.signer == nil || url == "" {
+		return url
+	}
+	key, ok := s.store.Key(url)
+	if !ok {
+		// Not one of the store's images, there is nothing to sign.
+		return url
+	}
+	signed, err := s.signer.Sign(url, key)
+	if err != nil {
+		logger.Error("error when trying to sign image url", err)
+		return url
+	}
+	return signed
+}
+
+// Stored reports whether url is one of the store's images.
+func (s *imagesService) Stored(url string) bool {
+	_, ok := s.store.Key(url)
+	return ok
+}
+
+// DeleteImage removes the image at url and its thumbnails, once no user
+// references it any more. URLs that are not the store's are left alone.
+// Failures are only logged, the sweeper removes what is left behind.
+func (s *imagesService) DeleteImage(ctx context.Context, url string) {
+	key, ok := s.store.Key(url)
+	if !ok {
+		return
+	}
+	referenced, err := UsersService.ImageReferenced(ctx, key)
+	if err != nil || referenced {
+		// Another user may have set the same URL, and an image that is
+		// not checked is left to the sweeper.
+		return
+	}
+	keys := []string{key}
+	for _, size := range images.ThumbnailSizes {
+		keys = append(keys, images.ThumbnailName(key, size))
+	}
+	s.delete(ctx, keys)
+}
+
+// SweepResult counts what a sweep of the image store found.
+type SweepResult struct {
+	Scanned  int `json:"scanned"`
+	Orphaned int `json:"orphaned"`
+	Deleted  int `json:"deleted"`
+}
+
+// Sweep deletes the images no user references that were stored before
+// olderThan. Younger images may belong to an upload whose user is not saved
+// yet. With dryRun the orphans are only counted.
+//
+// When none of the old images is referenced it refuses to delete anything:
+// that is far more likely a store or database that does not belong with
+// this one than a store full of orphans.
+func (s *imagesService) Sweep(ctx context.Context, olderThan time.Time, dryRun bool) (*SweepResult, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "imagesService.Sweep")
+	defer span.End()
+
+	result := &SweepResult{}
+	var orphans []string
+	anyReferenced := false
+	// Thumbnails are listed right after their image, remembering the last
+	// lookup saves a query per thumbnail.
+	var lastOriginal string
+	var lastReferenced bool
+	var lookupErr *errors.RestErr
+
+	listErr := s.store.List(ctx, imageKeyPrefix, func(key string, info storage.ObjectInfo) error {
+		result.Scanned++
+		if !info.ModTime.Before(olderThan) {
+			return nil
+		}
+
+		original := images.OriginalName(key)
+		if original != lastOriginal {
+			referenced, err := UsersService.ImageReferenced(ctx, original)
+			if err != nil {
+				lookupErr = err
+				return stderrors.New(err.Message)
+			}
+			lastOriginal, lastReferenced = original, referenced
+		}
+		if lastReferenced {
+			anyReferenced = true
+			return nil
+		}
+		orphans = append(orphans, key)
+		return nil
+	})
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	if listErr != nil {
+		logger.ErrorContext(ctx, "error when trying to list stored images", listErr)
+		return nil, errors.NewInternalServerError("error when trying to list stored images")
+	}
+
+	result.Orphaned = len(orphans)
+	if dryRun {
+		for _, key := range orphans {
+			logger.InfoContext(ctx, "found orphaned image "+key)
+		}
+		return result, nil
+	}
+	if len(orphans) > 0 && !anyReferenced {
+		logger.FromContext(ctx).Warn(fmt.Sprintf("refusing to delete %d images, none of the stored images is referenced by a user", len(orphans)))
+		return nil, errors.NewConflictError("none of the stored images is referenced by a user, check that the image store and the database belong together")
+	}
+	for _, key := range orphans {
+		if err := s.store.Delete(ctx, key); err != nil {
+			logger.ErrorContext(ctx, "error when trying to delete orphaned image "+key, err)
+			continue
+		}
+		logger.InfoContext(ctx, "deleted orphaned image "+key)
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// StartSweeper sweeps the image store every interval until ctx is done.
+func (s *imagesService) StartSweeper(ctx context.Context, interval time.Duration, gracePeriod time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := s.Sweep(ctx, time.Now().Add(-gracePeriod), false)
+				if err == nil && result.Orphaned > 0 {
+					logger.Info(fmt.Sprintf("image sweep deleted %d of %d orphaned images", result.Deleted, result.Orphaned))
+				}
+			}
+		}
+	}()
+}
+
+// LegacyMigrationResult counts what a migration of legacy images did.
+type LegacyMigrationResult struct {
+	Scanned  int `json:"scanned"`
+	Legacy   int `json:"legacy"`
+	Migrated int `json:"migrated"`
+	Failed   int `json:"failed"`
+}
+
+// MigrateLegacy moves the images users reference by a local file path, such
+// as src/wwwroot/<uuid>.jpg from before the image store, into the store and
+// points the users to the stored image. Relative paths are resolved against
+// root. A moved file is deleted once its user is updated. Images that cannot
+// be read or decoded are logged and left alone. With dryRun the legacy images
+// are only counted.
+func (s *imagesService) MigrateLegacy(ctx context.Context, root string, dryRun bool) (*LegacyMigrationResult, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "imagesService.MigrateLegacy")
+	defer span.End()
+
+	// The users are collected first, so the iterator is closed before any
+	// of them is updated.
+	it, restErr := UsersService.ExportUsers(ctx, users.ListQuery{})
+	if restErr != nil {
+		return nil, restErr
+	}
+	result := &LegacyMigrationResult{}
+	var legacy []users.User
+	for it.Next() {
+		result.Scanned++
+		user := it.User()
+		if _, ok := s.store.Key(user.ImageUrl); user.ImageUrl != "" && !ok {
+			legacy = append(legacy, user)
+		}
+	}
+	restErr = it.Err()
+	it.Close()
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	result.Legacy = len(legacy)
+	if dryRun {
+		for _, user := range legacy {
+			logger.InfoContext(ctx, fmt.Sprintf("found legacy image %s of user %d", user.ImageUrl, user.Id))
+		}
+		return result, nil
+	}
+
+	for _, user := range legacy {
+		if err := ctx.Err(); err != nil {
+			return result, errors.NewInternalServerError("image migration was interrupted")
+		}
+		if s.migrateLegacy(ctx, root, user) {
+			result.Migrated++
+		} else {
+			result.Failed++
+		}
+	}
+	return result, nil
+}
+
+func (s *imagesService) migrateLegacy(ctx context.Context, root string, user users.User) bool {
+	file := filepath.FromSlash(user.ImageUrl)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		logger.ErrorContext(ctx, fmt.Sprintf("error when trying to read legacy image of user %d", user.Id), err)
+		return false
+	}
+	img, err := images.Decode(content, s.limits)
+	if err != nil {
+		logger.ErrorContext(ctx, fmt.Sprintf("error when trying to decode legacy image of user %d", user.Id), err)
+		return false
+	}
+
+	url, err := s.save(ctx, img)
+	if err != nil {
+		logger.ErrorContext(ctx, fmt.Sprintf("error when trying to store legacy image of user %d", user.Id), err)
+		return false
+	}
+	replaced, restErr := UsersService.ReplaceImage(ctx, user.Id, user.ImageUrl, url)
+	if restErr != nil || !replaced {
+		// The user changed or lost the image meanwhile, the copy is not
+		// referenced.
+		s.DeleteImage(ctx, url)
+		return restErr == nil
+	}
+
+	if err := os.Remove(file); err != nil {
+		logger.ErrorContext(ctx, "error when trying to delete legacy image "+file, err)
+	}
+	logger.InfoContext(ctx, fmt.Sprintf("moved legacy image %s of user %d to %s", user.ImageUrl, user.Id, url))
+	return true
+}
+
+// save stores img, re-encoded, under a new key along with its thumbnails and
+// returns its public URL. Nothing is left behind when it fails.
+func (s *imagesService) save(ctx context.Context, img image.Image) (string, error) {
+	format := images.FormatOf(img)
+	key := imageKeyPrefix + uuid.New().String() + format.Extension
+	variants := map[string]image.Image{key: img}
+	for _, size := range images.ThumbnailSizes {
+		variants[images.ThumbnailName(key, size)] = images.Thumbnail(img, size)
+	}
+
+	var stored []string
+	for variantKey, variant := range variants {
+		if err := s.put(ctx, variantKey, variant, format); err != nil {
+			s.delete(ctx, stored)
+			return "", err
+		}
+		stored = append(stored, variantKey)
+	}
+	return s.store.URL(key), nil
+}
+
+func (s *imagesService) put(ctx context.Context, key string, img image.Image, format images.Format) error {
+	content, err := images.Encode(img, format, s.limits.JPEGQuality)
+	if err != nil {
+		return err
+	}
+	_, err = s.store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), format.ContentType)
+	return err
+}
+
+// delete removes the variants of a failed upload. Failures are only logged,
+// what is left behind is never referenced.
+func (s *imagesService) delete(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			logger.ErrorContext(ctx, "error when trying to delete image "+key, err)
+		}
+	}
+}
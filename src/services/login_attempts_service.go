@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/login_attempts"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+var (
+	// LoginAttemptsService is set up by StartApplication.
+	LoginAttemptsService loginAttemptsServiceInterface
+)
+
+type loginAttemptsService struct {
+	config     config.Login
+	repository login_attempts.LoginAttemptRepository
+}
+
+func NewLoginAttemptsService(cfg config.Login, repository login_attempts.LoginAttemptRepository) loginAttemptsServiceInterface {
+	return &loginAttemptsService{config: cfg, repository: repository}
+}
+
+type loginAttemptsServiceInterface interface {
+	CheckAllowed(context.Context, string, string) *errors.RestErr
+	RegisterFailure(context.Context, string, string) *errors.RestErr
+	RegisterSuccess(context.Context, string) *errors.RestErr
+	GetLockouts(context.Context) ([]login_attempts.LoginAttempt, *errors.RestErr)
+	ClearLockout(context.Context, string, string) *errors.RestErr
+}
+
+// CheckAllowed rejects the login while the email or the client IP is locked.
+// Emails are tracked whether or not they belong to a user, so a lockout does
+// not reveal which accounts exist.
+func (s *loginAttemptsService) CheckAllowed(ctx context.Context, email string, clientIp string) *errors.RestErr {
+	now := date_utils.GetNow().Unix()
+	for _, key := range s.attemptsFor(email, clientIp) {
+		attempt, err := s.repository.Get(ctx, key.Kind, key.Key)
+		if err != nil {
+			if err.Status == http.StatusNotFound {
+				continue
+			}
+			return err
+		}
+		if attempt.LockedUntil > now {
+			return errors.NewTooManyRequestsError("too many failed login attempts, try again later")
+		}
+	}
+	return nil
+}
+
+// RegisterFailure counts a failed login. Once a counter reaches its limit the
+// key is locked, for twice as long with every further failure.
+func (s *loginAttemptsService) RegisterFailure(ctx context.Context, email string, clientIp string) *errors.RestErr {
+	now := date_utils.GetNow()
+	resetBefore := now.Add(-s.config.FailureWindow).Unix()
+
+	for _, key := range s.attemptsFor(email, clientIp) {
+		attempt, err := s.repository.RegisterFailure(ctx, key.Kind, key.Key, now.Unix(), resetBefore)
+		if err != nil {
+			return err
+		}
+
+		lockout := s.lockoutDuration(attempt.Failures, s.maxFailures(attempt.Kind))
+		if lockout == 0 {
+			continue
+		}
+		if err := s.repository.SetLockedUntil(ctx, attempt.Kind, attempt.Key, now.Add(lockout).Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterSuccess resets the counter of the account. The IP counter is left
+// alone so that one valid login does not reset a password spraying attempt.
+func (s *loginAttemptsService) RegisterSuccess(ctx context.Context, email string) *errors.RestErr {
+	return s.repository.Delete(ctx, login_attempts.KindAccount, normalizeEmail(email))
+}
+
+func (s *loginAttemptsService) GetLockouts(ctx context.Context) ([]login_attempts.LoginAttempt, *errors.RestErr) {
+	return s.repository.GetLocked(ctx, date_utils.GetNow().Unix())
+}
+
+func (s *loginAttemptsService) ClearLockout(ctx context.Context, kind string, key string) *errors.RestErr {
+	switch kind {
+	case login_attempts.KindAccount:
+		key = normalizeEmail(key)
+	case login_attempts.KindIp:
+	default:
+		return errors.NewBadRequestError("kind must be account or ip")
+	}
+
+	return s.repository.Delete(ctx, kind, key)
+}
+
+// attemptsFor returns the counters a login of email from clientIp goes
+// through. The IP is left out when login.ip_lockout is off.
+func (s *loginAttemptsService) attemptsFor(email string, clientIp string) []login_attempts.LoginAttempt {
+	attempts := []login_attempts.LoginAttempt{{Kind: login_attempts.KindAccount, Key: normalizeEmail(email)}}
+	if s.config.IpLockout {
+		attempts = append(attempts, login_attempts.LoginAttempt{Kind: login_attempts.KindIp, Key: clientIp})
+	}
+	return attempts
+}
+
+func (s *loginAttemptsService) lockoutDuration(failures int, max int) time.Duration {
+	if failures < max {
+		return 0
+	}
+
+	base, ceiling := s.config.LockoutBase, s.config.LockoutMax
+
+	lockout := base
+	for i := max; i < failures && lockout < ceiling; i++ {
+		lockout *= 2
+	}
+	if lockout > ceiling {
+		return ceiling
+	}
+	return lockout
+}
+
+func (s *loginAttemptsService) maxFailures(kind string) int {
+	if kind == login_attempts.KindIp {
+		return s.config.MaxIpFailures
+	}
+	return s.config.MaxAccountFailures
+}
+
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(strings.ToLower(email))
+}
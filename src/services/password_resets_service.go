@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/password_resets"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/notifications"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/amirnep/shop/src/validation"
+)
+
+const (
+	resetTokenSize = 32
+)
+
+var (
+	// PasswordResetsService is set up by StartApplication.
+	PasswordResetsService passwordResetsServiceInterface
+)
+
+type passwordResetsService struct {
+	config     config.PasswordReset
+	repository password_resets.PasswordResetRepository
+	// sending tracks the reset links still being sent.
+	sending sync.WaitGroup
+}
+
+func NewPasswordResetsService(cfg config.PasswordReset, repository password_resets.PasswordResetRepository) passwordResetsServiceInterface {
+	return &passwordResetsService{config: cfg, repository: repository}
+}
+
+type passwordResetsServiceInterface interface {
+	RequestReset(context.Context, string) *errors.RestErr
+	Wait()
+	ResetPassword(context.Context, password_resets.ResetPasswordInput) *errors.RestErr
+}
+
+// RequestReset sends a reset link when email belongs to a user. Unknown
+// emails are not reported to the caller so that accounts cannot be
+// enumerated. The link is stored and sent in the background, so that a known
+// email is not answered any slower than an unknown one either.
+func (s *passwordResetsService) RequestReset(ctx context.Context, email string) *errors.RestErr {
+	user, err := UsersService.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err.Status == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	s.sending.Add(1)
+	go func() {
+		defer s.sending.Done()
+		s.sendReset(context.WithoutCancel(ctx), user)
+	}()
+	return nil
+}
+
+// Wait blocks until the reset links requested so far are sent.
+func (s *passwordResetsService) Wait() {
+	s.sending.Wait()
+}
+
+// sendReset replaces the reset link of the user and sends it. Failures are
+// only logged, the caller has been answered already.
+func (s *passwordResetsService) sendReset(ctx context.Context, user *users.User) {
+	// Only the newest link stays valid. The repository logs its errors.
+	if err := s.repository.InvalidateForUser(ctx, user.Id); err != nil {
+		return
+	}
+
+	raw, tokenErr := crypto_utils.GenerateRandomToken(resetTokenSize)
+	if tokenErr != nil {
+		logger.ErrorContext(ctx, "error when trying to generate password reset token", tokenErr)
+		return
+	}
+
+	now := date_utils.GetNow()
+	ttl := s.config.TTL
+	reset := &password_resets.PasswordReset{
+		UserId:      user.Id,
+		TokenHash:   crypto_utils.GetSha256(raw),
+		ExpiresAt:   date_utils.FormatDBTime(now.Add(ttl)),
+		DateCreated: date_utils.FormatDBTime(now),
+	}
+	if err := s.repository.Save(ctx, reset); err != nil {
+		return
+	}
+
+	message := notifications.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use the following link within %d minutes to choose a new password: %s",
+			int(ttl.Minutes()), s.resetLink(raw)),
+	}
+	if err := notifications.Sender.Send(message); err != nil {
+		logger.ErrorContext(ctx, "error when trying to send password reset notification", err)
+	}
+}
+
+func (s *passwordResetsService) ResetPassword(ctx context.Context, input password_resets.ResetPasswordInput) *errors.RestErr {
+	password := &users.Password{Password: input.Password, ConfirmPassword: input.ConfirmPassword}
+	if err := validation.ChangePasswordValidation(password); err != nil {
+		return err
+	}
+
+	reset, err := s.repository.GetByHash(ctx, crypto_utils.GetSha256(input.Token))
+	if err != nil {
+		return err
+	}
+
+	expiresAt, parseErr := date_utils.ParseDBTime(reset.ExpiresAt)
+	if reset.Used || parseErr != nil || !expiresAt.After(date_utils.GetNow()) {
+		return errors.NewBadRequestError("invalid or expired reset token")
+	}
+
+	consumed, err := s.repository.MarkUsed(ctx, reset.Id)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errors.NewBadRequestError("invalid or expired reset token")
+	}
+
+	return UsersService.EditPassword(ctx, reset.UserId, password)
+}
+
+func (s *passwordResetsService) resetLink(token string) string {
+	return withTokenQuery(s.config.URL, token)
+}
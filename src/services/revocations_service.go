@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/revocations"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+var (
+	// RevocationsService is set up by StartApplication.
+	RevocationsService revocationsServiceInterface
+)
+
+// revocationsService keeps the whole revocation list in memory so that token
+// validation never hits the database. Every replica reloads it periodically,
+// which bounds how long a token revoked on another replica stays usable.
+type revocationsService struct {
+	repository revocations.RevocationRepository
+	mu         sync.RWMutex
+	tokens     map[string]int64
+	users      map[int64]int64
+}
+
+func NewRevocationsService(repository revocations.RevocationRepository) revocationsServiceInterface {
+	return &revocationsService{
+		repository: repository,
+		tokens:     make(map[string]int64),
+		users:      make(map[int64]int64),
+	}
+}
+
+type revocationsServiceInterface interface {
+	Load(context.Context) *errors.RestErr
+	StartSync(context.Context, time.Duration)
+	IsRevoked(jti string, userId int64, issuedAtMillis int64) bool
+	RevokeToken(ctx context.Context, jti string, userId int64, expiresAt int64) *errors.RestErr
+	RevokeUser(context.Context, int64) *errors.RestErr
+}
+
+func (s *revocationsService) Load(ctx context.Context) *errors.RestErr {
+	now := date_utils.GetNow().Unix()
+
+	if err := s.repository.DeleteExpiredTokens(ctx, now); err != nil {
+		return err
+	}
+	revokedTokens, err := s.repository.GetActiveTokens(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	userRevocations, err := s.repository.GetUserRevocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The loaded entries are merged rather than swapped in, so that a
+	// revocation made here while the queries ran is not dropped.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, expiresAt := range s.tokens {
+		if expiresAt <= now {
+			delete(s.tokens, jti)
+		}
+	}
+	for _, token := range revokedTokens {
+		s.tokens[token.Jti] = token.ExpiresAt
+	}
+	for _, revocation := range userRevocations {
+		if revocation.RevokedBefore > s.users[revocation.UserId] {
+			s.users[revocation.UserId] = revocation.RevokedBefore
+		}
+	}
+	return nil
+}
+
+// StartSync reloads the revocation list every interval in the background.
+// Load errors are already logged by the DAO and the previous list is kept.
+func (s *revocationsService) StartSync(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Load(ctx)
+			}
+		}
+	}()
+}
+
+// IsRevoked reports whether the token was revoked, on its own or with every
+// token its user held at the time. issuedAtMillis is when the token was issued
+// in unix milliseconds.
+func (s *revocationsService) IsRevoked(jti string, userId int64, issuedAtMillis int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true
+	}
+	if revokedBefore, ok := s.users[userId]; ok && issuedAtMillis < revokedBefore {
+		return true
+	}
+	return false
+}
+
+func (s *revocationsService) RevokeToken(ctx context.Context, jti string, userId int64, expiresAt int64) *errors.RestErr {
+	token := revocations.RevokedToken{Jti: jti, UserId: userId, ExpiresAt: expiresAt}
+	if err := s.repository.SaveToken(ctx, token); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = expiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeUser invalidates every access and refresh token issued to the user so
+// far.
+func (s *revocationsService) RevokeUser(ctx context.Context, userId int64) *errors.RestErr {
+	revocation := revocations.UserRevocation{UserId: userId, RevokedBefore: date_utils.GetNow().UnixMilli()}
+	if err := s.repository.SaveUserRevocation(ctx, revocation); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userId] = revocation.RevokedBefore
+	s.mu.Unlock()
+
+	return TokensService.RevokeUserTokens(ctx, userId)
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/utils/errors"
+)
+
+var (
+	// RolesService is set up by StartApplication.
+	RolesService rolesServiceInterface
+)
+
+// rolesService caches the role to permission mapping so that permission
+// checks never hit the database. Changes made through this service apply
+// immediately; changes made on other replicas once the cache is reloaded.
+type rolesService struct {
+	repository  roles.RoleRepository
+	mu          sync.RWMutex
+	permissions map[string]map[string]bool
+}
+
+// NewRolesService returns a service with an empty cache. Load must be called
+// before the first permission check.
+func NewRolesService(repository roles.RoleRepository) rolesServiceInterface {
+	return &rolesService{
+		repository:  repository,
+		permissions: make(map[string]map[string]bool),
+	}
+}
+
+type rolesServiceInterface interface {
+	Load(context.Context) *errors.RestErr
+	StartSync(context.Context, time.Duration)
+	Exists(string) bool
+	HasPermission(string, string) bool
+	IsPrivileged(string) bool
+	Includes(string, string) bool
+	GetRoles(context.Context) ([]roles.Role, *errors.RestErr)
+	CreateRole(context.Context, string, roles.Role) (*roles.Role, *errors.RestErr)
+	SetPermissions(context.Context, string, string, []string) (*roles.Role, *errors.RestErr)
+	DeleteRole(context.Context, string) *errors.RestErr
+}
+
+func (s *rolesService) Load(ctx context.Context) *errors.RestErr {
+	all, err := s.repository.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	permissions := make(map[string]map[string]bool, len(all))
+	for _, role := range all {
+		granted := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			granted[permission] = true
+		}
+		permissions[role.Name] = granted
+	}
+
+	s.mu.Lock()
+	s.permissions = permissions
+	s.mu.Unlock()
+	return nil
+}
+
+// StartSync reloads the roles every interval in the background. Load errors
+// are already logged by the DAO and the previous mapping is kept.
+func (s *rolesService) StartSync(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Load(ctx)
+			}
+		}
+	}()
+}
+
+func (s *rolesService) Exists(role string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.permissions[role]
+	return ok
+}
+
+func (s *rolesService) HasPermission(role string, permission string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.permissions[role][permission]
+}
+
+// IsPrivileged reports whether the role was granted one of the permissions
+// that can take over other accounts, see roles.PrivilegedPermissions.
+func (s *rolesService) IsPrivileged(role string) bool {
+	for _, permission := range roles.PrivilegedPermissions {
+		if s.HasPermission(role, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether role was granted every permission of other, so
+// that whoever holds role gains nothing by being given other.
+func (s *rolesService) Includes(role string, other string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for permission := range s.permissions[other] {
+		if !s.permissions[role][permission] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *rolesService) GetRoles(ctx context.Context) ([]roles.Role, *errors.RestErr) {
+	return s.repository.GetAll(ctx)
+}
+
+// CreateRole adds a role on behalf of a caller with callerRole, who can only
+// grant permissions they hold themselves.
+func (s *rolesService) CreateRole(ctx context.Context, callerRole string, role roles.Role) (*roles.Role, *errors.RestErr) {
+	role.Name = strings.TrimSpace(strings.ToLower(role.Name))
+	if role.Name == "" {
+		return nil, errors.NewBadRequestError("invalid role name")
+	}
+	if s.Exists(role.Name) {
+		return nil, errors.NewBadRequestError("role already exists")
+	}
+
+	permissions, err := validatePermissions(role.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkGrant(callerRole, permissions); err != nil {
+		return nil, err
+	}
+	role.Permissions = permissions
+
+	if err := s.repository.Save(ctx, role); err != nil {
+		return nil, err
+	}
+	return &role, s.Load(ctx)
+}
+
+// SetPermissions replaces the permissions of a role on behalf of a caller
+// with callerRole. Like EditRole, the caller can neither change their own
+// role nor one with permissions they do not hold, and can only grant what
+// they hold.
+func (s *rolesService) SetPermissions(ctx context.Context, callerRole string, name string, permissions []string) (*roles.Role, *errors.RestErr) {
+	if !s.Exists(name) {
+		return nil, errors.NewNotFoundError("role not found")
+	}
+	if name == callerRole {
+		return nil, errors.NewForbiddenError("you cannot change the permissions of your own role")
+	}
+	// Taking permissions away from admin could lock everybody out of the
+	// role management endpoints.
+	if name == roles.RoleAdmin {
+		return nil, errors.NewBadRequestError("permissions of the admin role cannot be changed")
+	}
+
+	valid, err := validatePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Includes(callerRole, name) {
+		return nil, errors.NewForbiddenError("you cannot change a role with permissions you do not have")
+	}
+	if err := s.checkGrant(callerRole, valid); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.SetPermissions(ctx, name, valid); err != nil {
+		return nil, err
+	}
+	return &roles.Role{Name: name, Permissions: valid}, s.Load(ctx)
+}
+
+func (s *rolesService) DeleteRole(ctx context.Context, name string) *errors.RestErr {
+	if name == roles.RoleAdmin || name == roles.RoleUser {
+		return errors.NewBadRequestError("built-in roles cannot be deleted")
+	}
+	if !s.Exists(name) {
+		return errors.NewNotFoundError("role not found")
+	}
+
+	count, err := UsersService.CountUsersWithRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.NewBadRequestError("role is still assigned to users")
+	}
+
+	if err := s.repository.SetPermissions(ctx, name, nil); err != nil {
+		return err
+	}
+	if err := s.repository.Delete(ctx, name); err != nil {
+		return err
+	}
+	return s.Load(ctx)
+}
+
+// checkGrant refuses permissions that callerRole was not granted.
+func (s *rolesService) checkGrant(callerRole string, permissions []string) *errors.RestErr {
+	for _, permission := range permissions {
+		if !s.HasPermission(callerRole, permission) {
+			return errors.NewForbiddenError("you cannot grant permissions you do not have: " + permission)
+		}
+	}
+	return nil
+}
+
+func validatePermissions(permissions []string) ([]string, *errors.RestErr) {
+	seen := make(map[string]bool, len(permissions))
+	result := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !roles.IsPermission(permission) {
+			return nil, errors.NewBadRequestError("unknown permission " + permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			result = append(result, permission)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/refresh_tokens"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/logger"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	refreshTokenSize = 32
+)
+
+var (
+	// TokensService is set up by StartApplication.
+	TokensService tokensServiceInterface
+)
+
+type tokensService struct {
+	refreshTokenTTL time.Duration
+	repository      refresh_tokens.RefreshTokenRepository
+}
+
+func NewTokensService(cfg config.JWT, repository refresh_tokens.RefreshTokenRepository) tokensServiceInterface {
+	return &tokensService{refreshTokenTTL: cfg.RefreshTokenTTL, repository: repository}
+}
+
+type tokensServiceInterface interface {
+	CreateRefreshToken(context.Context, int64, bool) (string, *errors.RestErr)
+	RotateRefreshToken(context.Context, string) (*users.User, string, bool, *errors.RestErr)
+	RevokeRefreshToken(context.Context, int64, string) *errors.RestErr
+	RevokeUserTokens(context.Context, int64) *errors.RestErr
+	RefreshTokenTTL() time.Duration
+}
+
+// CreateRefreshToken starts a new token family for the user and returns the
+// opaque token. Only its SHA-256 hash is persisted. mfa records whether the
+// login passed two-factor authentication and is kept across rotations.
+func (s *tokensService) CreateRefreshToken(ctx context.Context, userId int64, mfa bool) (string, *errors.RestErr) {
+	return s.issue(ctx, userId, uuid.New().String(), mfa)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that was already rotated is treated as theft and
+// revokes every token of its family.
+func (s *tokensService) RotateRefreshToken(ctx context.Context, raw string) (*users.User, string, bool, *errors.RestErr) {
+	current, err := s.repository.GetByHash(ctx, crypto_utils.GetSha256(raw))
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if current.Revoked {
+		return nil, "", false, errors.NewUnauthorizedError("invalid refresh token")
+	}
+
+	if current.Used {
+		return nil, "", false, s.revokeReusedFamily(ctx, current)
+	}
+
+	expiresAt, parseErr := date_utils.ParseDBTime(current.ExpiresAt)
+	if parseErr != nil || !expiresAt.After(date_utils.GetNow()) {
+		return nil, "", false, errors.NewUnauthorizedError("refresh token expired")
+	}
+
+	rotated, err := s.repository.MarkUsed(ctx, current.Id)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if !rotated {
+		return nil, "", false, s.revokeReusedFamily(ctx, current)
+	}
+
+	user, err := UsersService.GetUser(ctx, current.UserId)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	token, err := s.issue(ctx, current.UserId, current.FamilyId, current.Mfa)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return user, token, current.Mfa, nil
+}
+
+// RevokeRefreshToken revokes the family of a refresh token owned by userId.
+// Unknown tokens are ignored so that logging out twice is not an error.
+func (s *tokensService) RevokeRefreshToken(ctx context.Context, userId int64, raw string) *errors.RestErr {
+	current, err := s.repository.GetByHash(ctx, crypto_utils.GetSha256(raw))
+	if err != nil {
+		if err.Status == http.StatusUnauthorized {
+			return nil
+		}
+		return err
+	}
+
+	if current.UserId != userId {
+		return errors.NewUnauthorizedError("invalid refresh token")
+	}
+	return s.repository.RevokeFamily(ctx, current.FamilyId)
+}
+
+// RevokeUserTokens revokes every refresh token of the user.
+func (s *tokensService) RevokeUserTokens(ctx context.Context, userId int64) *errors.RestErr {
+	return s.repository.RevokeAllForUser(ctx, userId)
+}
+
+func (s *tokensService) RefreshTokenTTL() time.Duration {
+	return s.refreshTokenTTL
+}
+
+func (s *tokensService) issue(ctx context.Context, userId int64, familyId string, mfa bool) (string, *errors.RestErr) {
+	raw, err := crypto_utils.GenerateRandomToken(refreshTokenSize)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to generate refresh token", err)
+		return "", errors.NewInternalServerError("error when trying to create refresh token")
+	}
+
+	now := date_utils.GetNow()
+	token := &refresh_tokens.RefreshToken{
+		UserId:      userId,
+		FamilyId:    familyId,
+		TokenHash:   crypto_utils.GetSha256(raw),
+		Mfa:         mfa,
+		ExpiresAt:   date_utils.FormatDBTime(now.Add(s.RefreshTokenTTL())),
+		DateCreated: date_utils.FormatDBTime(now),
+	}
+	if err := s.repository.Save(ctx, token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func (s *tokensService) revokeReusedFamily(ctx context.Context, token *refresh_tokens.RefreshToken) *errors.RestErr {
+	logger.InfoContext(ctx, "refresh token reuse detected, revoking token family",
+		zap.Int64("user_id", token.UserId),
+		zap.String("family_id", token.FamilyId),
+	)
+	if err := s.repository.RevokeFamily(ctx, token.FamilyId); err != nil {
+		return err
+	}
+	return errors.NewUnauthorizedError("refresh token reuse detected")
+}
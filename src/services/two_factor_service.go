@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/logger"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/amirnep/shop/src/utils/totp"
+)
+
+const (
+	recoveryCodesCount = 10
+	recoveryCodeSize   = 8
+)
+
+var (
+	// TwoFactorService is set up by StartApplication.
+	TwoFactorService twoFactorServiceInterface
+)
+
+type twoFactorService struct {
+	config     config.TwoFactor
+	repository two_factor.TwoFactorRepository
+}
+
+func NewTwoFactorService(cfg config.TwoFactor, repository two_factor.TwoFactorRepository) twoFactorServiceInterface {
+	return &twoFactorService{config: cfg, repository: repository}
+}
+
+type twoFactorServiceInterface interface {
+	Enroll(context.Context, int64) (string, string, *errors.RestErr)
+	Confirm(context.Context, int64, string, string) ([]string, *errors.RestErr)
+	Disable(context.Context, int64, string, string, string) *errors.RestErr
+	IsEnabled(context.Context, int64) (bool, *errors.RestErr)
+	Verify(context.Context, int64, string, string) *errors.RestErr
+	CompleteLogin(context.Context, two_factor.Challenge, two_factor.VerifyInput, string) (*users.User, *errors.RestErr)
+	RequiredForRole(string) bool
+}
+
+// Enroll generates a new secret for the user and returns it together with its
+// otpauth URI. Two-factor authentication stays off until Confirm succeeds.
+func (s *twoFactorService) Enroll(ctx context.Context, userId int64) (string, string, *errors.RestErr) {
+	user, err := UsersService.GetUser(ctx, userId)
+	if err != nil {
+		return "", "", err
+	}
+
+	if enabled, err := s.IsEnabled(ctx, userId); err != nil {
+		return "", "", err
+	} else if enabled {
+		return "", "", errors.NewBadRequestError("two-factor authentication is already enabled")
+	}
+
+	secret, secretErr := totp.GenerateSecret()
+	if secretErr != nil {
+		logger.ErrorContext(ctx, "error when trying to generate totp secret", secretErr)
+		return "", "", errors.NewInternalServerError("error when trying to enroll two-factor authentication")
+	}
+
+	twoFactor := &two_factor.TwoFactor{UserId: userId, Secret: secret, DateCreated: date_utils.GetNowDBFormat()}
+	if err := s.repository.Save(ctx, twoFactor); err != nil {
+		return "", "", err
+	}
+
+	return secret, totp.URI(s.config.Issuer, user.Email, secret), nil
+}
+
+// Confirm enables two-factor authentication once the user proves the
+// authenticator app works, and returns the recovery codes in plain text. They
+// are only stored hashed and cannot be shown again.
+func (s *twoFactorService) Confirm(ctx context.Context, userId int64, code string, clientIp string) ([]string, *errors.RestErr) {
+	twoFactor, err := s.repository.Get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, errors.NewBadRequestError("two-factor authentication is already enabled")
+	}
+
+	if err := s.limitGuesses(ctx, userId, clientIp, func() *errors.RestErr {
+		return s.checkCode(ctx, twoFactor, code)
+	}); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.replaceRecoveryCodes(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.Enable(ctx, userId); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off once the user proved it with a
+// code or a recovery code.
+func (s *twoFactorService) Disable(ctx context.Context, userId int64, code string, recoveryCode string, clientIp string) *errors.RestErr {
+	if err := s.limitGuesses(ctx, userId, clientIp, func() *errors.RestErr {
+		return s.Verify(ctx, userId, code, recoveryCode)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.repository.Delete(ctx, userId); err != nil {
+		return err
+	}
+	return s.repository.DeleteRecoveryCodes(ctx, userId)
+}
+
+func (s *twoFactorService) IsEnabled(ctx context.Context, userId int64) (bool, *errors.RestErr) {
+	twoFactor, err := s.repository.Get(ctx, userId)
+	if err != nil {
+		if err.Status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return twoFactor.Enabled, nil
+}
+
+// Verify accepts either a current TOTP code or one of the recovery codes,
+// which is consumed.
+func (s *twoFactorService) Verify(ctx context.Context, userId int64, code string, recoveryCode string) *errors.RestErr {
+	twoFactor, err := s.repository.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !twoFactor.Enabled {
+		return errors.NewBadRequestError("two-factor authentication is not enabled")
+	}
+
+	if code != "" {
+		return s.checkCode(ctx, twoFactor, code)
+	}
+
+	if recoveryCode != "" {
+		used, err := s.repository.UseRecoveryCode(ctx, userId, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errors.NewUnauthorizedError("invalid two-factor code")
+		}
+		return nil
+	}
+
+	return errors.NewBadRequestError("code or recovery_code is required")
+}
+
+// CompleteLogin verifies the code sent with a login challenge and returns the
+// user it was issued to. Wrong codes count as failed logins of the account,
+// so guessing is locked out like guessing passwords. The failure counter is
+// only reset here, not by the password, so logging in again does not buy more
+// guesses. The challenge is revoked once it succeeded or the account got
+// locked, so it cannot be replayed.
+func (s *twoFactorService) CompleteLogin(ctx context.Context, challenge two_factor.Challenge, input two_factor.VerifyInput, clientIp string) (*users.User, *errors.RestErr) {
+	user, err := UsersService.GetUser(ctx, challenge.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := LoginAttemptsService.CheckAllowed(ctx, user.Email, clientIp); err != nil {
+		if revokeErr := s.revokeChallenge(ctx, challenge); revokeErr != nil {
+			return nil, revokeErr
+		}
+		return nil, err
+	}
+
+	if err := s.Verify(ctx, user.Id, input.Code, input.RecoveryCode); err != nil {
+		if err.Status != http.StatusUnauthorized {
+			return nil, err
+		}
+		if failErr := LoginAttemptsService.RegisterFailure(ctx, user.Email, clientIp); failErr != nil {
+			return nil, failErr
+		}
+		if lockErr := LoginAttemptsService.CheckAllowed(ctx, user.Email, clientIp); lockErr != nil {
+			if revokeErr := s.revokeChallenge(ctx, challenge); revokeErr != nil {
+				return nil, revokeErr
+			}
+		}
+		return nil, err
+	}
+
+	if err := s.revokeChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+	if err := LoginAttemptsService.RegisterSuccess(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// limitGuesses runs check unless the account of the user is locked, and
+// counts a wrong code as a failed login. A stolen access token can then not
+// be used to guess codes either.
+func (s *twoFactorService) limitGuesses(ctx context.Context, userId int64, clientIp string, check func() *errors.RestErr) *errors.RestErr {
+	user, err := UsersService.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if err := LoginAttemptsService.CheckAllowed(ctx, user.Email, clientIp); err != nil {
+		return err
+	}
+
+	if err := check(); err != nil {
+		if err.Status != http.StatusUnauthorized {
+			return err
+		}
+		if failErr := LoginAttemptsService.RegisterFailure(ctx, user.Email, clientIp); failErr != nil {
+			return failErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *twoFactorService) revokeChallenge(ctx context.Context, challenge two_factor.Challenge) *errors.RestErr {
+	return RevocationsService.RevokeToken(ctx, challenge.Jti, challenge.UserId, challenge.ExpiresAt)
+}
+
+// RequiredForRole reports whether tokens of role must have passed two-factor
+// authentication. With two_factor.require_for_admin that is every role
+// granted a privileged permission, not only the built-in admin role.
+func (s *twoFactorService) RequiredForRole(role string) bool {
+	return s.config.RequireForAdmin && RolesService.IsPrivileged(role)
+}
+
+func (s *twoFactorService) checkCode(ctx context.Context, twoFactor *two_factor.TwoFactor, code string) *errors.RestErr {
+	step, ok := totp.Validate(twoFactor.Secret, strings.TrimSpace(code), date_utils.GetNow(), twoFactor.LastStep)
+	if !ok {
+		return errors.NewUnauthorizedError("invalid two-factor code")
+	}
+
+	fresh, err := s.repository.UseStep(ctx, twoFactor.UserId, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errors.NewUnauthorizedError("invalid two-factor code")
+	}
+	return nil
+}
+
+func (s *twoFactorService) replaceRecoveryCodes(ctx context.Context, userId int64) ([]string, *errors.RestErr) {
+	if err := s.repository.DeleteRecoveryCodes(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		raw, tokenErr := crypto_utils.GenerateRandomToken(recoveryCodeSize)
+		if tokenErr != nil {
+			logger.ErrorContext(ctx, "error when trying to generate recovery code", tokenErr)
+			return nil, errors.NewInternalServerError("error when trying to generate recovery codes")
+		}
+
+		code := &two_factor.RecoveryCode{UserId: userId, CodeHash: hashRecoveryCode(raw)}
+		if err := s.repository.SaveRecoveryCode(ctx, code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw)
+	}
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return crypto_utils.GetSha256(strings.TrimSpace(code))
+}
@@ -1,135 +1,496 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amirnep/shop/src/domain/roles"
 	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/metrics"
+	"github.com/amirnep/shop/src/tracing"
 	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
 	"github.com/amirnep/shop/src/validation"
 
 	"github.com/amirnep/shop/src/utils/date_utils"
 	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/amirnep/shop/src/utils/json_utils"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+	// maxNameLength is the size of the first_name and last_name columns.
+	maxNameLength = 100
+	// maxUpdateAttempts bounds how often UpdateUser re-applies a patch to a
+	// profile that changed while it was being patched.
+	maxUpdateAttempts = 3
 )
 
 var (
-	UsersService usersServiceInterface = &usersService{}
+	// UsersService is set up by StartApplication.
+	UsersService usersServiceInterface
 )
 
-type usersService struct{}
+type usersService struct {
+	repository users.UserRepository
+}
+
+func NewUsersService(repository users.UserRepository) usersServiceInterface {
+	return &usersService{repository: repository}
+}
 
 type usersServiceInterface interface {
-	GetUser(int64) (*users.User, *errors.RestErr)
-	GetAll() ([]users.User, *errors.RestErr)
-	CreateUser(*users.User)(*users.User,*errors.RestErr)
-	UpdateUser(bool, users.User) (*users.User, *errors.RestErr)
-	DeleteUser(int64) *errors.RestErr
-	Login(string) (*users.User, *errors.RestErr)
-	GetProfile(int64) (*users.User, *errors.RestErr)
-	EditRole(int64) *errors.RestErr
-	EditPassword(int64, *users.Password) *errors.RestErr
-}
-
-func (s *usersService) GetUser(userId int64) (*users.User, *errors.RestErr) {
-	dao := &users.User{Id: userId}
-	if err := dao.Get(); err != nil {
+	GetUser(context.Context, int64) (*users.User, *errors.RestErr)
+	GetUserByEmail(context.Context, string) (*users.User, *errors.RestErr)
+	ExportUsers(context.Context, users.ListQuery) (users.UserIterator, *errors.RestErr)
+	ListUsers(context.Context, users.ListQuery) (*users.UserPage, *errors.RestErr)
+	CreateUser(context.Context, *users.User) (*users.User, *errors.RestErr)
+	UpdateUser(context.Context, int64, users.ProfilePatch) (*users.User, *errors.RestErr)
+	DeleteUser(context.Context, int64) *errors.RestErr
+	Login(context.Context, users.LoginInput, string) (*users.User, *errors.RestErr)
+	GetProfile(context.Context, int64) (*users.User, *errors.RestErr)
+	EditRole(context.Context, int64, int64, string) *errors.RestErr
+	CountUsersWithRole(context.Context, string) (int64, *errors.RestErr)
+	EditPassword(context.Context, int64, *users.Password) *errors.RestErr
+	VerifyEmail(context.Context, int64, string) *errors.RestErr
+	MarkVerificationSent(context.Context, int64, time.Time, time.Time) (bool, *errors.RestErr)
+	UnmarkVerificationSent(context.Context, int64, time.Time) *errors.RestErr
+	ImageReferenced(context.Context, string) (bool, *errors.RestErr)
+	ReplaceImage(context.Context, int64, string, string) (bool, *errors.RestErr)
+}
+
+func (s *usersService) GetUser(ctx context.Context, userId int64) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.GetUser")
+	defer span.End()
+
+	return s.repository.Get(ctx, userId)
+}
+
+// GetUserByEmail returns the user including the password hash.
+func (s *usersService) GetUserByEmail(ctx context.Context, email string) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.GetUserByEmail")
+	defer span.End()
+
+	return s.repository.GetByEmail(ctx, normalizeEmail(email))
+}
+
+// ExportUsers returns every user matching the filters of query, in id order.
+// Paging and sorting parameters are ignored. The caller must close the
+// iterator.
+func (s *usersService) ExportUsers(ctx context.Context, query users.ListQuery) (users.UserIterator, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.ExportUsers")
+	defer span.End()
+
+	options, err := listOptions(query)
+	if err != nil {
 		return nil, err
 	}
-	return dao, nil
+	return s.repository.Iterate(ctx, options.Filter)
 }
 
-func (s *usersService) GetAll() ([]users.User, *errors.RestErr) {
-	dao := &users.User{}
-	res, err := dao.GetAll(); if err != nil {
+// ListUsers returns one page of users. Pages are fetched with one extra row
+// to tell whether a next cursor is needed.
+func (s *usersService) ListUsers(ctx context.Context, query users.ListQuery) (*users.UserPage, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.ListUsers")
+	defer span.End()
+
+	options, err := listOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := options.Limit
+	options.Limit++
+	result, err := s.repository.List(ctx, *options)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.repository.Count(ctx, options.Filter)
+	if err != nil {
 		return nil, err
 	}
-	return res, nil
+
+	page := &users.UserPage{Users: result, Total: total, Limit: limit, Offset: options.Offset}
+	if len(result) > limit {
+		page.Users = result[:limit]
+		last := page.Users[limit-1]
+		page.NextCursor = users.Cursor{
+			Sort:  options.Sort,
+			Desc:  options.Desc,
+			Value: last.SortValue(options.Sort),
+			Id:    last.Id,
+		}.Encode()
+	}
+	return page, nil
 }
 
-func (s *usersService) CreateUser(user *users.User) (*users.User, *errors.RestErr) {
+func listOptions(query users.ListQuery) (*users.ListOptions, *errors.RestErr) {
+	options := &users.ListOptions{
+		Filter: users.UserFilter{
+			Role:        strings.TrimSpace(query.Role),
+			EmailPrefix: normalizeEmail(query.Email),
+			Name:        strings.TrimSpace(query.Name),
+		},
+		Sort:   users.SortId,
+		Offset: query.Offset,
+		Limit:  query.Limit,
+	}
+
+	switch {
+	case query.Limit == 0:
+		options.Limit = defaultListLimit
+	case query.Limit < 0:
+		return nil, errors.NewBadRequestError("limit must be positive")
+	case query.Limit > maxListLimit:
+		options.Limit = maxListLimit
+	}
+
+	if query.Offset < 0 {
+		return nil, errors.NewBadRequestError("offset must not be negative")
+	}
+
+	if query.Sort != "" {
+		options.Sort = query.Sort
+		if !isSortColumn(query.Sort) {
+			return nil, errors.NewBadRequestError("sort must be one of " + strings.Join(users.SortColumns, ", "))
+		}
+	}
+
+	switch strings.ToLower(query.Order) {
+	case "", "asc":
+	case "desc":
+		options.Desc = true
+	default:
+		return nil, errors.NewBadRequestError("order must be asc or desc")
+	}
+
+	if query.Cursor != "" {
+		if query.Offset != 0 {
+			return nil, errors.NewBadRequestError("cursor and offset cannot be combined")
+		}
+		cursor, cursorErr := users.DecodeCursor(query.Cursor)
+		if cursorErr != nil || cursor.Sort != options.Sort || cursor.Desc != options.Desc {
+			return nil, errors.NewBadRequestError("invalid cursor")
+		}
+		options.After = cursor
+	}
+
+	var dateErr error
+	if query.CreatedAfter != "" {
+		if options.Filter.CreatedAfter, dateErr = date_utils.ParseQueryDate(query.CreatedAfter); dateErr != nil {
+			return nil, errors.NewBadRequestError("invalid created_after date")
+		}
+	}
+	if query.CreatedBefore != "" {
+		if options.Filter.CreatedBefore, dateErr = date_utils.ParseQueryDate(query.CreatedBefore); dateErr != nil {
+			return nil, errors.NewBadRequestError("invalid created_before date")
+		}
+	}
+	return options, nil
+}
+
+func isSortColumn(column string) bool {
+	for _, sortColumn := range users.SortColumns {
+		if column == sortColumn {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *usersService) CreateUser(ctx context.Context, user *users.User) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.CreateUser")
+	defer span.End()
+
 	if err := validation.Validate(user); err != nil {
 		return nil, err
 	}
 
-	user.Role = "user"
+	user.Role = roles.RoleUser
 	user.DateCreated = date_utils.GetNowDBFormat()
-	user.Password = crypto_utils.GetMd5(user.Password)
-	user.ConfirmPassword = crypto_utils.GetMd5(user.ConfirmPassword)
+	hash, hashErr := crypto_utils.HashPassword(user.Password)
+	if hashErr != nil {
+		logger.ErrorContext(ctx, "error when trying to hash user password", hashErr)
+		return nil, errors.NewInternalServerError("error when trying to save user")
+	}
+	user.Password = hash
+	user.ConfirmPassword = hash
 
-	if err := user.Save(); err != nil {
+	if err := s.repository.Save(ctx, user); err != nil {
 		return nil, err
 	}
+
+	// The account exists at this point, a failed email only means the user has
+	// to ask for a new one.
+	EmailVerificationsService.SendVerification(ctx, user)
 	return user, nil
 }
 
-func (s *usersService) UpdateUser(isPartial bool, user users.User) (*users.User, *errors.RestErr) {
-	current := &users.User{Id: user.Id}
-	if err := current.Get(); err != nil {
+// UpdateUser applies patch to the profile of the user, with JSON Merge Patch
+// semantics: a member that is absent is left as it is, null removes it and
+// any other value replaces it. The image the user had before is deleted once
+// it is replaced or removed.
+//
+// The profile is only saved if it did not change since it was read, otherwise
+// the patch is applied again to the new profile, so concurrent updates of
+// different fields are never lost.
+func (s *usersService) UpdateUser(ctx context.Context, userId int64, patch users.ProfilePatch) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.UpdateUser")
+	defer span.End()
+
+	document := patch.Document
+	if len(bytes.TrimSpace(document)) == 0 {
+		document = json.RawMessage("{}")
+	}
+	if err := checkProfilePatch(document); err != nil {
 		return nil, err
 	}
 
-	if isPartial {
-		if user.FirstName != "" {
-			current.FirstName = user.FirstName
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		current, err := s.repository.Get(ctx, userId)
+		if err != nil {
+			return nil, err
 		}
-
-		if user.LastName != "" {
-			current.LastName = user.LastName
+		updated, err := applyProfilePatch(*current, document, patch.ImageUrl)
+		if err != nil {
+			return nil, err
+		}
+		if updated.FirstName == current.FirstName && updated.LastName == current.LastName && updated.ImageUrl == current.ImageUrl {
+			return updated, nil
 		}
 
-		if user.ImageUrl != "" {
-			current.ImageUrl = user.ImageUrl
+		saved, err := s.repository.Update(ctx, updated, current)
+		if err != nil {
+			return nil, err
+		}
+		if !saved {
+			continue
 		}
-	} else {
-		current.FirstName = user.FirstName
-		current.LastName = user.LastName
-		current.ImageUrl = user.ImageUrl
+		if updated.ImageUrl != current.ImageUrl {
+			ImagesService.DeleteImage(ctx, current.ImageUrl)
+		}
+		return updated, nil
+	}
+	return nil, errors.NewConflictError("the profile was changed concurrently, try again")
+}
+
+// applyProfilePatch returns user with the merge patch document applied and,
+// when imageUrl is set, the uploaded image.
+func applyProfilePatch(user users.User, document json.RawMessage, imageUrl string) (*users.User, *errors.RestErr) {
+	target, _ := json.Marshal(users.ProfileDocument{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		ImageUrl:  user.ImageUrl,
+	})
+	merged, mergeErr := json_utils.MergePatch(target, document)
+	if mergeErr != nil {
+		return nil, errors.NewBadRequestError("invalid merge patch")
+	}
+	var profile users.ProfileDocument
+	if err := json.Unmarshal(merged, &profile); err != nil {
+		return nil, errors.NewBadRequestError("first_name and last_name must be strings")
 	}
 
-	if err := current.Update(); err != nil {
-		return nil, err
+	user.FirstName = strings.TrimSpace(profile.FirstName)
+	user.LastName = strings.TrimSpace(profile.LastName)
+	user.ImageUrl = profile.ImageUrl
+	if imageUrl != "" {
+		user.ImageUrl = imageUrl
 	}
-	return current, nil
+	if utf8.RuneCountInString(user.FirstName) > maxNameLength || utf8.RuneCountInString(user.LastName) > maxNameLength {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("first_name and last_name must not be longer than %d characters", maxNameLength))
+	}
+	return &user, nil
 }
 
-func (s *usersService) DeleteUser(userId int64) *errors.RestErr {
-	current := &users.User{Id: userId}
-	if err := current.Get(); err != nil {
+// checkProfilePatch only accepts an object with the members of the profile
+// document, so that a client trying to change e.g. its email here gets an
+// error instead of being silently ignored.
+func checkProfilePatch(document json.RawMessage) *errors.RestErr {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(document, &members); err != nil || members == nil {
+		return errors.NewBadRequestError("profile patch must be a JSON object")
+	}
+	for name, value := range members {
+		switch name {
+		case "first_name", "last_name":
+		case "image_url":
+			if string(bytes.TrimSpace(value)) != "null" {
+				return errors.NewBadRequestError("image_url can only be removed with null, upload a new image instead")
+			}
+		default:
+			return errors.NewBadRequestError(fmt.Sprintf("%s cannot be changed in the profile", name))
+		}
+	}
+	return nil
+}
+
+func (s *usersService) DeleteUser(ctx context.Context, userId int64) *errors.RestErr {
+	ctx, span := tracing.Start(ctx, "usersService.DeleteUser")
+	defer span.End()
+
+	user, err := s.repository.Get(ctx, userId)
+	if err != nil {
 		return errors.NewBadRequestError("user does not exist")
 	}
-	return current.Delete()
+
+	if err := s.repository.Delete(ctx, userId); err != nil {
+		return err
+	}
+	ImagesService.DeleteImage(ctx, user.ImageUrl)
+	return RevocationsService.RevokeUser(ctx, userId)
 }
 
-func (s *usersService) Login(email string) (*users.User, *errors.RestErr) {
-	dao := &users.User{Email: email}
-	if err := dao.Login(); err != nil {
+// Login checks the credentials of a user. Unknown emails and wrong passwords
+// get the same error after the same amount of work, and repeated failures
+// lock the email and the client IP for a while.
+func (s *usersService) Login(ctx context.Context, input users.LoginInput, clientIp string) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.Login")
+	defer span.End()
+
+	if err := LoginAttemptsService.CheckAllowed(ctx, input.Email, clientIp); err != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginLocked).Inc()
 		return nil, err
 	}
-	return dao, nil
-}
 
-func (s *usersService) GetProfile(userId int64) (*users.User, *errors.RestErr) {
-	dao := &users.User{Id: userId}
-	if err := dao.Get(); err != nil {
+	user, err := s.GetUserByEmail(ctx, input.Email)
+	if err != nil {
+		if err.Status != http.StatusNotFound {
+			metrics.Logins.WithLabelValues(metrics.LoginError).Inc()
+			return nil, err
+		}
+		crypto_utils.VerifyDummy(input.Password)
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		return nil, s.loginFailed(ctx, input.Email, clientIp)
+	}
+
+	match, rehash, verifyErr := crypto_utils.VerifyPassword(input.Password, user.Password)
+	if verifyErr != nil {
+		logger.ErrorContext(ctx, "error when trying to verify user password", verifyErr)
+		metrics.Logins.WithLabelValues(metrics.LoginError).Inc()
+		return nil, errors.NewInternalServerError("error when trying to verify password")
+	}
+	if !match {
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		return nil, s.loginFailed(ctx, input.Email, clientIp)
+	}
+
+	// With two-factor authentication the login is only complete once the
+	// code passed, TwoFactorService.CompleteLogin resets the failures then.
+	// Resetting them here would let the password buy unlimited guesses.
+	twoFactorEnabled, err := TwoFactorService.IsEnabled(ctx, user.Id)
+	if err != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginError).Inc()
 		return nil, err
 	}
-	return dao, nil
+	if !twoFactorEnabled {
+		if err := LoginAttemptsService.RegisterSuccess(ctx, input.Email); err != nil {
+			metrics.Logins.WithLabelValues(metrics.LoginError).Inc()
+			return nil, err
+		}
+	}
+
+	if !user.EmailVerified && EmailVerificationsService.RequireVerifiedEmail() {
+		metrics.Logins.WithLabelValues(metrics.LoginUnverified).Inc()
+		return nil, errors.NewForbiddenError("email address is not verified")
+	}
+
+	if rehash {
+		s.upgradePasswordHash(ctx, user.Id, input.Password)
+	}
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+	return user, nil
+}
+
+func (s *usersService) loginFailed(ctx context.Context, email string, clientIp string) *errors.RestErr {
+	if err := LoginAttemptsService.RegisterFailure(ctx, email, clientIp); err != nil {
+		return err
+	}
+	return errors.NewUnauthorizedError("invalid email or password")
+}
+
+// upgradePasswordHash replaces a legacy or outdated hash once the plain
+// password is known. Failures are only logged so that login still succeeds.
+func (s *usersService) upgradePasswordHash(ctx context.Context, userId int64, password string) {
+	hash, err := crypto_utils.HashPassword(password)
+	if err != nil {
+		logger.ErrorContext(ctx, "error when trying to rehash user password", err)
+		return
+	}
+
+	// EditPassword already logs the underlying database error.
+	s.repository.EditPassword(ctx, userId, hash)
+}
+
+func (s *usersService) GetProfile(ctx context.Context, userId int64) (*users.User, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.GetProfile")
+	defer span.End()
+
+	return s.repository.Get(ctx, userId)
 }
 
-func (s *usersService) EditRole(userId int64) (*errors.RestErr) {
-	current := &users.User{Id: userId}
-	if err := current.Get(); err != nil {
+// EditRole gives the user role on behalf of the caller. Callers can neither
+// change their own role nor hand out or take away a role with permissions
+// they do not have themselves, and the last admin cannot be demoted.
+func (s *usersService) EditRole(ctx context.Context, callerId int64, userId int64, role string) (*errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.EditRole")
+	defer span.End()
+
+	if !RolesService.Exists(role) {
+		return errors.NewBadRequestError("role does not exist")
+	}
+	if callerId == userId {
+		return errors.NewForbiddenError("you cannot change your own role")
+	}
+
+	caller, err := s.repository.Get(ctx, callerId)
+	if err != nil {
 		return err
 	}
+	user, err := s.repository.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !RolesService.Includes(caller.Role, role) || !RolesService.Includes(caller.Role, user.Role) {
+		return errors.NewForbiddenError("you cannot assign a role with permissions you do not have")
+	}
 
-	current.Role = "admin"
+	if user.Role == roles.RoleAdmin && role != roles.RoleAdmin {
+		admins, err := s.CountUsersWithRole(ctx, roles.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return errors.NewBadRequestError("the last admin cannot be demoted")
+		}
+	}
 
-	if err := current.EditRole(); err != nil {
+	if err := s.repository.EditRole(ctx, userId, role); err != nil {
 		return err
 	}
-	return nil
+	return RevocationsService.RevokeUser(ctx, userId)
+}
+
+func (s *usersService) CountUsersWithRole(ctx context.Context, role string) (int64, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.CountUsersWithRole")
+	defer span.End()
+
+	return s.repository.Count(ctx, users.UserFilter{Role: role})
 }
 
-func (s *usersService) EditPassword(userId int64, user *users.Password) (*errors.RestErr) {
-	current := &users.User{Id: userId}
-	if err := current.Get(); err != nil {
+func (s *usersService) EditPassword(ctx context.Context, userId int64, user *users.Password) (*errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.EditPassword")
+	defer span.End()
+
+	if _, err := s.repository.Get(ctx, userId); err != nil {
 		return err
 	}
 
@@ -137,11 +498,51 @@ func (s *usersService) EditPassword(userId int64, user *users.Password) (*errors
 		return errors.NewBadRequestError("password not valid")
 	}
 
-	current.Password = crypto_utils.GetMd5(user.Password)
-	current.ConfirmPassword = crypto_utils.GetMd5(user.ConfirmPassword)
+	hash, hashErr := crypto_utils.HashPassword(user.Password)
+	if hashErr != nil {
+		logger.ErrorContext(ctx, "error when trying to hash user password", hashErr)
+		return errors.NewInternalServerError("error when trying to change password")
+	}
 
-	if err := current.EditPassword(); err != nil {
+	if err := s.repository.EditPassword(ctx, userId, hash); err != nil {
 		return err
 	}
-	return nil
-}
\ No newline at end of file
+	return RevocationsService.RevokeUser(ctx, userId)
+}
+
+func (s *usersService) VerifyEmail(ctx context.Context, userId int64, email string) *errors.RestErr {
+	ctx, span := tracing.Start(ctx, "usersService.VerifyEmail")
+	defer span.End()
+
+	return s.repository.VerifyEmail(ctx, userId, email)
+}
+
+func (s *usersService) MarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time, throttleBefore time.Time) (bool, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.MarkVerificationSent")
+	defer span.End()
+
+	return s.repository.MarkVerificationSent(ctx, userId, sentAt, throttleBefore)
+}
+
+func (s *usersService) UnmarkVerificationSent(ctx context.Context, userId int64, sentAt time.Time) *errors.RestErr {
+	ctx, span := tracing.Start(ctx, "usersService.UnmarkVerificationSent")
+	defer span.End()
+
+	return s.repository.UnmarkVerificationSent(ctx, userId, sentAt)
+}
+
+func (s *usersService) ImageReferenced(ctx context.Context, key string) (bool, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.ImageReferenced")
+	defer span.End()
+
+	return s.repository.ImageReferenced(ctx, key)
+}
+
+// ReplaceImage points the user to newURL unless the image was changed since
+// oldURL was read.
+func (s *usersService) ReplaceImage(ctx context.Context, userId int64, oldURL string, newURL string) (bool, *errors.RestErr) {
+	ctx, span := tracing.Start(ctx, "usersService.ReplaceImage")
+	defer span.End()
+
+	return s.repository.ReplaceImage(ctx, userId, oldURL, newURL)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type localStore struct {
+	dir       string
+	publicURL string
+}
+
+// NewLocalStore keeps images in dir, which is created on first use, and
+// publishes them under publicURL. dir should be absolute, the store must not
+// depend on the working directory.
+func NewLocalStore(dir string, publicURL string) ImageStore {
+	return &localStore{dir: dir, publicURL: publicURL}
+}
+
+func (s *localStore) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+
+	target := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first, so that a reader never sees half an
+	// image.
+	file, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(file.Name(), target); err != nil {
+		return "", err
+	}
+	return s.URL(key), nil
+}
+
+func (s *localStore) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if err := validKey(key); err != nil {
+		return nil, ObjectInfo{}, ErrNotExist
+	}
+
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return file, s.info(key, stat), nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) List(ctx context.Context, prefix string, fn func(key string, info ObjectInfo) error) error {
+	err := filepath.WalkDir(s.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Temporary files of uploads in progress and of the readiness
+		// check are not images.
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted since the directory was read.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(key, s.info(key, stat))
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing was stored yet.
+		return nil
+	}
+	return err
+}
+
+func (s *localStore) URL(key string) string {
+	return joinURL(s.publicURL, key)
+}
+
+func (s *localStore) Key(url string) (string, bool) {
+	return keyOf(s.publicURL, url)
+}
+
+func (s *localStore) Check(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (s *localStore) info(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+		ETag:        fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+func (s *localStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
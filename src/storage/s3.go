@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type s3Store struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// NewS3Store keeps images in a bucket of an S3 compatible service. The bucket
+// must exist and its objects must be publicly readable under the public URL.
+func NewS3Store(cfg config.Storage) (ImageStore, error) {
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure:       cfg.S3UseSSL,
+		Region:       cfg.S3Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicURL := cfg.S3PublicURL
+	if publicURL == "" {
+		publicURL = joinURL(client.EndpointURL().String(), cfg.S3Bucket)
+	}
+	return &s3Store{client: client, bucket: cfg.S3Bucket, publicURL: publicURL}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, content, size, minio.PutObjectOptions{
+		ContentType: contentType,
+		// Images are small, a plain body with an MD5 checksum avoids the
+		// chunked signature used by default over plain HTTP.
+		SendContentMd5:       true,
+		DisableContentSha256: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.URL(key), nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if err := validKey(key); err != nil {
+		return nil, ObjectInfo{}, ErrNotExist
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, s.translate(err)
+	}
+
+	// GetObject is lazy, Stat performs the request.
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, ObjectInfo{}, s.translate(err)
+	}
+	info := ObjectInfo{
+		Size:        stat.Size,
+		ContentType: stat.ContentType,
+		ModTime:     stat.LastModified,
+		ETag:        `"` + stat.ETag + `"`,
+	}
+	return object, info, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	// S3 does not report deleting a missing object as an error.
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string, fn func(key string, info ObjectInfo) error) error {
+	// Stopping early cancels the listing.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		info := ObjectInfo{
+			Size:        object.Size,
+			ContentType: object.ContentType,
+			ModTime:     object.LastModified,
+			ETag:        `"` + object.ETag + `"`,
+		}
+		if err := fn(object.Key, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3Store) URL(key string) string {
+	return joinURL(s.publicURL, key)
+}
+
+func (s *s3Store) Key(url string) (string, bool) {
+	return keyOf(s.publicURL, url)
+}
+
+func (s *s3Store) Check(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.bucket)
+	}
+	return nil
+}
+
+func (s *s3Store) translate(err error) error {
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+	return err
+}
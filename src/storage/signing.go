@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("image URL signature is invalid")
+	ErrSignatureExpired = errors.New("image URL has expired")
+)
+
+// URLSigner makes image URLs that are only valid for a while, so that the
+// images of a user cannot be fetched by guessing or keeping their keys. The
+// signature covers the key and the expiry time.
+type URLSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	return &URLSigner{secret: []byte(secret), ttl: ttl, now: time.Now}
+}
+
+// Sign appends the expiry time and the signature of key to rawURL. The expiry
+// is rounded, so that a client fetching the same image several times within a
+// quarter of the TTL gets the same URL and can use its cache. The URL stays
+// valid for at least three quarters of the TTL.
+func (s *URLSigner) Sign(rawURL string, key string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	window := s.ttl / 4
+	expires := s.now().Truncate(window).Add(s.ttl).Unix()
+
+	query := parsed.Query()
+	query.Set(ExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(SignatureParam, s.signature(key, expires))
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// Verify checks the expires and signature parameters of a request for key,
+// and returns the time the URL expires.
+func (s *URLSigner) Verify(key string, expires string, signature string) (time.Time, error) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrSignatureInvalid
+	}
+	expected := s.signature(key, unix)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return time.Time{}, ErrSignatureInvalid
+	}
+
+	expiresAt := time.Unix(unix, 0)
+	if !s.now().Before(expiresAt) {
+		return time.Time{}, ErrSignatureExpired
+	}
+	return expiresAt, nil
+}
+
+func (s *URLSigner) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
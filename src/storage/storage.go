@@ -0,0 +1,86 @@
+// Package storage keeps the users' profile images. Images are addressed by a
+// key such as "users/<uuid>.jpg" and published under a stable URL, so the
+// database never holds a filesystem path and every replica sees the same
+// images.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+)
+
+var ErrNotExist = errors.New("image does not exist")
+
+// ImageStore is implemented by every storage backend.
+type ImageStore interface {
+	// Put stores content under key, replacing any previous image, and
+	// returns its public URL.
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) (string, error)
+	// Open returns the content of key, which the caller must close, or
+	// ErrNotExist.
+	Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Delete removes key. Deleting a missing image is not an error.
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every image whose key starts with prefix, in key
+	// order, and stops at the first error fn returns.
+	List(ctx context.Context, prefix string, fn func(key string, info ObjectInfo) error) error
+	// URL returns the public URL of key.
+	URL(key string) string
+	// Key returns the key of an image from its public URL, false when the
+	// URL is not one of the store's.
+	Key(url string) (string, bool)
+	// Check reports whether the backend can currently store images.
+	Check(ctx context.Context) error
+}
+
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// ETag changes whenever the content does, it is quoted as HTTP wants.
+	ETag string
+}
+
+// NewImageStore returns the backend selected by cfg.
+func NewImageStore(cfg config.Storage) (ImageStore, error) {
+	switch cfg.Backend {
+	case "local":
+		dir, err := filepath.Abs(cfg.LocalDir)
+		if err != nil {
+			return nil, err
+		}
+		return NewLocalStore(dir, cfg.LocalPublicURL), nil
+	case "s3":
+		return NewS3Store(cfg)
+	}
+	return nil, fmt.Errorf("unknown image storage backend %q", cfg.Backend)
+}
+
+// validKey only accepts clean relative keys, so a key can never leave the
+// local directory or the bucket prefix.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid image key %q", key)
+	}
+	return nil
+}
+
+func joinURL(base string, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+func keyOf(base string, url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, strings.TrimSuffix(base, "/")+"/")
+	if !ok || validKey(key) != nil {
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// setDatabaseEnv provides the settings that have no default.
+func setDatabaseEnv(t *testing.T) {
+	t.Setenv("mysql_users_host", "127.0.0.1:3306")
+	t.Setenv("mysql_users_schema", "users_db")
+	t.Setenv("mysql_users_username", "root")
+	t.Setenv("JWT_KEYS_DIR", "/etc/users-api/keys")
+	t.Setenv("EMAIL_VERIFICATION_SECRET", testVerificationSecret)
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := config.Default()
+	assert.Equal(t, ":8080", cfg.Server.Address)
+	assert.Equal(t, "127.0.0.1:9090", cfg.Server.MetricsAddress)
+	assert.Equal(t, 15*time.Minute, cfg.JWT.AccessTokenTTL)
+	assert.Equal(t, "argon2id", cfg.Password.Hasher)
+
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "database.host")
+	assert.Contains(t, err.Error(), "jwt.keys_dir")
+	assert.Contains(t, err.Error(), "email_verification.secret")
+
+	cfg.Database.Host, cfg.Database.Schema, cfg.Database.Username = "localhost", "users_db", "root"
+	cfg.JWT.KeysDir = "/etc/users-api/keys"
+	cfg.EmailVerification.Secret = testVerificationSecret
+	assert.Nil(t, cfg.Validate())
+
+	// Development setups may sign with a throwaway key instead.
+	cfg.JWT.KeysDir, cfg.JWT.EphemeralKey = "", true
+	assert.Nil(t, cfg.Validate())
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	setDatabaseEnv(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "server:\n  address: \":9000\"\n  trusted_proxies: [10.0.0.1, 10.0.0.2]\njwt:\n  issuer: from-file\n  access_token_ttl: 5m\n"
+	assert.Nil(t, os.WriteFile(file, []byte(content), 0o600))
+
+	t.Setenv("JWT_ISSUER", "from-env")
+	t.Setenv("TOKEN_TTL", "600")
+
+	cfg, rest, err := config.Load([]string{"-config", file, "-jwt.access_token_ttl", "20m", "up"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"up"}, rest)
+	assert.Equal(t, ":9000", cfg.Server.Address)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, cfg.Server.TrustedProxies)
+	assert.Equal(t, "from-env", cfg.JWT.Issuer)
+	assert.Equal(t, 20*time.Minute, cfg.JWT.AccessTokenTTL)
+	assert.Equal(t, "shop", cfg.JWT.Audience)
+}
+
+func TestConfigTOMLFile(t *testing.T) {
+	setDatabaseEnv(t)
+
+	file := filepath.Join(t.TempDir(), "config.toml")
+	content := "[login]\nmax_account_failures = 3\nlockout_base = \"30s\"\n"
+	assert.Nil(t, os.WriteFile(file, []byte(content), 0o600))
+	t.Setenv("CONFIG_FILE", file)
+
+	cfg, _, err := config.Load(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cfg.Login.MaxAccountFailures)
+	assert.Equal(t, 30*time.Second, cfg.Login.LockoutBase)
+}
+
+func TestConfigRejectsUnknownFileKeys(t *testing.T) {
+	setDatabaseEnv(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(file, []byte("jwt:\n  isuer: typo\n"), 0o600))
+
+	_, _, err := config.Load([]string{"-config", file})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "jwt.isuer")
+}
+
+func TestConfigReportsEveryProblem(t *testing.T) {
+	setDatabaseEnv(t)
+	t.Setenv("PASSWORD_HASHER", "md5")
+	t.Setenv("NOTIFIER", "file")
+
+	_, _, err := config.Load([]string{"-login.lockout_max", "1s"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "password.hasher")
+	assert.Contains(t, err.Error(), "notifier.file")
+	assert.Contains(t, err.Error(), "login.lockout_max")
+
+	_, _, err = config.Load([]string{"-jwt.clock_skew", "soon"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "jwt.clock_skew")
+}
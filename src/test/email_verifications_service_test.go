@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/notifications"
+	"github.com/amirnep/shop/src/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerificationTokenSignature(t *testing.T) {
+	notifier := useMemoryUsers(t)
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("verify@test.com"))
+	other, _ := services.UsersService.CreateUser(context.Background(), newTestUser("other@test.com"))
+	if !assert.Len(t, notifier.messages, 2) {
+		return
+	}
+	token := linkToken(t, notifier.messages[0])
+	payload, signature, _ := strings.Cut(token, ".")
+
+	// A token signed with another secret, as by a misconfigured replica.
+	cfg := config.Default().EmailVerification
+	cfg.Secret, cfg.ResendInterval = strings.Repeat("x", 32), 0
+	services.EmailVerificationsService = services.NewEmailVerificationsService(cfg)
+	services.EmailVerificationsService.ResendVerification(context.Background(), "verify@test.com")
+	useVerifications(t, config.Default().EmailVerification)
+	if !assert.Len(t, notifier.messages, 3) {
+		return
+	}
+
+	forged := fmt.Sprintf("%d:%d:%s", other.Id, time.Now().Add(time.Hour).Unix(), "other@test.com")
+	for name, invalid := range map[string]string{
+		"other secret":  linkToken(t, notifier.messages[2]),
+		"signature":     payload + "." + strings.Repeat("A", len(signature)),
+		"payload":       base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + signature,
+		"no signature":  payload,
+		"empty":         "",
+		"not base64":    "!." + signature,
+		"trailing data": token + "x",
+	} {
+		err := services.EmailVerificationsService.VerifyEmail(context.Background(), invalid)
+		if assert.NotNil(t, err, name) {
+			assert.Equal(t, "invalid or expired verification token", err.Message, name)
+		}
+	}
+	for _, userId := range []int64{created.Id, other.Id} {
+		stored, _ := services.UsersService.GetUser(context.Background(), userId)
+		assert.False(t, stored.EmailVerified)
+	}
+
+	assert.Nil(t, services.EmailVerificationsService.VerifyEmail(context.Background(), token))
+	stored, _ := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.True(t, stored.EmailVerified)
+	stored, _ = services.UsersService.GetUser(context.Background(), other.Id)
+	assert.False(t, stored.EmailVerified)
+}
+
+func TestVerificationTokenExpires(t *testing.T) {
+	notifier := useMemoryUsers(t)
+	cfg := config.Default().EmailVerification
+	cfg.TTL = -time.Minute
+	useVerifications(t, cfg)
+
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("verify@test.com"))
+	if !assert.Len(t, notifier.messages, 1) {
+		return
+	}
+	err := services.EmailVerificationsService.VerifyEmail(context.Background(), linkToken(t, notifier.messages[0]))
+	assert.NotNil(t, err)
+
+	stored, _ := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.False(t, stored.EmailVerified)
+}
+
+func TestResendVerificationIsThrottled(t *testing.T) {
+	notifier := useMemoryUsers(t)
+	services.UsersService.CreateUser(context.Background(), newTestUser("verify@test.com"))
+
+	// The registration mail was sent less than a minute ago.
+	assert.Nil(t, services.EmailVerificationsService.ResendVerification(context.Background(), "verify@test.com"))
+	assert.Len(t, notifier.messages, 1)
+
+	cfg := config.Default().EmailVerification
+	cfg.ResendInterval = 0
+	useVerifications(t, cfg)
+	assert.Nil(t, services.EmailVerificationsService.ResendVerification(context.Background(), "verify@test.com"))
+	assert.Len(t, notifier.messages, 2)
+
+	// Unknown addresses cannot be told apart.
+	assert.Nil(t, services.EmailVerificationsService.ResendVerification(context.Background(), "nobody@test.com"))
+	assert.Len(t, notifier.messages, 2)
+}
+
+// unreachableNotifier fails every send, as a mail server that is down.
+type unreachableNotifier struct{}
+
+func (unreachableNotifier) Send(message notifications.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestFailedVerificationSendIsNotThrottled(t *testing.T) {
+	notifier := useMemoryUsers(t)
+	notifications.Sender = unreachableNotifier{}
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("verify@test.com"))
+
+	err := services.EmailVerificationsService.SendVerification(context.Background(), created)
+	assert.NotNil(t, err)
+
+	// The retry goes out although it is within the resend interval.
+	notifications.Sender = notifier
+	assert.Nil(t, services.EmailVerificationsService.SendVerification(context.Background(), created))
+	assert.Len(t, notifier.messages, 1)
+}
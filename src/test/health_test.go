@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/health"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// useReadiness swaps in an empty readiness registry for the test.
+func useReadiness(t *testing.T, timeout time.Duration) *health.Registry {
+	previous := health.Readiness
+	t.Cleanup(func() { health.Readiness = previous })
+
+	health.Readiness = health.NewRegistry(timeout)
+	return health.Readiness
+}
+
+func probe(path string) (int, health.Report) {
+	r := gin.New()
+	r.GET("/healthz", controllers.HealthController.Healthz)
+	r.GET("/readyz", controllers.HealthController.Readyz)
+
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var report health.Report
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return w.Code, report
+}
+
+func TestReadyzReportsEveryCheck(t *testing.T) {
+	registry := useReadiness(t, time.Second)
+	registry.Register("storage", storage.NewLocalStore(t.TempDir(), imagesPublicURL).Check)
+	registry.Register("database", func(ctx context.Context) error { return nil })
+
+	code, report := probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOk, report.Status)
+	assert.Len(t, report.Checks, 2)
+	assert.Equal(t, "database", report.Checks[0].Name)
+
+	registry.Register("database", func(ctx context.Context) error { return errors.New("connection refused") })
+	registry.Register("storage", func(ctx context.Context) error { return errors.New("read-only file system") })
+
+	code, report = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusFailing, report.Status)
+	assert.Equal(t, "connection refused", report.Checks[0].Error)
+	assert.Equal(t, health.StatusFailing, report.Checks[1].Status)
+}
+
+func TestReadyzTimesOutSlowChecks(t *testing.T) {
+	registry := useReadiness(t, 50*time.Millisecond)
+	block := make(chan struct{})
+	defer close(block)
+	registry.Register("database", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+
+	code, report := probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, report.Checks[0].Error, "timed out")
+}
+
+func TestReadyzFailsWhileShuttingDown(t *testing.T) {
+	registry := useReadiness(t, time.Second)
+	registry.Register("database", func(ctx context.Context) error { return nil })
+	registry.ShutDown()
+
+	code, report := probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusShuttingDown, report.Status)
+
+	code, report = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOk, report.Status)
+}
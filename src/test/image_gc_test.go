@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/images"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// putImageWithThumbnails stores an image and its thumbnails under key and
+// returns the image's URL.
+func putImageWithThumbnails(t *testing.T, store storage.ImageStore, key string) string {
+	for _, size := range images.ThumbnailSizes {
+		putImage(t, store, images.ThumbnailName(key, size), "thumbnail")
+	}
+	return putImage(t, store, key, "image")
+}
+
+func imageExists(store storage.ImageStore, key string) bool {
+	content, _, err := store.Open(context.Background(), key)
+	if err != nil {
+		return false
+	}
+	content.Close()
+	return true
+}
+
+// age makes the local image at key look stored an hour ago.
+func age(t *testing.T, dir string, key string) {
+	past := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), past, past))
+}
+
+func TestUpdateUserDeletesThePreviousImage(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	user := newTestUser("images@test.com")
+	user.ImageUrl = putImageWithThumbnails(t, store, "users/old.jpg")
+	created, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+
+	// Keeping the image deletes nothing.
+	_, err = services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(`{"first_name": "renamed"}`)})
+	assert.Nil(t, err)
+	assert.True(t, imageExists(store, "users/old.jpg"))
+
+	update := users.ProfilePatch{ImageUrl: putImageWithThumbnails(t, store, "users/new.jpg")}
+	_, err = services.UsersService.UpdateUser(context.Background(), created.Id, update)
+	assert.Nil(t, err)
+
+	assert.False(t, imageExists(store, "users/old.jpg"))
+	for _, size := range images.ThumbnailSizes {
+		assert.False(t, imageExists(store, images.ThumbnailName("users/old.jpg", size)))
+		assert.True(t, imageExists(store, images.ThumbnailName("users/new.jpg", size)))
+	}
+	assert.True(t, imageExists(store, "users/new.jpg"))
+}
+
+func TestDeleteImageLeavesOtherURLsAlone(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(filepath.Join(dir, "images"), imagesPublicURL)
+	useImages(t, store, nil)
+	legacy := filepath.Join(dir, "legacy.jpg")
+	assert.Nil(t, os.WriteFile(legacy, []byte("image"), 0o644))
+
+	services.ImagesService.DeleteImage(context.Background(), "")
+	services.ImagesService.DeleteImage(context.Background(), legacy)
+	services.ImagesService.DeleteImage(context.Background(), "http://elsewhere.example.com/users/a.jpg")
+
+	_, err := os.Stat(legacy)
+	assert.Nil(t, err)
+}
+
+func TestSweepDeletesOldOrphans(t *testing.T) {
+	useMemoryUsers(t)
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir, imagesPublicURL)
+	useImages(t, store, nil)
+
+	user := newTestUser("sweep@test.com")
+	user.ImageUrl = putImageWithThumbnails(t, store, "users/kept.jpg")
+	_, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+
+	putImageWithThumbnails(t, store, "users/orphan.jpg")
+	putImage(t, store, "users/uploading.jpg", "image")
+	for _, key := range []string{"users/kept.jpg", "users/kept_64.jpg", "users/orphan.jpg", "users/orphan_64.jpg", "users/orphan_256.jpg", "users/orphan_512.jpg"} {
+		age(t, dir, key)
+	}
+	olderThan := time.Now().Add(-time.Minute)
+
+	result, err := services.ImagesService.Sweep(context.Background(), olderThan, true)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.SweepResult{Scanned: 9, Orphaned: 4, Deleted: 0}, *result)
+	assert.True(t, imageExists(store, "users/orphan.jpg"))
+
+	result, err = services.ImagesService.Sweep(context.Background(), olderThan, false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.SweepResult{Scanned: 9, Orphaned: 4, Deleted: 4}, *result)
+
+	assert.False(t, imageExists(store, "users/orphan.jpg"))
+	assert.False(t, imageExists(store, "users/orphan_512.jpg"))
+	assert.True(t, imageExists(store, "users/kept.jpg"))
+	assert.True(t, imageExists(store, "users/kept_64.jpg"))
+	// Too young to tell from an upload whose user is not saved yet.
+	assert.True(t, imageExists(store, "users/uploading.jpg"))
+}
+
+func TestSweepOnS3(t *testing.T) {
+	useMemoryUsers(t)
+	srv := newS3StandIn(t, "images")
+	store := newS3Store(t, srv.URL, "images")
+	useImages(t, store, nil)
+
+	user := newTestUser("sweep@test.com")
+	user.ImageUrl = putImageWithThumbnails(t, store, "users/kept.jpg")
+	_, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+	putImageWithThumbnails(t, store, "users/orphan.jpg")
+
+	result, err := services.ImagesService.Sweep(context.Background(), time.Now().Add(time.Hour), false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.SweepResult{Scanned: 8, Orphaned: 4, Deleted: 4}, *result)
+	assert.False(t, imageExists(store, "users/orphan_64.jpg"))
+	assert.True(t, imageExists(store, "users/kept_64.jpg"))
+}
+
+func TestSweepFindsImagesSavedUnderAnotherPublicURL(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	putImageWithThumbnails(t, store, "users/kept.jpg")
+	putImageWithThumbnails(t, store, "users/orphan.jpg")
+	user := newTestUser("moved@test.com")
+	// Saved before the images moved behind another host.
+	user.ImageUrl = "https://old-cdn.example.com/images/users/kept.jpg"
+	_, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+
+	result, err := services.ImagesService.Sweep(context.Background(), time.Now().Add(time.Hour), false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.SweepResult{Scanned: 8, Orphaned: 4, Deleted: 4}, *result)
+	assert.True(t, imageExists(store, "users/kept.jpg"))
+	assert.True(t, imageExists(store, "users/kept_512.jpg"))
+	assert.False(t, imageExists(store, "users/orphan.jpg"))
+}
+
+func TestSweepRefusesWhenNothingIsReferenced(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	putImageWithThumbnails(t, store, "users/a.jpg")
+	putImageWithThumbnails(t, store, "users/b.jpg")
+	user := newTestUser("elsewhere@test.com")
+	user.ImageUrl = "https://cdn.example.com/images/users/other.jpg"
+	_, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+
+	result, err := services.ImagesService.Sweep(context.Background(), time.Now().Add(time.Hour), true)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.SweepResult{Scanned: 8, Orphaned: 8, Deleted: 0}, *result)
+
+	result, err = services.ImagesService.Sweep(context.Background(), time.Now().Add(time.Hour), false)
+	assert.Nil(t, result)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusConflict, err.Status)
+	}
+	assert.True(t, imageExists(store, "users/a.jpg"))
+	assert.True(t, imageExists(store, "users/b_64.jpg"))
+}
+
+func TestDeleteImageKeepsImagesOtherUsersReference(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	shared := putImageWithThumbnails(t, store, "users/shared.jpg")
+	owner := newTestUser("owner@test.com")
+	owner.ImageUrl = shared
+	original, err := services.UsersService.CreateUser(context.Background(), owner)
+	assert.Nil(t, err)
+	copier := newTestUser("copier@test.com")
+	copier.ImageUrl = shared
+	created, err := services.UsersService.CreateUser(context.Background(), copier)
+	assert.Nil(t, err)
+
+	_, err = services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{ImageUrl: putImageWithThumbnails(t, store, "users/own.jpg")})
+	assert.Nil(t, err)
+	assert.True(t, imageExists(store, "users/shared.jpg"))
+	assert.True(t, imageExists(store, "users/shared_64.jpg"))
+
+	// Once nobody has it any more it goes.
+	_, err = services.UsersService.UpdateUser(context.Background(), original.Id, users.ProfilePatch{ImageUrl: putImageWithThumbnails(t, store, "users/new.jpg")})
+	assert.Nil(t, err)
+	assert.False(t, imageExists(store, "users/shared.jpg"))
+	assert.False(t, imageExists(store, "users/shared_64.jpg"))
+}
+
+func TestOriginalName(t *testing.T) {
+	assert.EqualValues(t, "users/a.jpg", images.OriginalName("users/a_256.jpg"))
+	assert.EqualValues(t, "users/a.jpg", images.OriginalName("users/a.jpg"))
+	assert.EqualValues(t, "users/a_100.jpg", images.OriginalName("users/a_100.jpg"))
+}
+
+func TestMigrateLegacyImages(t *testing.T) {
+	useMemoryUsers(t)
+	root := t.TempDir()
+	store := storage.NewLocalStore(filepath.Join(root, "images"), imagesPublicURL)
+	useImages(t, store, nil)
+
+	legacyFile := filepath.Join(root, "src", "wwwroot", "a.jpg")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(legacyFile), 0o755))
+	assert.Nil(t, os.WriteFile(legacyFile, encodeJPEG(t, halves(600, 400)), 0o644))
+
+	legacy := newTestUser("legacy@test.com")
+	legacy.ImageUrl = "src/wwwroot/a.jpg"
+	legacy, err := services.UsersService.CreateUser(context.Background(), legacy)
+	assert.Nil(t, err)
+	missing := newTestUser("missing@test.com")
+	missing.ImageUrl = "src/wwwroot/missing.jpg"
+	_, err = services.UsersService.CreateUser(context.Background(), missing)
+	assert.Nil(t, err)
+	stored := newTestUser("stored@test.com")
+	stored.ImageUrl = putImageWithThumbnails(t, store, "users/stored.jpg")
+	_, err = services.UsersService.CreateUser(context.Background(), stored)
+	assert.Nil(t, err)
+
+	result, err := services.ImagesService.MigrateLegacy(context.Background(), root, true)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.LegacyMigrationResult{Scanned: 3, Legacy: 2}, *result)
+
+	result, err = services.ImagesService.MigrateLegacy(context.Background(), root, false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, services.LegacyMigrationResult{Scanned: 3, Legacy: 2, Migrated: 1, Failed: 1}, *result)
+
+	migrated, err := services.UsersService.GetUser(context.Background(), legacy.Id)
+	assert.Nil(t, err)
+	key, ok := store.Key(migrated.ImageUrl)
+	assert.True(t, ok)
+	assert.True(t, imageExists(store, key))
+	for _, size := range images.ThumbnailSizes {
+		assert.True(t, imageExists(store, images.ThumbnailName(key, size)))
+	}
+	_, statErr := os.Stat(legacyFile)
+	assert.True(t, os.IsNotExist(statErr))
+}
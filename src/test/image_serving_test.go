@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/images"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const imagesPublicURL = "http://localhost:8080/images"
+
+// useImages serves the images of store for the test, with signed URLs when
+// signer is not nil.
+func useImages(t *testing.T, store storage.ImageStore, signer *storage.URLSigner) {
+	previousService, previousURL, previousStored := services.ImagesService, images.PublicURL, images.Stored
+	t.Cleanup(func() {
+		services.ImagesService = previousService
+		images.PublicURL = previousURL
+		images.Stored = previousStored
+	})
+
+	services.ImagesService = services.NewImagesService(store, config.Default().Images, signer)
+	images.PublicURL = services.ImagesService.PublicURL
+	images.Stored = services.ImagesService.Stored
+}
+
+// putImage stores content under key and returns the stored URL.
+func putImage(t *testing.T, store storage.ImageStore, key string, content string) string {
+	url, err := store.Put(context.Background(), key, strings.NewReader(content), int64(len(content)), "image/jpeg")
+	assert.Nil(t, err)
+	return url
+}
+
+// fetchImage requests the image at rawURL from the images route.
+func fetchImage(method string, rawURL string, header http.Header) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/images/*key", controllers.ImagesController.Get)
+	r.HEAD("/images/*key", controllers.ImagesController.Get)
+
+	parsed, _ := url.Parse(rawURL)
+	req, _ := http.NewRequest(method, parsed.RequestURI(), nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestImagesAreServedWithCachingHeaders(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+	url := putImage(t, store, "users/a.jpg", "jpeg content")
+
+	w := fetchImage(http.MethodGet, url, nil)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, "jpeg content", w.Body.String())
+	assert.EqualValues(t, "image/jpeg", w.Header().Get("Content-Type"))
+	assert.EqualValues(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	w = fetchImage(http.MethodGet, url, http.Header{"If-None-Match": {etag}})
+	assert.EqualValues(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = fetchImage(http.MethodGet, url, http.Header{"If-Modified-Since": {lastModified}})
+	assert.EqualValues(t, http.StatusNotModified, w.Code)
+
+	w = fetchImage(http.MethodGet, url, http.Header{"Range": {"bytes=0-3"}})
+	assert.EqualValues(t, http.StatusPartialContent, w.Code)
+	assert.EqualValues(t, "jpeg", w.Body.String())
+
+	w = fetchImage(http.MethodHead, url, nil)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.EqualValues(t, "12", w.Header().Get("Content-Length"))
+}
+
+func TestMissingImagesAreNotFound(t *testing.T) {
+	useImages(t, storage.NewLocalStore(t.TempDir(), imagesPublicURL), nil)
+
+	assert.EqualValues(t, http.StatusNotFound, fetchImage(http.MethodGet, imagesPublicURL+"/users/missing.jpg", nil).Code)
+	assert.EqualValues(t, http.StatusNotFound, fetchImage(http.MethodGet, imagesPublicURL+"/../config.yaml", nil).Code)
+}
+
+func TestImagesAreServedFromS3(t *testing.T) {
+	srv := newS3StandIn(t, "images")
+	store := newS3Store(t, srv.URL, "images")
+	useImages(t, store, nil)
+	url := putImage(t, store, "users/a.jpg", "jpeg content")
+	key, _ := store.Key(url)
+
+	w := fetchImage(http.MethodGet, imagesPublicURL+"/"+key, nil)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, "jpeg content", w.Body.String())
+	assert.EqualValues(t, "image/jpeg", w.Header().Get("Content-Type"))
+
+	w = fetchImage(http.MethodGet, imagesPublicURL+"/"+key, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	assert.EqualValues(t, http.StatusNotModified, w.Code)
+
+	w = fetchImage(http.MethodGet, imagesPublicURL+"/"+key, http.Header{"Range": {"bytes=5-"}})
+	assert.EqualValues(t, http.StatusPartialContent, w.Code)
+	assert.EqualValues(t, "content", w.Body.String())
+}
+
+func TestSignedImageURLs(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, storage.NewURLSigner(strings.Repeat("s", 32), time.Hour))
+	stored := putImage(t, store, "users/a.jpg", "jpeg content")
+	putImage(t, store, "users/b.jpg", "other content")
+
+	signed := services.ImagesService.PublicURL(stored)
+	assert.True(t, strings.HasPrefix(signed, stored+"?"))
+
+	w := fetchImage(http.MethodGet, signed, nil)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Cache-Control"), "private, max-age="))
+
+	// Neither the bare URL nor the signature of another image will do.
+	assert.EqualValues(t, http.StatusForbidden, fetchImage(http.MethodGet, stored, nil).Code)
+	other := strings.Replace(signed, "users/a.jpg", "users/b.jpg", 1)
+	assert.EqualValues(t, http.StatusForbidden, fetchImage(http.MethodGet, other, nil).Code)
+	// Unknown keys are refused before the store is asked, they cannot be
+	// told apart from existing ones.
+	assert.EqualValues(t, http.StatusForbidden, fetchImage(http.MethodGet, imagesPublicURL+"/users/missing.jpg", nil).Code)
+
+	// The URL stays the same for a while, so clients can cache the image.
+	assert.EqualValues(t, signed, services.ImagesService.PublicURL(stored))
+}
+
+func TestSignedImageURLsExpire(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, storage.NewURLSigner(strings.Repeat("s", 32), 40*time.Millisecond))
+	signed := services.ImagesService.PublicURL(putImage(t, store, "users/a.jpg", "jpeg content"))
+
+	time.Sleep(60 * time.Millisecond)
+	w := fetchImage(http.MethodGet, signed, nil)
+	assert.EqualValues(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "expired")
+}
+
+func TestPrivateUserImageURLsAreSigned(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, storage.NewURLSigner(strings.Repeat("s", 32), time.Hour))
+	stored := putImage(t, store, "users/a.jpg", "jpeg content")
+	putImage(t, store, "users/a_64.jpg", "thumbnail content")
+
+	user := users.User{Id: 1, ImageUrl: stored}
+	private := user.Marshall(false).(users.PrivateUser)
+	assert.Contains(t, private.ImageUrl, storage.SignatureParam+"=")
+
+	w := fetchImage(http.MethodGet, private.Thumbnails[64], nil)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.True(t, bytes.Equal([]byte("thumbnail content"), w.Body.Bytes()))
+
+	// Values that are not the store's are handed out unchanged.
+	user.ImageUrl = "src/wwwroot/a.jpg"
+	assert.EqualValues(t, "src/wwwroot/a.jpg", user.Marshall(false).(users.PrivateUser).ImageUrl)
+}
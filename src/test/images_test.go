@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/images"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// halves returns a width x height image, red on the left and blue on the
+// right.
+func halves(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// withExifOrientation inserts an EXIF block holding orientation, and a GPS
+// looking marker, right after the start of a JPEG.
+func withExifOrientation(content []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2A")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPSLatitude")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(content[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(content[2:])
+	return out.Bytes()
+}
+
+// uploadedFile returns content as the file header of a multipart upload.
+func uploadedFile(t *testing.T, content []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("Image", "a.jpg")
+	part.Write(content)
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(32 << 20)
+	assert.Nil(t, err)
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["Image"][0]
+}
+
+// openImage decodes the stored image at key.
+func openImage(t *testing.T, store storage.ImageStore, key string) (image.Image, string) {
+	reader, _, err := store.Open(context.Background(), key)
+	if !assert.Nil(t, err, key) {
+		return nil, ""
+	}
+	defer reader.Close()
+	content, _ := io.ReadAll(reader)
+	img, format, err := image.Decode(bytes.NewReader(content))
+	assert.Nil(t, err, key)
+	return img, format
+}
+
+func TestDecodeRejectsWhatIsNotAnImage(t *testing.T) {
+	limits := config.Default().Images
+	content := encodeJPEG(t, halves(40, 20))
+
+	for name, upload := range map[string][]byte{
+		"text":      []byte("<html><body>not an image</body></html>"),
+		"empty":     {},
+		"truncated": content[:len(content)/2],
+	} {
+		_, err := images.Decode(upload, limits)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestDecodeRejectsDecompressionBombs(t *testing.T) {
+	limits := config.Default().Images
+
+	// A tiny PNG whose header claims 60000 x 60000 pixels.
+	content := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	header := content[12:29]
+	binary.BigEndian.PutUint32(header[4:], 60000)
+	binary.BigEndian.PutUint32(header[8:], 60000)
+	binary.BigEndian.PutUint32(content[29:], crc32.ChecksumIEEE(header))
+
+	_, err := images.Decode(content, limits)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must not be larger")
+
+	limits.MaxPixels = 799
+	_, err = images.Decode(encodeJPEG(t, halves(40, 20)), limits)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeAppliesExifOrientation(t *testing.T) {
+	content := withExifOrientation(encodeJPEG(t, halves(40, 20)), 6)
+
+	img, err := images.Decode(content, config.Default().Images)
+	assert.Nil(t, err)
+	assert.EqualValues(t, image.Rect(0, 0, 20, 40), img.Bounds())
+
+	// Turned clockwise, the red left half is now on top.
+	r, _, b, _ := img.At(10, 5).RGBA()
+	assert.True(t, r > b)
+	r, _, b, _ = img.At(10, 35).RGBA()
+	assert.True(t, b > r)
+
+	encoded, err := images.Encode(img, images.FormatOf(img), 85)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(encoded, []byte("Exif")))
+	assert.False(t, bytes.Contains(encoded, []byte("GPSLatitude")))
+}
+
+func TestFormatOfKeepsTransparency(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	assert.EqualValues(t, images.FormatPNG, images.FormatOf(transparent))
+
+	img, err := images.Decode(encodeJPEG(t, halves(4, 4)), config.Default().Images)
+	assert.Nil(t, err)
+	assert.EqualValues(t, images.FormatJPEG, images.FormatOf(img))
+}
+
+func TestThumbnailURLs(t *testing.T) {
+	useImages(t, storage.NewLocalStore(t.TempDir(), "http://cdn.example.com"), nil)
+	urls := images.ThumbnailURLs("http://cdn.example.com/users/a.jpg")
+
+	assert.EqualValues(t, map[int]string{
+		64:  "http://cdn.example.com/users/a_64.jpg",
+		256: "http://cdn.example.com/users/a_256.jpg",
+		512: "http://cdn.example.com/users/a_512.jpg",
+	}, urls)
+	assert.Nil(t, images.ThumbnailURLs(""))
+	// Legacy paths and foreign URLs have no thumbnails.
+	assert.Nil(t, images.ThumbnailURLs("src/wwwroot/a.jpg"))
+	assert.Nil(t, images.ThumbnailURLs("http://elsewhere.example.com/users/a.jpg"))
+}
+
+func TestPrivateUserListsThumbnails(t *testing.T) {
+	useImages(t, storage.NewLocalStore(t.TempDir(), "http://cdn.example.com"), nil)
+	user := users.User{Id: 1, ImageUrl: "http://cdn.example.com/users/a.png"}
+
+	private := user.Marshall(false).(users.PrivateUser)
+	assert.EqualValues(t, "http://cdn.example.com/users/a_64.png", private.Thumbnails[64])
+
+	user.ImageUrl = "src/wwwroot/a.png"
+	assert.Nil(t, user.Marshall(false).(users.PrivateUser).Thumbnails)
+}
+
+func TestImagesServiceStoresImageAndThumbnails(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), "http://cdn.example.com")
+	service := services.NewImagesService(store, config.Default().Images, nil)
+
+	content := withExifOrientation(encodeJPEG(t, halves(600, 300)), 6)
+	url, err := service.SaveUpload(context.Background(), uploadedFile(t, content))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(url, "http://cdn.example.com/users/"))
+	assert.True(t, strings.HasSuffix(url, ".jpg"))
+
+	key := strings.TrimPrefix(url, "http://cdn.example.com/")
+	img, format := openImage(t, store, key)
+	assert.EqualValues(t, "jpeg", format)
+	assert.EqualValues(t, image.Rect(0, 0, 300, 600), img.Bounds())
+
+	for _, size := range images.ThumbnailSizes {
+		thumbnail, _ := openImage(t, store, images.ThumbnailName(key, size))
+		assert.EqualValues(t, image.Rect(0, 0, size, size), thumbnail.Bounds())
+	}
+}
+
+func TestImagesServiceStoresTransparentImagesAsPNG(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), "http://cdn.example.com")
+	service := services.NewImagesService(store, config.Default().Images, nil)
+
+	url, err := service.SaveUpload(context.Background(), uploadedFile(t, encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 8, 8)))))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(url, ".png"))
+}
+
+func TestImagesServiceAcceptsGIF(t *testing.T) {
+	service := services.NewImagesService(storage.NewLocalStore(t.TempDir(), "http://cdn.example.com"), config.Default().Images, nil)
+
+	var buf bytes.Buffer
+	assert.Nil(t, gif.Encode(&buf, halves(8, 8), nil))
+	_, err := service.SaveUpload(context.Background(), uploadedFile(t, buf.Bytes()))
+	assert.Nil(t, err)
+}
+
+func TestImagesServiceRejectsInvalidUploads(t *testing.T) {
+	limits := config.Default().Images
+	limits.MaxUploadSize = 1 << 10
+	service := services.NewImagesService(storage.NewLocalStore(t.TempDir(), "http://cdn.example.com"), limits, nil)
+
+	_, err := service.SaveUpload(context.Background(), uploadedFile(t, make([]byte, 1<<10+1)))
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.Status)
+
+	_, err = service.SaveUpload(context.Background(), uploadedFile(t, []byte("GIF89a but not really")))
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.Status)
+}
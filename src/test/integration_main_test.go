@@ -0,0 +1,60 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/datasources/mysql/migrations"
+	"github.com/amirnep/shop/src/datasources/mysql/users_db"
+	"github.com/amirnep/shop/src/domain/login_attempts"
+	"github.com/amirnep/shop/src/domain/password_resets"
+	"github.com/amirnep/shop/src/domain/refresh_tokens"
+	"github.com/amirnep/shop/src/domain/revocations"
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/services"
+)
+
+// The integration tests run against the MySQL database configured in .env,
+// which is migrated to the latest version first:
+//
+//	go test -tags integration ./test/...
+func TestMain(m *testing.M) {
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := users_db.Init(cfg.Database); err != nil {
+		panic(err)
+	}
+
+	migrator, err := migrations.New(users_db.Client)
+	if err != nil {
+		panic(err)
+	}
+	if err := migrator.Up(context.Background(), 0); err != nil {
+		panic(err)
+	}
+
+	services.UsersService = services.NewUsersService(users.NewMySQLRepository(users_db.Client))
+	services.TokensService = services.NewTokensService(cfg.JWT, refresh_tokens.NewMySQLRepository(users_db.Client))
+	services.RevocationsService = services.NewRevocationsService(revocations.NewMySQLRepository(users_db.Client))
+	services.PasswordResetsService = services.NewPasswordResetsService(cfg.PasswordReset, password_resets.NewMySQLRepository(users_db.Client))
+	services.EmailVerificationsService = services.NewEmailVerificationsService(cfg.EmailVerification)
+	services.LoginAttemptsService = services.NewLoginAttemptsService(cfg.Login, login_attempts.NewMySQLRepository(users_db.Client))
+	services.RolesService = services.NewRolesService(roles.NewMySQLRepository(users_db.Client))
+	services.TwoFactorService = services.NewTwoFactorService(cfg.TwoFactor, two_factor.NewMySQLRepository(users_db.Client))
+
+	if err := services.RolesService.Load(context.Background()); err != nil {
+		panic(err.Message)
+	}
+	if err := services.RevocationsService.Load(context.Background()); err != nil {
+		panic(err.Message)
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/metrics"
+	gojwt "github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// signClaims signs claims with private under kid, the way the jwt package
+// would.
+func signClaims(t *testing.T, private ed25519.PrivateKey, kid string, claims gojwt.Claims) string {
+	token := gojwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(private)
+	assert.Nil(t, err)
+	return signed
+}
+
+// validClaims are what GenerateJWT would put into a token for user 1 with the
+// default configuration, issued at now.
+func validClaims(now time.Time) *jwt.Claims {
+	cfg := config.Default().JWT
+	return &jwt.Claims{
+		Role: "user",
+		StandardClaims: gojwt.StandardClaims{
+			Id:        "jti",
+			Subject:   "1",
+			Issuer:    cfg.Issuer,
+			Audience:  cfg.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(cfg.AccessTokenTTL).Unix(),
+		},
+	}
+}
+
+func TestClaimsValidation(t *testing.T) {
+	jwt.Configure(config.Default().JWT)
+	private := useTestKeys(t)
+	useMemoryRevocations(t)
+	now := time.Now()
+	// The default clock skew is 30 seconds.
+	within, beyond := 20*time.Second, 40*time.Second
+
+	cases := map[string]struct {
+		change func(*jwt.Claims)
+		reason string
+	}{
+		"valid":           {func(c *jwt.Claims) {}, ""},
+		"expired in skew": {func(c *jwt.Claims) { c.ExpiresAt = now.Add(-within).Unix() }, ""},
+		"expired":         {func(c *jwt.Claims) { c.ExpiresAt = now.Add(-beyond).Unix() }, "expired"},
+		"no expiry":       {func(c *jwt.Claims) { c.ExpiresAt = 0 }, "expired"},
+		"early in skew":   {func(c *jwt.Claims) { c.NotBefore = now.Add(within).Unix() }, ""},
+		"not before":      {func(c *jwt.Claims) { c.NotBefore = now.Add(beyond).Unix() }, "not_yet_valid"},
+		"issued later":    {func(c *jwt.Claims) { c.IssuedAt = now.Add(beyond).Unix() }, "not_yet_valid"},
+		"issuer":          {func(c *jwt.Claims) { c.Issuer = "someone-else" }, "issuer"},
+		"audience":        {func(c *jwt.Claims) { c.Audience = "other" }, "audience"},
+		"challenge":       {func(c *jwt.Claims) { c.Audience = config.Default().JWT.Audience + "/mfa" }, "audience"},
+		"no jti":          {func(c *jwt.Claims) { c.Id = "" }, "claims"},
+		"no subject":      {func(c *jwt.Claims) { c.Subject = "" }, "claims"},
+		"invalid subject": {func(c *jwt.Claims) { c.Subject = "admin" }, "claims"},
+	}
+	for name, c := range cases {
+		claims := validClaims(now)
+		c.change(claims)
+		token := signClaims(t, private, "test", claims)
+
+		if c.reason == "" {
+			assert.Equal(t, http.StatusOK, authenticate(token), name)
+			continue
+		}
+		counter := metrics.TokenFailures.WithLabelValues(c.reason)
+		before := testutil.ToFloat64(counter)
+		assert.Equal(t, http.StatusUnauthorized, authenticate(token), name)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), name)
+	}
+}
+
+func TestClaimsSignature(t *testing.T) {
+	jwt.Configure(config.Default().JWT)
+	private := useTestKeys(t)
+	useMemoryRevocations(t)
+	claims := validClaims(time.Now())
+
+	for name, c := range map[string]struct {
+		token  string
+		reason string
+	}{
+		"unknown kid":  {signClaims(t, private, "other", claims), "unknown_key"},
+		"other key":    {signClaims(t, newEd25519Key(t), "test", claims), "signature"},
+		"none":         {noneToken(t, claims), "unknown_key"},
+		"wrong method": {hmacToken(t, claims), "unknown_key"},
+	} {
+		counter := metrics.TokenFailures.WithLabelValues(c.reason)
+		before := testutil.ToFloat64(counter)
+		assert.Equal(t, http.StatusUnauthorized, authenticate(c.token), name)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), name)
+	}
+}
+
+func noneToken(t *testing.T, claims gojwt.Claims) string {
+	token := gojwt.NewWithClaims(gojwt.SigningMethodNone, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(gojwt.UnsafeAllowNoneSignatureType)
+	assert.Nil(t, err)
+	return signed
+}
+
+func hmacToken(t *testing.T, claims gojwt.Claims) string {
+	token := gojwt.NewWithClaims(gojwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString([]byte("secret"))
+	assert.Nil(t, err)
+	return signed
+}
+
+func TestGeneratedTokensCarryTheConfiguredClaims(t *testing.T) {
+	useTestKeys(t)
+	useMemoryRevocations(t)
+	cfg := config.Default().JWT
+	cfg.Issuer, cfg.Audience = "issuer", "audience"
+	jwt.Configure(cfg)
+	t.Cleanup(func() { jwt.Configure(config.Default().JWT) })
+
+	first, _ := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, true)
+	second, _ := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, true)
+	assert.Equal(t, http.StatusOK, authenticate(first))
+
+	parse := func(token string) *jwt.Claims {
+		claims := &jwt.Claims{}
+		_, _, err := new(gojwt.Parser).ParseUnverified(token, claims)
+		assert.Nil(t, err)
+		return claims
+	}
+	claims := parse(first)
+	assert.Equal(t, "issuer", claims.Issuer)
+	assert.Equal(t, "audience", claims.Audience)
+	assert.Equal(t, "1", claims.Subject)
+	assert.True(t, claims.MFA)
+	assert.Equal(t, cfg.AccessTokenTTL, time.Duration(claims.ExpiresAt-claims.IssuedAt)*time.Second)
+	// Every token has its own id, so that it can be revoked on its own.
+	assert.NotEmpty(t, claims.Id)
+	assert.NotEqual(t, claims.Id, parse(second).Id)
+
+	// A challenge is no access token.
+	challenge, _ := jwt.GenerateMFAChallenge(users.User{Id: 1})
+	assert.Equal(t, http.StatusUnauthorized, authenticate(challenge))
+	parsed, err := jwt.ParseMFAChallenge(challenge)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 1, parsed.UserId)
+		assert.NotEmpty(t, parsed.Jti)
+	}
+	_, err = jwt.ParseMFAChallenge(first)
+	assert.NotNil(t, err)
+}
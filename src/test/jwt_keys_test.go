@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKey stores private as dir/<kid>.pem, written at modified.
+func writeKey(t *testing.T, dir string, kid string, private crypto.PrivateKey, modified time.Time) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.Nil(t, err)
+	file := filepath.Join(dir, kid+".pem")
+	assert.Nil(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	assert.Nil(t, os.Chtimes(file, modified, modified))
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return private
+}
+
+// useTestKeys signs tokens with a key of a temporary key directory, whose kid
+// is test. The key is returned.
+func useTestKeys(t *testing.T) ed25519.PrivateKey {
+	dir := t.TempDir()
+	private := newEd25519Key(t)
+	writeKey(t, dir, "test", private, time.Now())
+	assert.Nil(t, jwt.LoadKeys(dir, 0))
+	return private
+}
+
+// tokenKid returns the kid in the header of token.
+func tokenKid(t *testing.T, token string) string {
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	assert.Nil(t, err)
+	var fields map[string]string
+	assert.Nil(t, json.Unmarshal(header, &fields))
+	return fields["kid"]
+}
+
+func TestLoadKeysRequiresADirectory(t *testing.T) {
+	assert.NotNil(t, jwt.LoadKeys("", 0))
+	// An empty directory is a mistake as well.
+	assert.NotNil(t, jwt.LoadKeys(t.TempDir(), 0))
+
+	dir := t.TempDir()
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	writeKey(t, dir, "weak", weak, time.Now())
+	err = jwt.LoadKeys(dir, 0)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "2048")
+	}
+}
+
+func TestNewKeysWaitForTheirActivation(t *testing.T) {
+	useMemoryRevocations(t)
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	writeKey(t, dir, "old", rsaKey, time.Now().Add(-2*time.Hour))
+	writeKey(t, dir, "new", newEd25519Key(t), time.Now())
+
+	assert.Nil(t, jwt.LoadKeys(dir, time.Hour))
+	old, err := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", tokenKid(t, old))
+
+	// Both keys are published before either signs.
+	jwks := jwt.JWKS()
+	if assert.Len(t, jwks.Keys, 2) {
+		assert.Equal(t, "new", jwks.Keys[0].Kid)
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+		assert.Equal(t, "Ed25519", jwks.Keys[0].Crv)
+		assert.NotEmpty(t, jwks.Keys[0].X)
+		assert.Equal(t, "old", jwks.Keys[1].Kid)
+		assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+		assert.Equal(t, "RS256", jwks.Keys[1].Alg)
+		assert.Equal(t, "AQAB", jwks.Keys[1].E)
+		assert.NotEmpty(t, jwks.Keys[1].N)
+	}
+
+	assert.Nil(t, jwt.LoadKeys(dir, 0))
+	current, err := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", tokenKid(t, current))
+
+	// Tokens are verified with the key named by their kid.
+	assert.Equal(t, http.StatusOK, authenticate(old))
+	assert.Equal(t, http.StatusOK, authenticate(current))
+
+	// Retiring a key invalidates what it signed.
+	assert.Nil(t, os.Remove(filepath.Join(dir, "old.pem")))
+	assert.Nil(t, jwt.LoadKeys(dir, 0))
+	assert.Equal(t, http.StatusUnauthorized, authenticate(old))
+	assert.Equal(t, http.StatusOK, authenticate(current))
+	assert.Len(t, jwt.JWKS().Keys, 1)
+}
+
+func TestEphemeralKey(t *testing.T) {
+	useMemoryRevocations(t)
+	assert.Nil(t, jwt.LoadEphemeralKey())
+
+	token, err := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "ephemeral", tokenKid(t, token))
+	assert.Equal(t, http.StatusOK, authenticate(token))
+
+	jwks := jwt.JWKS()
+	if assert.Len(t, jwks.Keys, 1) {
+		assert.Equal(t, "ephemeral", jwks.Keys[0].Kid)
+	}
+}
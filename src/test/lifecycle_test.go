@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func recordingHook(name string, calls *[]string, startErr error) app.Hook {
+	return app.Hook{
+		Name: name,
+		OnStart: func(ctx context.Context) error {
+			*calls = append(*calls, "start "+name)
+			return startErr
+		},
+		OnStop: func(ctx context.Context) error {
+			*calls = append(*calls, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycleStopsInReverseOrder(t *testing.T) {
+	var calls []string
+	lifecycle := app.NewLifecycle()
+	lifecycle.Append(recordingHook("database", &calls, nil))
+	lifecycle.Append(recordingHook("services", &calls, nil))
+
+	assert.Nil(t, lifecycle.Start(context.Background()))
+	assert.Nil(t, lifecycle.Stop(context.Background()))
+	assert.Equal(t, []string{"start database", "start services", "stop services", "stop database"}, calls)
+
+	assert.Nil(t, lifecycle.Stop(context.Background()))
+	assert.Len(t, calls, 4)
+}
+
+func TestLifecycleRollsBackFailedStart(t *testing.T) {
+	var calls []string
+	lifecycle := app.NewLifecycle()
+	lifecycle.Append(recordingHook("database", &calls, nil))
+	lifecycle.Append(recordingHook("services", &calls, errors.New("no keys")))
+	lifecycle.Append(recordingHook("router", &calls, nil))
+
+	err := lifecycle.Start(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "starting services: no keys")
+	assert.Equal(t, []string{"start database", "start services", "stop database"}, calls)
+}
+
+func TestServeDrainsRequestsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, server, listener, 5*time.Second)
+	}()
+
+	response := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+
+	<-started
+	cancel()
+	assert.Equal(t, "done", <-response)
+	assert.Nil(t, <-served)
+
+	_, err = http.Get("http://" + listener.Addr().String())
+	assert.NotNil(t, err)
+}
+
+func TestServeGivesUpAfterShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, server, listener, 50*time.Millisecond)
+	}()
+	go http.Get("http://" + listener.Addr().String())
+
+	<-started
+	cancel()
+	err = <-served
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
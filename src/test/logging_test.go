@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/logger"
+	"github.com/amirnep/shop/src/middlewares"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs routes the logger into memory for the test.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.InfoLevel)
+	t.Cleanup(logger.Replace(zap.New(core)))
+	return logs
+}
+
+func loggingRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(middlewares.RequestId(), middlewares.AccessLog(), middlewares.Recovery())
+	r.GET("/api/admin/GetUser/:user_id", func(c *gin.Context) {
+		logger.ErrorContext(c.Request.Context(), "error when trying to get user by id", context.DeadlineExceeded)
+		c.Status(http.StatusInternalServerError)
+	})
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	return r
+}
+
+func TestRequestIdIsPropagatedToLogs(t *testing.T) {
+	logs := observeLogs(t)
+
+	req, _ := http.NewRequest("GET", "/api/admin/GetUser/7", nil)
+	req.Header.Set(middlewares.RequestIdHeader, "abc-123")
+	w := httptest.NewRecorder()
+	loggingRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, "abc-123", w.Header().Get(middlewares.RequestIdHeader))
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+
+	daoEntry := entries[0].ContextMap()
+	assert.Equal(t, "abc-123", daoEntry["request_id"])
+	assert.Equal(t, context.DeadlineExceeded.Error(), daoEntry["error"])
+
+	access := entries[1]
+	assert.Equal(t, zapcore.ErrorLevel, access.Level)
+	fields := access.ContextMap()
+	assert.Equal(t, "abc-123", fields["request_id"])
+	assert.Equal(t, "/api/admin/GetUser/:user_id", fields["route"])
+	assert.EqualValues(t, http.StatusInternalServerError, fields["status"])
+	assert.Contains(t, fields, "latency_ms")
+	assert.NotContains(t, fields, "user_id")
+}
+
+func TestRequestIdIsAssignedWhenMissingOrInvalid(t *testing.T) {
+	observeLogs(t)
+
+	for _, sent := range []string{"", "bad id\nwith newline", strings.Repeat("x", 200)} {
+		req, _ := http.NewRequest("GET", "/unknown", nil)
+		if sent != "" {
+			req.Header[middlewares.RequestIdHeader] = []string{sent}
+		}
+		w := httptest.NewRecorder()
+		loggingRouter().ServeHTTP(w, req)
+
+		assigned := w.Header().Get(middlewares.RequestIdHeader)
+		assert.Len(t, assigned, 36)
+		assert.NotEqual(t, sent, assigned)
+	}
+}
+
+func TestRecoveryLogsPanics(t *testing.T) {
+	logs := observeLogs(t)
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	loggingRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	panics := logs.FilterMessage("panic while handling request").All()
+	assert.Len(t, panics, 1)
+	assert.Equal(t, "boom", panics[0].ContextMap()["panic"])
+	assert.Len(t, logs.FilterMessage("request").All(), 1)
+}
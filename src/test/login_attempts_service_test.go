@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/login_attempts"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/services"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryLogins points the login attempts service at an empty in-memory
+// repository, which is returned.
+func useMemoryLogins(t *testing.T, cfg config.Login) login_attempts.LoginAttemptRepository {
+	previous := services.LoginAttemptsService
+	t.Cleanup(func() { services.LoginAttemptsService = previous })
+
+	repository := login_attempts.NewMemoryRepository()
+	services.LoginAttemptsService = services.NewLoginAttemptsService(cfg, repository)
+	return repository
+}
+
+func login(email string, password string, clientIp string) (*users.User, int) {
+	user, err := services.UsersService.Login(context.Background(), users.LoginInput{Email: email, Password: password}, clientIp)
+	if err != nil {
+		return nil, err.Status
+	}
+	return user, http.StatusOK
+}
+
+// lockout returns how long key is locked after its last failure.
+func lockout(t *testing.T, repository login_attempts.LoginAttemptRepository, kind string, key string) time.Duration {
+	attempt, err := repository.Get(context.Background(), kind, key)
+	if !assert.Nil(t, err) {
+		return 0
+	}
+	if attempt.LockedUntil == 0 {
+		return 0
+	}
+	return time.Duration(attempt.LockedUntil-attempt.LastFailure) * time.Second
+}
+
+// unlock ends the lockout of key as if its time had passed.
+func unlock(repository login_attempts.LoginAttemptRepository, kind string, key string) {
+	repository.SetLockedUntil(context.Background(), kind, key, 1)
+}
+
+func TestLockoutDoublesWithEveryFailure(t *testing.T) {
+	useMemoryUsers(t)
+	cfg := config.Default().Login
+	cfg.MaxAccountFailures, cfg.LockoutBase, cfg.LockoutMax = 3, time.Minute, 5*time.Minute
+	repository := useMemoryLogins(t, cfg)
+	services.UsersService.CreateUser(context.Background(), newTestUser("lock@test.com"))
+	account := login_attempts.KindAccount
+
+	for i := 0; i < 2; i++ {
+		_, status := login("lock@test.com", "wrong-password", "10.0.0.1")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+	assert.Zero(t, lockout(t, repository, account, "lock@test.com"))
+
+	_, status := login("lock@test.com", "wrong-password", "10.0.0.1")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, time.Minute, lockout(t, repository, account, "lock@test.com"))
+
+	// While locked, not even the right password gets through.
+	_, status = login("lock@test.com", "T@1est12459", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	// Every failure after the lockout ends doubles it, up to the maximum.
+	for _, expected := range []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		unlock(repository, account, "lock@test.com")
+		login("lock@test.com", "wrong-password", "10.0.0.1")
+		assert.Equal(t, expected, lockout(t, repository, account, "lock@test.com"))
+	}
+
+	// A successful login starts over.
+	unlock(repository, account, "lock@test.com")
+	_, status = login("lock@test.com", "T@1est12459", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, status)
+	_, err := repository.Get(context.Background(), account, "lock@test.com")
+	assert.NotNil(t, err)
+}
+
+func TestFailuresOutsideTheWindowStartOver(t *testing.T) {
+	useMemoryUsers(t)
+	cfg := config.Default().Login
+	cfg.MaxAccountFailures = 2
+	repository := useMemoryLogins(t, cfg)
+
+	old := time.Now().Add(-cfg.FailureWindow - time.Minute).Unix()
+	repository.RegisterFailure(context.Background(), login_attempts.KindAccount, "window@test.com", old, 0)
+
+	login("window@test.com", "wrong-password", "10.0.0.1")
+	attempt, _ := repository.Get(context.Background(), login_attempts.KindAccount, "window@test.com")
+	assert.Equal(t, 1, attempt.Failures)
+	assert.Zero(t, attempt.LockedUntil)
+}
+
+func TestClientIpLockout(t *testing.T) {
+	useMemoryUsers(t)
+	cfg := config.Default().Login
+	cfg.MaxIpFailures = 3
+	repository := useMemoryLogins(t, cfg)
+	services.UsersService.CreateUser(context.Background(), newTestUser("ip@test.com"))
+
+	// Spraying passwords over many accounts, some of which do not exist.
+	for _, email := range []string{"a@test.com", "b@test.com", "c@test.com"} {
+		login(email, "wrong-password", "10.0.0.1")
+	}
+	assert.Equal(t, cfg.LockoutBase, lockout(t, repository, login_attempts.KindIp, "10.0.0.1"))
+
+	_, status := login("ip@test.com", "T@1est12459", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	_, status = login("ip@test.com", "T@1est12459", "10.0.0.2")
+	assert.Equal(t, http.StatusOK, status)
+
+	// A valid login does not reset the counter of the IP.
+	unlock(repository, login_attempts.KindIp, "10.0.0.1")
+	login("ip@test.com", "T@1est12459", "10.0.0.1")
+	attempt, _ := repository.Get(context.Background(), login_attempts.KindIp, "10.0.0.1")
+	assert.Equal(t, 3, attempt.Failures)
+
+	locked, _ := services.LoginAttemptsService.GetLockouts(context.Background())
+	assert.Empty(t, locked)
+	assert.NotNil(t, services.LoginAttemptsService.ClearLockout(context.Background(), "user", "ip@test.com"))
+}
+
+func TestIpLockoutCanBeTurnedOff(t *testing.T) {
+	useMemoryUsers(t)
+	cfg := config.Default().Login
+	cfg.IpLockout, cfg.MaxIpFailures = false, 1
+	repository := useMemoryLogins(t, cfg)
+
+	login("a@test.com", "wrong-password", "10.0.0.1")
+	login("b@test.com", "wrong-password", "10.0.0.1")
+	_, err := repository.Get(context.Background(), login_attempts.KindIp, "10.0.0.1")
+	assert.NotNil(t, err)
+
+	locked, _ := services.LoginAttemptsService.GetLockouts(context.Background())
+	assert.Empty(t, locked)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/metrics"
+	"github.com/amirnep/shop/src/middlewares"
+	"github.com/amirnep/shop/src/services"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRecordRoutes(t *testing.T) {
+	r := gin.New()
+	r.Use(middlewares.Metrics())
+	r.GET("/api/admin/GetUser/:user_id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	matched := metrics.HTTPRequests.WithLabelValues("GET", "/api/admin/GetUser/:user_id", "204")
+	unmatched := metrics.HTTPRequests.WithLabelValues("GET", "unmatched", "404")
+	before, beforeUnmatched := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched)
+
+	for _, path := range []string{"/api/admin/GetUser/1", "/api/admin/GetUser/2", "/wp-login.php"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, before+2, testutil.ToFloat64(matched))
+	assert.Equal(t, beforeUnmatched+1, testutil.ToFloat64(unmatched))
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_request_duration_seconds_bucket{method="GET",route="/api/admin/GetUser/:user_id",status="204"`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestMetricsRecordTokenFailureReasons(t *testing.T) {
+	useTestKeys(t)
+	t.Cleanup(func() { jwt.Configure(config.Default().JWT) })
+
+	expiredCfg := config.Default().JWT
+	expiredCfg.AccessTokenTTL = -time.Hour
+	jwt.Configure(expiredCfg)
+	expired, err := jwt.GenerateJWT(users.User{Id: 1, Role: "user"}, false)
+	assert.Nil(t, err)
+	jwt.Configure(config.Default().JWT)
+
+	r := gin.New()
+	r.GET("/api/GetProfile", middlewares.JWTAuthCustomerMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := map[string]string{
+		"missing":   "",
+		"malformed": "Bearer not-a-jwt",
+		"expired":   "Bearer " + expired,
+	}
+	for reason, header := range cases {
+		counter := metrics.TokenFailures.WithLabelValues(reason)
+		before := testutil.ToFloat64(counter)
+
+		req, _ := http.NewRequest("GET", "/api/GetProfile", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, reason)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), reason)
+	}
+}
+
+func TestMetricsRecordLoginResults(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryLogins(t, config.Default().Login)
+	services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+
+	success := metrics.Logins.WithLabelValues(metrics.LoginSuccess)
+	failure := metrics.Logins.WithLabelValues(metrics.LoginFailure)
+	beforeSuccess, beforeFailure := testutil.ToFloat64(success), testutil.ToFloat64(failure)
+
+	_, err := services.UsersService.Login(context.Background(), users.LoginInput{Email: "test@test.com", Password: "T@1est12459"}, "10.0.0.1")
+	assert.Nil(t, err)
+	_, err = services.UsersService.Login(context.Background(), users.LoginInput{Email: "test@test.com", Password: strings.Repeat("x", 12)}, "10.0.0.1")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, beforeSuccess+1, testutil.ToFloat64(success))
+	assert.Equal(t, beforeFailure+1, testutil.ToFloat64(failure))
+}
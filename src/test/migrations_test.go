@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/amirnep/shop/src/datasources/mysql/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreContiguous(t *testing.T) {
+	all, err := migrations.Load()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, all)
+
+	for i, migration := range all {
+		assert.EqualValues(t, i+1, migration.Version, migration.Name)
+		assert.NotEmpty(t, migrations.Statements(migration.Up), migration.Name)
+		assert.NotEmpty(t, migrations.Statements(migration.Down), migration.Name)
+	}
+
+	latest, err := migrations.Latest()
+	assert.Nil(t, err)
+	assert.Equal(t, all[len(all)-1].Version, latest)
+}
+
+func TestMigrationStatements(t *testing.T) {
+	script := `-- comment
+CREATE TABLE a (
+    id BIGINT NOT NULL
+);
+
+INSERT INTO a (id) VALUES
+    (1),
+    (2);
+DROP TABLE b`
+
+	statements := migrations.Statements(script)
+	assert.Equal(t, []string{
+		"CREATE TABLE a (\n    id BIGINT NOT NULL\n);",
+		"INSERT INTO a (id) VALUES\n    (1),\n    (2);",
+		"DROP TABLE b",
+	}, statements)
+}
+
+func TestSupportedSchemaVersions(t *testing.T) {
+	latest, err := migrations.Latest()
+	assert.Nil(t, err)
+
+	assert.Nil(t, migrations.Supports(latest, false))
+	// A rolling deploy may have migrated ahead of this binary.
+	assert.Nil(t, migrations.Supports(latest+1, false))
+	assert.NotNil(t, migrations.Supports(latest-1, false))
+	assert.NotNil(t, migrations.Supports(latest, true))
+	assert.NotNil(t, migrations.Supports(latest+1, true))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/services"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := crypto_utils.NewArgon2idHasher(crypto_utils.DefaultArgon2idParams)
+
+	hash, err := hasher.Hash("T@1est12459")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"))
+	assert.True(t, hasher.Supports(hash))
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	// Every hash gets its own salt.
+	other, _ := hasher.Hash("T@1est12459")
+	assert.NotEqual(t, hash, other)
+
+	match, err := hasher.Verify("T@1est12459", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	match, err = hasher.Verify("T@1est12458", hash)
+	assert.Nil(t, err)
+	assert.False(t, match)
+
+	stronger := crypto_utils.DefaultArgon2idParams
+	stronger.Iterations++
+	assert.True(t, crypto_utils.NewArgon2idHasher(stronger).NeedsRehash(hash))
+
+	_, err = hasher.Verify("T@1est12459", "$argon2id$v=19$broken")
+	assert.NotNil(t, err)
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := crypto_utils.NewBcryptHasher(4)
+
+	hash, err := hasher.Hash("T@1est12459")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"))
+	assert.True(t, hasher.Supports(hash))
+	assert.False(t, hasher.NeedsRehash(hash))
+	assert.True(t, crypto_utils.NewBcryptHasher(crypto_utils.DefaultBcryptCost).NeedsRehash(hash))
+
+	match, err := hasher.Verify("T@1est12459", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	match, err = hasher.Verify("T@1est12458", hash)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestVerifyPasswordFlagsOtherFormatsForRehash(t *testing.T) {
+	argon2idHash, _ := crypto_utils.NewArgon2idHasher(crypto_utils.DefaultArgon2idParams).Hash("T@1est12459")
+	bcryptHash, _ := crypto_utils.NewBcryptHasher(4).Hash("T@1est12459")
+	md5Hash := crypto_utils.GetMd5("T@1est12459")
+	assert.True(t, crypto_utils.IsLegacyMd5(md5Hash))
+
+	for name, c := range map[string]struct {
+		hash   string
+		rehash bool
+	}{
+		"argon2id": {argon2idHash, false},
+		"bcrypt":   {bcryptHash, true},
+		"md5":      {md5Hash, true},
+	} {
+		match, rehash, err := crypto_utils.VerifyPassword("T@1est12459", c.hash)
+		assert.Nil(t, err, name)
+		assert.True(t, match, name)
+		assert.Equal(t, c.rehash, rehash, name)
+
+		match, rehash, err = crypto_utils.VerifyPassword("T@1est12458", c.hash)
+		assert.Nil(t, err, name)
+		assert.False(t, match, name)
+		assert.False(t, rehash, name)
+	}
+
+	_, _, err := crypto_utils.VerifyPassword("T@1est12459", "plain text")
+	assert.Equal(t, crypto_utils.ErrUnknownHashFormat, err)
+}
+
+func TestLoginUpgradesLegacyMd5Hash(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryLogins(t, config.Default().Login)
+
+	repository := users.NewMemoryRepository()
+	services.UsersService = services.NewUsersService(repository)
+	legacy := newTestUser("legacy@test.com")
+	legacy.Password = crypto_utils.GetMd5("T@1est12459")
+	legacy.Role, legacy.DateCreated = roles.RoleUser, date_utils.GetNowDBFormat()
+	assert.Nil(t, repository.Save(context.Background(), legacy))
+
+	// A failed login leaves the hash alone.
+	_, err := services.UsersService.Login(context.Background(), users.LoginInput{Email: "legacy@test.com", Password: "T@1est12458"}, "10.0.0.1")
+	assert.NotNil(t, err)
+	stored, _ := repository.GetByEmail(context.Background(), "legacy@test.com")
+	assert.True(t, crypto_utils.IsLegacyMd5(stored.Password))
+
+	_, err = services.UsersService.Login(context.Background(), users.LoginInput{Email: "legacy@test.com", Password: "T@1est12459"}, "10.0.0.1")
+	assert.Nil(t, err)
+	stored, _ = repository.GetByEmail(context.Background(), "legacy@test.com")
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+	_, err = services.UsersService.Login(context.Background(), users.LoginInput{Email: "legacy@test.com", Password: "T@1est12459"}, "10.0.0.1")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/password_resets"
+	"github.com/amirnep/shop/src/notifications"
+	"github.com/amirnep/shop/src/services"
+	crypto_utils "github.com/amirnep/shop/src/utils/cypto_utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryResets points the password resets service, and the services a
+// reset goes through, at empty in-memory repositories. Reset links are valid
+// for ttl.
+func useMemoryResets(t *testing.T, ttl time.Duration) *capturingNotifier {
+	notifier := useMemoryUsers(t)
+	useMemoryRevocations(t)
+	useMemoryTokens(t, time.Hour)
+
+	previous := services.PasswordResetsService
+	t.Cleanup(func() { services.PasswordResetsService = previous })
+	cfg := config.Default().PasswordReset
+	cfg.TTL = ttl
+	services.PasswordResetsService = services.NewPasswordResetsService(cfg, password_resets.NewMemoryRepository())
+	return notifier
+}
+
+// linkToken returns the token of the link that ends message.
+func linkToken(t *testing.T, message notifications.Message) string {
+	link, err := url.Parse(message.Body[strings.LastIndex(message.Body, " ")+1:])
+	assert.Nil(t, err)
+	return link.Query().Get("token")
+}
+
+func resetInput(token string, password string) password_resets.ResetPasswordInput {
+	return password_resets.ResetPasswordInput{Token: token, Password: password, ConfirmPassword: password}
+}
+
+func TestResetPassword(t *testing.T) {
+	notifier := useMemoryResets(t, time.Hour)
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("reset@test.com"))
+	refreshToken, _ := services.TokensService.CreateRefreshToken(context.Background(), created.Id, false)
+	notifier.messages = nil
+
+	assert.Nil(t, services.PasswordResetsService.RequestReset(context.Background(), "reset@test.com"))
+	services.PasswordResetsService.Wait()
+	if !assert.Len(t, notifier.messages, 1) {
+		return
+	}
+	assert.Equal(t, "reset@test.com", notifier.messages[0].To)
+	token := linkToken(t, notifier.messages[0])
+
+	// A weak password leaves the token usable.
+	err := services.PasswordResetsService.ResetPassword(context.Background(), resetInput(token, "weak"))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+
+	assert.Nil(t, services.PasswordResetsService.ResetPassword(context.Background(), resetInput(token, "N3w@Password99")))
+	stored, _ := services.UsersService.GetUserByEmail(context.Background(), "reset@test.com")
+	match, _, _ := crypto_utils.VerifyPassword("N3w@Password99", stored.Password)
+	assert.True(t, match)
+
+	// Resetting the password logs every session out.
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), refreshToken)
+	assert.NotNil(t, err)
+
+	// The token only works once.
+	err = services.PasswordResetsService.ResetPassword(context.Background(), resetInput(token, "0ther@Password99"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid or expired reset token", err.Message)
+}
+
+func TestOnlyTheNewestResetLinkIsValid(t *testing.T) {
+	notifier := useMemoryResets(t, time.Hour)
+	services.UsersService.CreateUser(context.Background(), newTestUser("reset@test.com"))
+	notifier.messages = nil
+
+	services.PasswordResetsService.RequestReset(context.Background(), "reset@test.com")
+	services.PasswordResetsService.Wait()
+	services.PasswordResetsService.RequestReset(context.Background(), "reset@test.com")
+	services.PasswordResetsService.Wait()
+	if !assert.Len(t, notifier.messages, 2) {
+		return
+	}
+
+	err := services.PasswordResetsService.ResetPassword(context.Background(), resetInput(linkToken(t, notifier.messages[0]), "N3w@Password99"))
+	assert.NotNil(t, err)
+	assert.Nil(t, services.PasswordResetsService.ResetPassword(context.Background(), resetInput(linkToken(t, notifier.messages[1]), "N3w@Password99")))
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	notifier := useMemoryResets(t, -time.Minute)
+	services.UsersService.CreateUser(context.Background(), newTestUser("reset@test.com"))
+	notifier.messages = nil
+
+	services.PasswordResetsService.RequestReset(context.Background(), "reset@test.com")
+	services.PasswordResetsService.Wait()
+	if !assert.Len(t, notifier.messages, 1) {
+		return
+	}
+	err := services.PasswordResetsService.ResetPassword(context.Background(), resetInput(linkToken(t, notifier.messages[0]), "N3w@Password99"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid or expired reset token", err.Message)
+
+	err = services.PasswordResetsService.ResetPassword(context.Background(), resetInput("unknown", "N3w@Password99"))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+}
+
+func TestRequestResetForUnknownEmail(t *testing.T) {
+	notifier := useMemoryResets(t, time.Hour)
+
+	// Unknown emails look like known ones to the caller.
+	assert.Nil(t, services.PasswordResetsService.RequestReset(context.Background(), "nobody@test.com"))
+	services.PasswordResetsService.Wait()
+	assert.Empty(t, notifier.messages)
+}
+
+// blockingNotifier holds every message until release is closed.
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Send(message notifications.Message) error {
+	<-n.release
+	return nil
+}
+
+func TestRequestResetDoesNotWaitForDelivery(t *testing.T) {
+	useMemoryResets(t, time.Hour)
+	services.UsersService.CreateUser(context.Background(), newTestUser("reset@test.com"))
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	notifications.Sender = notifier
+
+	// A known email is answered as soon as an unknown one, before the link
+	// is sent.
+	assert.Nil(t, services.PasswordResetsService.RequestReset(context.Background(), "reset@test.com"))
+	close(notifier.release)
+	services.PasswordResetsService.Wait()
+}
+
+func TestLogNotifierRedactsTokens(t *testing.T) {
+	logs := observeLogs(t)
+
+	message := notifications.Message{
+		To:      "reset@test.com",
+		Subject: "Reset your password",
+		Body:    "Use the following link: http://localhost:8080/ResetPassword?lang=en&token=s3cr3t-t0ken",
+	}
+	assert.Nil(t, notifications.NewLogNotifier().Send(message))
+
+	entries := logs.FilterMessage("notification sent").All()
+	if assert.Len(t, entries, 1) {
+		body := entries[0].ContextMap()["body"]
+		assert.Equal(t, "Use the following link: http://localhost:8080/ResetPassword?lang=en&token=REDACTED", body)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/amirnep/shop/src/utils/json_utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// profileForm builds a multipart body from fields, with image as the Image
+// file when it is not nil.
+func profileForm(fields map[string]string, image []byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	if image != nil {
+		part, _ := writer.CreateFormFile("Image", "a.jpg")
+		part.Write(image)
+	}
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+// editProfile sends body to EditProfile as the user with userId.
+func editProfile(t *testing.T, method string, userId int64, contentType string, body *bytes.Buffer) *httptest.ResponseRecorder {
+	useMemoryRevocations(t)
+	token, err := jwt.GenerateJWT(users.User{Id: userId, Role: "user"}, false)
+	assert.Nil(t, err)
+
+	r := gin.New()
+	r.PUT("/api/EditProfile", controllers.UsersController.Update)
+	r.PATCH("/api/EditProfile", controllers.UsersController.Update)
+
+	req, _ := http.NewRequest(method, "/api/EditProfile", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396, appendix A.
+	cases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		merged, err := json_utils.MergePatch([]byte(c[0]), []byte(c[1]))
+		assert.Nil(t, err)
+		assert.JSONEq(t, c[2], string(merged), "%s patched with %s", c[0], c[1])
+	}
+
+	_, err := json_utils.MergePatch([]byte(`{}`), []byte(`{`))
+	assert.NotNil(t, err)
+}
+
+func TestUpdateUserMergesThePatch(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	user := newTestUser("merge@test.com")
+	user.ImageUrl = putImageWithThumbnails(t, store, "users/a.jpg")
+	created, err := services.UsersService.CreateUser(context.Background(), user)
+	assert.Nil(t, err)
+
+	// Absent members are kept, null clears them.
+	updated, err := services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(`{"first_name": " renamed ", "last_name": null}`)})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "renamed", updated.FirstName)
+	assert.EqualValues(t, "", updated.LastName)
+	assert.EqualValues(t, user.ImageUrl, updated.ImageUrl)
+
+	// An empty patch changes nothing.
+	updated, err = services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "renamed", updated.FirstName)
+
+	updated, err = services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(`{"image_url": null}`)})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "", updated.ImageUrl)
+	assert.False(t, imageExists(store, "users/a.jpg"))
+	assert.False(t, imageExists(store, "users/a_64.jpg"))
+}
+
+func TestUpdateUserRejectsInvalidPatches(t *testing.T) {
+	useMemoryUsers(t)
+	created, err := services.UsersService.CreateUser(context.Background(), newTestUser("invalid@test.com"))
+	assert.Nil(t, err)
+
+	for name, document := range map[string]string{
+		"not an object": `["first_name"]`,
+		"null":          `null`,
+		"email":         `{"email": "other@test.com"}`,
+		"role":          `{"role": "admin"}`,
+		"image url":     `{"image_url": "http://elsewhere.example.com/a.jpg"}`,
+		"number":        `{"first_name": 1}`,
+		"too long":      `{"last_name": "` + strings.Repeat("n", 101) + `"}`,
+	} {
+		_, err := services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(document)})
+		if assert.NotNil(t, err, name) {
+			assert.EqualValues(t, http.StatusBadRequest, err.Status, name)
+		}
+	}
+
+	stored, err := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "amir", stored.FirstName)
+	assert.EqualValues(t, "nep", stored.LastName)
+}
+
+// racingRepository changes the last name of a user right after the first
+// Get of it, as a concurrent update would.
+type racingRepository struct {
+	users.UserRepository
+	raced bool
+}
+
+func (r *racingRepository) Get(ctx context.Context, userId int64) (*users.User, *errors.RestErr) {
+	user, err := r.UserRepository.Get(ctx, userId)
+	if err != nil || r.raced {
+		return user, err
+	}
+	r.raced = true
+	concurrent := *user
+	concurrent.LastName = "concurrent"
+	if _, err := r.UserRepository.Update(ctx, &concurrent, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func TestUpdateUserKeepsConcurrentUpdates(t *testing.T) {
+	useMemoryUsers(t)
+	repository := &racingRepository{UserRepository: users.NewMemoryRepository(), raced: true}
+	services.UsersService = services.NewUsersService(repository)
+	created, err := services.UsersService.CreateUser(context.Background(), newTestUser("race@test.com"))
+	assert.Nil(t, err)
+
+	repository.raced = false
+	updated, err := services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(`{"first_name": "renamed"}`)})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "renamed", updated.FirstName)
+	assert.EqualValues(t, "concurrent", updated.LastName)
+
+	stored, err := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "renamed", stored.FirstName)
+	assert.EqualValues(t, "concurrent", stored.LastName)
+}
+
+func TestEditProfileWithJSON(t *testing.T) {
+	useTestKeys(t)
+	useMemoryUsers(t)
+	useImages(t, storage.NewLocalStore(t.TempDir(), imagesPublicURL), nil)
+	created, err := services.UsersService.CreateUser(context.Background(), newTestUser("json@test.com"))
+	assert.Nil(t, err)
+
+	w := editProfile(t, http.MethodPatch, created.Id, "application/merge-patch+json", bytes.NewBufferString(`{"first_name": "renamed"}`))
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"last_name":"nep"`)
+
+	// A PUT replaces the profile, what is left out is cleared.
+	w = editProfile(t, http.MethodPut, created.Id, "application/json", bytes.NewBufferString(`{"last_name": "doe"}`))
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	stored, _ := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.EqualValues(t, "", stored.FirstName)
+	assert.EqualValues(t, "doe", stored.LastName)
+
+	w = editProfile(t, http.MethodPatch, created.Id, "application/json", bytes.NewBufferString(`{"email": "other@test.com"}`))
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+
+	w = editProfile(t, http.MethodPatch, created.Id, "text/plain", bytes.NewBufferString("first_name=renamed"))
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestEditProfileWithForm(t *testing.T) {
+	useTestKeys(t)
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+	created, err := services.UsersService.CreateUser(context.Background(), newTestUser("form@test.com"))
+	assert.Nil(t, err)
+
+	// The image is optional.
+	body, contentType := profileForm(map[string]string{"FirstName": "renamed"}, nil)
+	w := editProfile(t, http.MethodPatch, created.Id, contentType, body)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	stored, _ := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.EqualValues(t, "renamed", stored.FirstName)
+	assert.EqualValues(t, "nep", stored.LastName)
+	assert.Empty(t, stored.ImageUrl)
+
+	body, contentType = profileForm(nil, encodeJPEG(t, halves(40, 20)))
+	w = editProfile(t, http.MethodPatch, created.Id, contentType, body)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	stored, _ = services.UsersService.GetUser(context.Background(), created.Id)
+	assert.EqualValues(t, "renamed", stored.FirstName)
+	key, ok := store.Key(stored.ImageUrl)
+	assert.True(t, ok)
+	assert.True(t, imageExists(store, key))
+
+	// An invalid image leaves the profile alone.
+	body, contentType = profileForm(map[string]string{"first_name": "again"}, []byte("not an image"))
+	w = editProfile(t, http.MethodPatch, created.Id, contentType, body)
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+	stored, _ = services.UsersService.GetUser(context.Background(), created.Id)
+	assert.EqualValues(t, "renamed", stored.FirstName)
+
+	// A body larger than any image is cut off before it is parsed.
+	padding := strings.Repeat("x", config.Default().Images.MaxUploadSize+(1<<20))
+	body, contentType = profileForm(map[string]string{"first_name": "again", "padding": padding}, nil)
+	w = editProfile(t, http.MethodPatch, created.Id, contentType, body)
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+	stored, _ = services.UsersService.GetUser(context.Background(), created.Id)
+	assert.EqualValues(t, "renamed", stored.FirstName)
+
+	body, contentType = profileForm(map[string]string{"remove_image": "true"}, nil)
+	w = editProfile(t, http.MethodPatch, created.Id, contentType, body)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	stored, _ = services.UsersService.GetUser(context.Background(), created.Id)
+	assert.Empty(t, stored.ImageUrl)
+	assert.False(t, imageExists(store, key))
+}
+
+func TestRegisterWithoutImage(t *testing.T) {
+	useMemoryUsers(t)
+	store := storage.NewLocalStore(t.TempDir(), imagesPublicURL)
+	useImages(t, store, nil)
+
+	r := gin.New()
+	r.POST("/api/Register", controllers.UsersController.Create)
+	register := func(body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/Register", body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	user := newTestUser("register@test.com")
+	user.ImageUrl = "http://elsewhere.example.com/a.jpg"
+	content, _ := json.Marshal(user)
+	w := register(bytes.NewBuffer(content), "application/json")
+	assert.EqualValues(t, http.StatusCreated, w.Code)
+	stored, err := services.UsersService.GetUserByEmail(context.Background(), "register@test.com")
+	assert.Nil(t, err)
+	// Clients cannot point their image anywhere they like.
+	assert.Empty(t, stored.ImageUrl)
+
+	body, contentType := profileForm(map[string]string{
+		"FirstName":       "amir",
+		"LastName":        "nep",
+		"Email":           "withimage@test.com",
+		"Password":        "T@1est12459",
+		"ConfirmPassword": "T@1est12459",
+	}, encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 8, 8))))
+	w = register(body, contentType)
+	assert.EqualValues(t, http.StatusCreated, w.Code)
+	stored, err = services.UsersService.GetUserByEmail(context.Background(), "withimage@test.com")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(stored.ImageUrl, ".png"))
+
+	// A body larger than any image is cut off before it is parsed.
+	body, contentType = profileForm(map[string]string{
+		"FirstName":       "amir",
+		"LastName":        "nep",
+		"Email":           "padded@test.com",
+		"Password":        "T@1est12459",
+		"ConfirmPassword": "T@1est12459",
+		"Padding":         strings.Repeat("x", config.Default().Images.MaxUploadSize+(1<<20)),
+	}, nil)
+	w = register(body, contentType)
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+	_, err = services.UsersService.GetUserByEmail(context.Background(), "padded@test.com")
+	assert.NotNil(t, err)
+}
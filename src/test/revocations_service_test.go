@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/domain/revocations"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryRevocations points the revocations service at an empty in-memory
+// repository, which is returned.
+func useMemoryRevocations(t *testing.T) revocations.RevocationRepository {
+	previous := services.RevocationsService
+	t.Cleanup(func() { services.RevocationsService = previous })
+
+	repository := revocations.NewMemoryRepository()
+	services.RevocationsService = services.NewRevocationsService(repository)
+	return repository
+}
+
+// authenticate reports the status of a request made with token to a handler
+// that only checks it.
+func authenticate(token string) int {
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if _, err := jwt.GetClaims(c); err != nil {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRevokeUserKeepsLaterTokens(t *testing.T) {
+	useTestKeys(t)
+	useMemoryRevocations(t)
+	useMemoryTokens(t, time.Hour)
+	user := users.User{Id: 7, Role: "user"}
+
+	before, _ := jwt.GenerateJWT(user, false)
+	refreshToken, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, services.RevocationsService.RevokeUser(context.Background(), user.Id))
+	time.Sleep(2 * time.Millisecond)
+	// Most likely issued in the same second as the revocation.
+	after, _ := jwt.GenerateJWT(user, false)
+
+	assert.Equal(t, http.StatusUnauthorized, authenticate(before))
+	assert.Equal(t, http.StatusOK, authenticate(after))
+
+	_, _, _, err := services.TokensService.RotateRefreshToken(context.Background(), refreshToken)
+	assert.NotNil(t, err)
+
+	// Other users are not affected.
+	other, _ := jwt.GenerateJWT(users.User{Id: 8, Role: "user"}, false)
+	assert.Equal(t, http.StatusOK, authenticate(other))
+}
+
+func TestRevokeToken(t *testing.T) {
+	useMemoryRevocations(t)
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	assert.False(t, services.RevocationsService.IsRevoked("a", 1, time.Now().UnixMilli()))
+	assert.Nil(t, services.RevocationsService.RevokeToken(context.Background(), "a", 1, expiresAt))
+	assert.True(t, services.RevocationsService.IsRevoked("a", 1, time.Now().UnixMilli()))
+	assert.False(t, services.RevocationsService.IsRevoked("b", 1, time.Now().UnixMilli()))
+}
+
+func TestLoadRevocations(t *testing.T) {
+	repository := useMemoryRevocations(t)
+	now := time.Now()
+	repository.SaveToken(context.Background(), revocations.RevokedToken{Jti: "active", UserId: 1, ExpiresAt: now.Add(time.Hour).Unix()})
+	repository.SaveToken(context.Background(), revocations.RevokedToken{Jti: "expired", UserId: 1, ExpiresAt: now.Add(-time.Hour).Unix()})
+	repository.SaveUserRevocation(context.Background(), revocations.UserRevocation{UserId: 2, RevokedBefore: now.UnixMilli()})
+
+	// Revocations made on another replica apply once they are loaded.
+	assert.False(t, services.RevocationsService.IsRevoked("active", 1, now.UnixMilli()))
+	assert.Nil(t, services.RevocationsService.Load(context.Background()))
+	assert.True(t, services.RevocationsService.IsRevoked("active", 1, now.UnixMilli()))
+	assert.True(t, services.RevocationsService.IsRevoked("", 2, now.UnixMilli()-1))
+	assert.False(t, services.RevocationsService.IsRevoked("", 2, now.UnixMilli()))
+
+	// Expired revocations are no longer needed.
+	active, _ := repository.GetActiveTokens(context.Background(), 0)
+	assert.Len(t, active, 1)
+}
+
+// revokingRepository revokes a token through the service once the active
+// tokens were read, as a request racing the periodic sync would.
+type revokingRepository struct {
+	revocations.RevocationRepository
+	revoke func()
+}
+
+func (r *revokingRepository) GetActiveTokens(ctx context.Context, now int64) ([]revocations.RevokedToken, *errors.RestErr) {
+	tokens, err := r.RevocationRepository.GetActiveTokens(ctx, now)
+	if r.revoke != nil {
+		r.revoke()
+	}
+	return tokens, err
+}
+
+func TestLoadKeepsRevocationsMadeDuringTheSync(t *testing.T) {
+	useMemoryRevocations(t)
+	repository := &revokingRepository{RevocationRepository: revocations.NewMemoryRepository()}
+	services.RevocationsService = services.NewRevocationsService(repository)
+	now := time.Now()
+	repository.revoke = func() {
+		services.RevocationsService.RevokeToken(context.Background(), "late", 1, now.Add(time.Hour).Unix())
+		services.RevocationsService.RevokeUser(context.Background(), 3)
+	}
+	useMemoryTokens(t, time.Hour)
+
+	assert.Nil(t, services.RevocationsService.Load(context.Background()))
+	assert.True(t, services.RevocationsService.IsRevoked("late", 1, now.UnixMilli()))
+	assert.True(t, services.RevocationsService.IsRevoked("", 3, now.UnixMilli()-1))
+}
+
+func TestClaimsIssuedAtMillis(t *testing.T) {
+	claims := jwt.Claims{IssuedAtMs: 1700000000123}
+	claims.IssuedAt = 1700000000
+	assert.EqualValues(t, 1700000000123, claims.IssuedAtMillis())
+
+	// Tokens from before iat_ms only tell the second.
+	claims.IssuedAtMs = 0
+	assert.EqualValues(t, 1700000000000, claims.IssuedAtMillis())
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/middlewares"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryRoles loads the roles the migrations create from an in-memory
+// repository.
+func useMemoryRoles(t *testing.T) {
+	previous := services.RolesService
+	t.Cleanup(func() { services.RolesService = previous })
+
+	services.RolesService = services.NewRolesService(roles.NewMemoryRepository())
+	assert.Nil(t, services.RolesService.Load(context.Background()))
+}
+
+// requireAdminTwoFactor turns two_factor.require_for_admin on.
+func requireAdminTwoFactor(t *testing.T) {
+	previous := services.TwoFactorService
+	t.Cleanup(func() { services.TwoFactorService = previous })
+
+	cfg := config.Default().TwoFactor
+	cfg.RequireForAdmin = true
+	services.TwoFactorService = services.NewTwoFactorService(cfg, two_factor.NewMemoryRepository())
+}
+
+// withRole stores a user with role.
+func withRole(t *testing.T, repository users.UserRepository, email string, role string) *users.User {
+	user := newTestUser(email)
+	user.Role, user.DateCreated = role, date_utils.GetNowDBFormat()
+	assert.Nil(t, repository.Save(context.Background(), user))
+	return user
+}
+
+func TestRequirePermission(t *testing.T) {
+	useTestKeys(t)
+	useMemoryRevocations(t)
+	useMemoryRoles(t)
+	requireAdminTwoFactor(t)
+	services.RolesService.CreateRole(context.Background(), roles.RoleAdmin, roles.Role{Name: "manager", Permissions: []string{roles.PermUsersRead, roles.PermUsersAssignRole}})
+
+	request := func(permission string, token string) int {
+		r := gin.New()
+		r.GET("/", middlewares.RequirePermission(permission), func(c *gin.Context) { c.Status(http.StatusOK) })
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	token := func(role string, mfa bool) string {
+		signed, err := jwt.GenerateJWT(users.User{Id: 1, Role: role}, mfa)
+		assert.Nil(t, err)
+		return signed
+	}
+
+	for name, c := range map[string]struct {
+		permission string
+		token      string
+		status     int
+	}{
+		"no token":            {roles.PermUsersRead, "", http.StatusUnauthorized},
+		"invalid token":       {roles.PermUsersRead, "invalid", http.StatusUnauthorized},
+		"admin":               {roles.PermUsersDelete, token(roles.RoleAdmin, true), http.StatusOK},
+		"admin without mfa":   {roles.PermUsersRead, token(roles.RoleAdmin, false), http.StatusForbidden},
+		"support":             {roles.PermUsersRead, token("support", false), http.StatusOK},
+		"support cannot":      {roles.PermUsersDelete, token("support", true), http.StatusForbidden},
+		"user":                {roles.PermUsersRead, token(roles.RoleUser, true), http.StatusForbidden},
+		"unknown role":        {roles.PermUsersRead, token("root", true), http.StatusForbidden},
+		"manager":             {roles.PermUsersRead, token("manager", true), http.StatusOK},
+		"manager without mfa": {roles.PermUsersRead, token("manager", false), http.StatusForbidden},
+	} {
+		assert.Equal(t, c.status, request(c.permission, c.token), name)
+	}
+
+	// Only roles with a privileged permission need two-factor authentication.
+	assert.True(t, services.TwoFactorService.RequiredForRole("manager"))
+	assert.False(t, services.TwoFactorService.RequiredForRole("support"))
+	assert.False(t, services.TwoFactorService.RequiredForRole(roles.RoleUser))
+}
+
+func TestEditRole(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryRevocations(t)
+	useMemoryTokens(t, time.Hour)
+	useMemoryRoles(t)
+	repository := users.NewMemoryRepository()
+	services.UsersService = services.NewUsersService(repository)
+	services.RolesService.CreateRole(context.Background(), roles.RoleAdmin, roles.Role{Name: "manager", Permissions: []string{roles.PermUsersRead, roles.PermUsersAssignRole, roles.PermLockoutsRead}})
+	services.RolesService.CreateRole(context.Background(), roles.RoleAdmin, roles.Role{Name: "owner", Permissions: roles.Permissions})
+
+	admin := withRole(t, repository, "admin@test.com", roles.RoleAdmin)
+	manager := withRole(t, repository, "manager@test.com", "manager")
+	owner := withRole(t, repository, "owner@test.com", "owner")
+	customer := withRole(t, repository, "customer@test.com", roles.RoleUser)
+
+	for name, c := range map[string]struct {
+		caller *users.User
+		user   *users.User
+		role   string
+		status int
+	}{
+		"unknown role":      {admin, customer, "root", http.StatusBadRequest},
+		"own role":          {manager, manager, roles.RoleUser, http.StatusForbidden},
+		"more permissions":  {manager, customer, roles.RoleAdmin, http.StatusForbidden},
+		"more privileged":   {manager, admin, roles.RoleUser, http.StatusForbidden},
+		"last admin":        {owner, admin, roles.RoleUser, http.StatusBadRequest},
+		"unknown user":      {admin, &users.User{Id: 999}, roles.RoleUser, http.StatusNotFound},
+		"unknown caller":    {&users.User{Id: 999}, customer, roles.RoleUser, http.StatusNotFound},
+		"same role is fine": {manager, customer, roles.RoleUser, 0},
+	} {
+		err := services.UsersService.EditRole(context.Background(), c.caller.Id, c.user.Id, c.role)
+		if c.status == 0 {
+			assert.Nil(t, err, name)
+			continue
+		}
+		if assert.NotNil(t, err, name) {
+			assert.Equal(t, c.status, err.Status, name)
+		}
+	}
+
+	assert.Nil(t, services.UsersService.EditRole(context.Background(), manager.Id, customer.Id, "support"))
+	stored, _ := repository.Get(context.Background(), customer.Id)
+	assert.Equal(t, "support", stored.Role)
+
+	// With a second admin either may be demoted.
+	assert.Nil(t, services.UsersService.EditRole(context.Background(), admin.Id, customer.Id, roles.RoleAdmin))
+	assert.Nil(t, services.UsersService.EditRole(context.Background(), owner.Id, admin.Id, roles.RoleUser))
+	err := services.UsersService.EditRole(context.Background(), owner.Id, customer.Id, roles.RoleUser)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "the last admin cannot be demoted", err.Message)
+	}
+}
+
+func TestUpdateRoleActsAsTheCaller(t *testing.T) {
+	useTestKeys(t)
+	useMemoryUsers(t)
+	useMemoryRevocations(t)
+	useMemoryTokens(t, time.Hour)
+	useMemoryRoles(t)
+	requireAdminTwoFactor(t)
+	repository := users.NewMemoryRepository()
+	services.UsersService = services.NewUsersService(repository)
+	admin := withRole(t, repository, "admin@test.com", roles.RoleAdmin)
+	customer := withRole(t, repository, "customer@test.com", roles.RoleUser)
+
+	update := func(caller *users.User, userId int64, role string) int {
+		token, _ := jwt.GenerateJWT(*caller, true)
+		body, _ := json.Marshal(roles.RoleInput{Role: role})
+
+		r := gin.New()
+		r.PUT("/api/admin/EditRole/:user_id", middlewares.RequirePermission(roles.PermUsersAssignRole), controllers.UsersController.UpdateRole)
+		req, _ := http.NewRequest(http.MethodPut, "/api/admin/EditRole/"+strconv.FormatInt(userId, 10), bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, update(admin, admin.Id, roles.RoleUser))
+	assert.Equal(t, http.StatusOK, update(admin, customer.Id, "support"))
+	// Support staff may not assign roles at all.
+	support, _ := repository.Get(context.Background(), customer.Id)
+	assert.Equal(t, http.StatusForbidden, update(support, admin.Id, roles.RoleUser))
+}
+
+func TestRoleManagersCannotGrantMoreThanTheyHold(t *testing.T) {
+	useMemoryRoles(t)
+	_, err := services.RolesService.CreateRole(context.Background(), roles.RoleAdmin, roles.Role{Name: "role_manager", Permissions: []string{roles.PermRolesRead, roles.PermRolesManage, roles.PermUsersRead}})
+	assert.Nil(t, err)
+
+	// A new role with a permission the caller lacks.
+	_, err = services.RolesService.CreateRole(context.Background(), "role_manager", roles.Role{Name: "deleter", Permissions: []string{roles.PermUsersRead, roles.PermUsersDelete}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.Status)
+	}
+	assert.False(t, services.RolesService.Exists("deleter"))
+	_, err = services.RolesService.CreateRole(context.Background(), "role_manager", roles.Role{Name: "reader", Permissions: []string{roles.PermUsersRead}})
+	assert.Nil(t, err)
+
+	// Their own role, even with permissions they hold.
+	_, err = services.RolesService.SetPermissions(context.Background(), "role_manager", "role_manager", []string{roles.PermRolesManage, roles.PermUsersDelete})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.Status)
+	}
+	_, err = services.RolesService.SetPermissions(context.Background(), "role_manager", "role_manager", []string{roles.PermRolesManage})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.Status)
+	}
+
+	// Another role, with a permission the caller lacks.
+	_, err = services.RolesService.SetPermissions(context.Background(), "role_manager", "reader", []string{roles.PermUsersAssignRole})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.Status)
+	}
+	// A role with more permissions than the caller's.
+	_, err = services.RolesService.SetPermissions(context.Background(), "role_manager", "support", []string{roles.PermUsersRead})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.Status)
+	}
+	assert.False(t, services.RolesService.HasPermission("reader", roles.PermUsersAssignRole))
+	assert.False(t, services.RolesService.HasPermission("role_manager", roles.PermUsersDelete))
+
+	_, err = services.RolesService.SetPermissions(context.Background(), "role_manager", "reader", []string{roles.PermUsersRead, roles.PermRolesRead})
+	assert.Nil(t, err)
+	assert.True(t, services.RolesService.HasPermission("reader", roles.PermRolesRead))
+}
+
+func TestRolesEndpointsActAsTheCaller(t *testing.T) {
+	useTestKeys(t)
+	useMemoryUsers(t)
+	useMemoryRevocations(t)
+	useMemoryRoles(t)
+	_, err := services.RolesService.CreateRole(context.Background(), roles.RoleAdmin, roles.Role{Name: "role_manager", Permissions: []string{roles.PermRolesManage, roles.PermUsersRead}})
+	assert.Nil(t, err)
+	manager := &users.User{Id: 1, Role: "role_manager"}
+
+	send := func(method string, path string, body interface{}) int {
+		token, _ := jwt.GenerateJWT(*manager, false)
+		content, _ := json.Marshal(body)
+
+		r := gin.New()
+		r.POST("/api/admin/Roles", middlewares.RequirePermission(roles.PermRolesManage), controllers.RolesController.CreateRole)
+		r.PUT("/api/admin/Roles/:role/Permissions", middlewares.RequirePermission(roles.PermRolesManage), controllers.RolesController.SetPermissions)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(content))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/admin/Roles", roles.Role{Name: "deleter", Permissions: []string{roles.PermUsersDelete}}))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/api/admin/Roles/role_manager/Permissions", roles.PermissionsInput{Permissions: []string{roles.PermRolesManage, roles.PermUsersAssignRole}}))
+	assert.Equal(t, http.StatusCreated, send(http.MethodPost, "/api/admin/Roles", roles.Role{Name: "reader", Permissions: []string{roles.PermUsersRead}}))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// s3Object is an object kept by the S3 stand-in.
+type s3Object struct {
+	content     []byte
+	contentType string
+	modTime     time.Time
+}
+
+// newS3StandIn serves the few path-style S3 calls the store makes, for a
+// single bucket, the way MinIO would.
+func newS3StandIn(t *testing.T, bucket string) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string]s3Object)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if name != bucket {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if key == "" {
+			if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+				listS3Objects(w, bucket, r.URL.Query().Get("prefix"), objects)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			objects[key] = s3Object{content: content, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC().Truncate(time.Second)}
+			w.Header().Set("ETag", `"etag"`)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodHead, http.MethodGet:
+			object, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set("Content-Type", object.contentType)
+			http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.content))
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// listS3Objects answers a ListObjectsV2 request in a single page.
+func listS3Objects(w http.ResponseWriter, bucket string, prefix string, objects map[string]s3Object) {
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []contents
+	}{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		object := objects[key]
+		result.Contents = append(result.Contents, contents{
+			Key:          key,
+			LastModified: object.modTime.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"etag"`,
+			Size:         len(object.content),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newS3Store(t *testing.T, endpoint string, bucket string) storage.ImageStore {
+	cfg := config.Default().Storage
+	cfg.Backend = "s3"
+	cfg.S3Endpoint = strings.TrimPrefix(endpoint, "http://")
+	cfg.S3Bucket = bucket
+	cfg.S3UseSSL = false
+	cfg.S3AccessKey = "access"
+	cfg.S3SecretKey = "secret"
+
+	store, err := storage.NewImageStore(cfg)
+	assert.Nil(t, err)
+	return store
+}
+
+// assertRoundTrip stores an image, reads it back and deletes it.
+func assertRoundTrip(t *testing.T, store storage.ImageStore, publicURL string) {
+	ctx := context.Background()
+	content := []byte("not really a jpeg")
+
+	url, err := store.Put(ctx, "users/a.jpg", bytes.NewReader(content), int64(len(content)), "image/jpeg")
+	assert.Nil(t, err)
+	assert.EqualValues(t, publicURL+"/users/a.jpg", url)
+	assert.EqualValues(t, url, store.URL("users/a.jpg"))
+
+	reader, info, err := store.Open(ctx, "users/a.jpg")
+	assert.Nil(t, err)
+	read, err := io.ReadAll(reader)
+	reader.Close()
+	assert.Nil(t, err)
+	assert.EqualValues(t, content, read)
+	assert.EqualValues(t, len(content), info.Size)
+	assert.EqualValues(t, "image/jpeg", info.ContentType)
+	assert.False(t, info.ModTime.IsZero())
+
+	assert.Nil(t, store.Delete(ctx, "users/a.jpg"))
+	assert.Nil(t, store.Delete(ctx, "users/a.jpg"))
+	_, _, err = store.Open(ctx, "users/a.jpg")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), "http://localhost:8080/images/")
+
+	assert.Nil(t, store.Check(context.Background()))
+	assertRoundTrip(t, store, "http://localhost:8080/images")
+}
+
+func TestLocalStoreRejectsKeysOutsideItsDirectory(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir(), "http://localhost:8080/images")
+
+	for _, key := range []string{"", "../a.jpg", "/etc/a.jpg", "users/../../a.jpg"} {
+		_, err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "image/jpeg")
+		assert.NotNil(t, err, key)
+	}
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	srv := newS3StandIn(t, "images")
+	store := newS3Store(t, srv.URL, "images")
+
+	assert.Nil(t, store.Check(context.Background()))
+	assertRoundTrip(t, store, srv.URL+"/images")
+}
+
+func TestS3StoreCheckFailsOnMissingBucket(t *testing.T) {
+	srv := newS3StandIn(t, "images")
+	store := newS3Store(t, srv.URL, "other")
+
+	assert.NotNil(t, store.Check(context.Background()))
+}
+
+func TestNewImageStoreRejectsUnknownBackend(t *testing.T) {
+	cfg := config.Default().Storage
+	cfg.Backend = "ftp"
+
+	_, err := storage.NewImageStore(cfg)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/refresh_tokens"
+	"github.com/amirnep/shop/src/services"
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryTokens points the tokens service at an empty in-memory repository,
+// issuing refresh tokens valid for ttl.
+func useMemoryTokens(t *testing.T, ttl time.Duration) {
+	previous := services.TokensService
+	t.Cleanup(func() { services.TokensService = previous })
+
+	cfg := config.Default().JWT
+	cfg.RefreshTokenTTL = ttl
+	services.TokensService = services.NewTokensService(cfg, refresh_tokens.NewMemoryRepository())
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryTokens(t, time.Hour)
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("rotate@test.com"))
+
+	first, err := services.TokensService.CreateRefreshToken(context.Background(), user.Id, true)
+	assert.Nil(t, err)
+
+	rotatedFor, second, mfa, err := services.TokensService.RotateRefreshToken(context.Background(), first)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Id, rotatedFor.Id)
+	assert.NotEqual(t, first, second)
+	// Rotations keep the two-factor state of the login.
+	assert.True(t, mfa)
+
+	_, third, _, err := services.TokensService.RotateRefreshToken(context.Background(), second)
+	assert.Nil(t, err)
+	assert.NotEqual(t, second, third)
+
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), "unknown")
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, err.Status)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryTokens(t, time.Hour)
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("reuse@test.com"))
+
+	first, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	other, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	_, second, _, err := services.TokensService.RotateRefreshToken(context.Background(), first)
+	assert.Nil(t, err)
+
+	// Replaying the rotated token gives the whole family away.
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), first)
+	assert.NotNil(t, err)
+	assert.Equal(t, "refresh token reuse detected", err.Message)
+
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), second)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, err.Status)
+
+	// Other logins of the user are left alone.
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), other)
+	assert.Nil(t, err)
+}
+
+func TestRotateExpiredRefreshToken(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryTokens(t, -time.Minute)
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("expired@test.com"))
+
+	token, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	_, _, _, err := services.TokensService.RotateRefreshToken(context.Background(), token)
+	assert.NotNil(t, err)
+	assert.Equal(t, "refresh token expired", err.Message)
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryTokens(t, time.Hour)
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("logout@test.com"))
+
+	first, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	_, second, _, _ := services.TokensService.RotateRefreshToken(context.Background(), first)
+
+	// Only the owner can revoke a token.
+	err := services.TokensService.RevokeRefreshToken(context.Background(), user.Id+1, second)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, err.Status)
+
+	assert.Nil(t, services.TokensService.RevokeRefreshToken(context.Background(), user.Id, second))
+	_, _, _, err = services.TokensService.RotateRefreshToken(context.Background(), second)
+	assert.NotNil(t, err)
+
+	// Logging out twice, or with a token that never existed, is fine.
+	assert.Nil(t, services.TokensService.RevokeRefreshToken(context.Background(), user.Id, second))
+	assert.Nil(t, services.TokensService.RevokeRefreshToken(context.Background(), user.Id, "unknown"))
+}
+
+func TestRevokeUserRefreshTokens(t *testing.T) {
+	useMemoryUsers(t)
+	useMemoryTokens(t, time.Hour)
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("revoke@test.com"))
+
+	first, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	second, _ := services.TokensService.CreateRefreshToken(context.Background(), user.Id, false)
+	assert.Nil(t, services.TokensService.RevokeUserTokens(context.Background(), user.Id))
+
+	for _, token := range []string{first, second} {
+		_, _, _, err := services.TokensService.RotateRefreshToken(context.Background(), token)
+		assert.NotNil(t, err)
+	}
+}
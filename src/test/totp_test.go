@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirnep/shop/src/utils/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, base32 encoded.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes, of which we use the last 6.
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+
+	// Secrets typed by hand may be in lower case.
+	lower, err := totp.Code(strings.ToLower(rfcSecret), 1)
+	assert.Nil(t, err)
+	upper, _ := totp.Code(rfcSecret, 1)
+	assert.Equal(t, upper, lower)
+
+	_, err = totp.Code("not base32!", 1)
+	assert.NotNil(t, err)
+}
+
+func TestValidateAcceptsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totp.Step(now)
+	code := func(step int64) string {
+		c, err := totp.Code(rfcSecret, step)
+		assert.Nil(t, err)
+		return c
+	}
+
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		step, ok := totp.Validate(rfcSecret, code(current+offset), now, 0)
+		assert.Equal(t, accepted, ok, offset)
+		if accepted {
+			assert.Equal(t, current+offset, step, offset)
+		}
+	}
+
+	// Steps up to the last accepted one are never accepted again.
+	_, ok := totp.Validate(rfcSecret, code(current), now, current)
+	assert.False(t, ok)
+	_, ok = totp.Validate(rfcSecret, code(current+1), now, current)
+	assert.True(t, ok)
+	_, ok = totp.Validate(rfcSecret, "000000", now, 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/middlewares"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	remoteTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent   = "00-" + remoteTraceId + "-00f067aa0ba902b7-01"
+)
+
+// collectorStub stands in for an OTLP/HTTP collector and keeps the exported
+// payloads.
+type collectorStub struct {
+	mu       sync.Mutex
+	payloads [][]byte
+}
+
+func (c *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	c.payloads = append(c.payloads, body)
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+func (c *collectorStub) received() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Join(c.payloads, nil)
+}
+
+func TestTracingExportsRequestSpansToOTLP(t *testing.T) {
+	useMemoryUsers(t)
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+
+	collector := &collectorStub{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{
+		Exporter:    "otlp",
+		Endpoint:    server.URL,
+		ServiceName: "shop-users-api-test",
+		SampleRatio: 1,
+	})
+	assert.Nil(t, err)
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	var handlerTraceId string
+	r := gin.New()
+	r.Use(middlewares.Tracing())
+	r.GET("/api/admin/GetUser/:user_id", func(c *gin.Context) {
+		handlerTraceId = trace.SpanContextFromContext(c.Request.Context()).TraceID().String()
+		services.UsersService.GetUser(c.Request.Context(), created.Id)
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/api/admin/GetUser/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, remoteTraceId, handlerTraceId)
+
+	assert.Nil(t, shutdown(context.Background()))
+
+	payload := collector.received()
+	rawTraceId, _ := hex.DecodeString(remoteTraceId)
+	assert.True(t, bytes.Contains(payload, rawTraceId))
+	assert.True(t, bytes.Contains(payload, []byte("GET /api/admin/GetUser/:user_id")))
+	assert.True(t, bytes.Contains(payload, []byte("usersService.GetUser")))
+	assert.True(t, bytes.Contains(payload, []byte("shop-users-api-test")))
+}
+
+func TestTracingPropagatesTraceparentWithoutExporter(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{Exporter: "none"})
+	assert.Nil(t, err)
+	defer shutdown(context.Background())
+
+	var spanContext trace.SpanContext
+	r := gin.New()
+	r.Use(middlewares.Tracing())
+	r.GET("/healthz", func(c *gin.Context) {
+		spanContext = trace.SpanContextFromContext(c.Request.Context())
+	})
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, spanContext.IsValid())
+
+	req.Header.Set("traceparent", traceparent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, remoteTraceId, spanContext.TraceID().String())
+}
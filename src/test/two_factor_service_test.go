@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/jwt"
+	"github.com/amirnep/shop/src/services"
+	"github.com/amirnep/shop/src/utils/date_utils"
+	"github.com/amirnep/shop/src/utils/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTwoFactor creates a user with confirmed two-factor authentication on
+// in-memory repositories. It returns the user, the TOTP secret and the
+// recovery codes. Logins are locked after maxFailures.
+func useTwoFactor(t *testing.T, maxFailures int) (*users.User, string, []string) {
+	useTestKeys(t)
+	useMemoryUsers(t)
+	useMemoryRevocations(t)
+
+	useAccountLockout(t, maxFailures)
+
+	previous := services.TwoFactorService
+	t.Cleanup(func() { services.TwoFactorService = previous })
+	services.TwoFactorService = services.NewTwoFactorService(config.Default().TwoFactor, two_factor.NewMemoryRepository())
+
+	user, _ := services.UsersService.CreateUser(context.Background(), newTestUser("mfa@test.com"))
+	secret, _, err := services.TwoFactorService.Enroll(context.Background(), user.Id)
+	assert.Nil(t, err)
+	recoveryCodes, err := services.TwoFactorService.Confirm(context.Background(), user.Id, totpCode(t, secret, 0), "10.0.0.1")
+	assert.Nil(t, err)
+	return user, secret, recoveryCodes
+}
+
+// totpCode returns the code of the step offset steps from now.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	code, err := totp.Code(secret, totp.Step(date_utils.GetNow())+offset)
+	assert.Nil(t, err)
+	return code
+}
+
+// useAccountLockout locks accounts after maxFailures failed logins.
+func useAccountLockout(t *testing.T, maxFailures int) {
+	cfg := config.Default().Login
+	cfg.MaxAccountFailures = maxFailures
+	useMemoryLogins(t, cfg)
+}
+
+func newChallenge(t *testing.T, user *users.User) *two_factor.Challenge {
+	token, err := jwt.GenerateMFAChallenge(*user)
+	assert.Nil(t, err)
+	challenge, parseErr := jwt.ParseMFAChallenge(token)
+	assert.Nil(t, parseErr)
+	return challenge
+}
+
+func TestChallengeIsSingleUse(t *testing.T) {
+	user, secret, recoveryCodes := useTwoFactor(t, 5)
+	token, _ := jwt.GenerateMFAChallenge(*user)
+	challenge, err := jwt.ParseMFAChallenge(token)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The step confirmed at enrollment cannot be used again.
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *challenge, two_factor.VerifyInput{Code: totpCode(t, secret, 0)}, "10.0.0.1")
+	assert.NotNil(t, err)
+
+	loggedIn, err := services.TwoFactorService.CompleteLogin(context.Background(), *challenge, two_factor.VerifyInput{Code: totpCode(t, secret, 1)}, "10.0.0.1")
+	if assert.Nil(t, err) {
+		assert.Equal(t, user.Id, loggedIn.Id)
+	}
+	_, err = jwt.ParseMFAChallenge(token)
+	assert.NotNil(t, err)
+
+	// Recovery codes work once as well.
+	recovery := two_factor.VerifyInput{RecoveryCode: recoveryCodes[0]}
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), recovery, "10.0.0.1")
+	assert.Nil(t, err)
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), recovery, "10.0.0.1")
+	assert.NotNil(t, err)
+}
+
+func TestWrongCodesLockTheAccount(t *testing.T) {
+	user, secret, _ := useTwoFactor(t, 3)
+	token, _ := jwt.GenerateMFAChallenge(*user)
+	challenge, _ := jwt.ParseMFAChallenge(token)
+	wrong := two_factor.VerifyInput{Code: strings.Repeat("0", 6)}
+
+	for i := 0; i < 3; i++ {
+		_, err := services.TwoFactorService.CompleteLogin(context.Background(), *challenge, wrong, "10.0.0.1")
+		if assert.NotNil(t, err) {
+			assert.Equal(t, http.StatusUnauthorized, err.Status)
+		}
+	}
+	// The last failure locked the account and revoked the challenge.
+	_, err := jwt.ParseMFAChallenge(token)
+	assert.NotNil(t, err)
+
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), two_factor.VerifyInput{Code: totpCode(t, secret, 1)}, "10.0.0.1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusTooManyRequests, err.Status)
+	}
+
+	// A missing code is a bad request, not a guess.
+	useAccountLockout(t, 1)
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), two_factor.VerifyInput{}, "10.0.0.1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.Status)
+	}
+	_, err = services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), two_factor.VerifyInput{Code: totpCode(t, secret, 1)}, "10.0.0.1")
+	assert.Nil(t, err)
+}
+
+func TestPasswordLoginsDoNotResetCodeGuesses(t *testing.T) {
+	user, secret, _ := useTwoFactor(t, 3)
+	wrong := two_factor.VerifyInput{Code: totpCode(t, secret, 100)}
+
+	// Logging in with the password again between guesses must not buy more
+	// of them.
+	guesses := 0
+	for round := 0; round < 10; round++ {
+		if _, status := login(user.Email, "T@1est12459", "10.0.0.1"); status != http.StatusOK {
+			assert.Equal(t, http.StatusTooManyRequests, status)
+			break
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := services.TwoFactorService.CompleteLogin(context.Background(), *newChallenge(t, user), wrong, "10.0.0.1"); err != nil && err.Status == http.StatusUnauthorized {
+				guesses++
+			}
+		}
+	}
+	assert.Equal(t, 3, guesses)
+}
+
+func TestWrongCodesWhenSignedInLockTheAccount(t *testing.T) {
+	user, secret, _ := useTwoFactor(t, 2)
+	wrong := totpCode(t, secret, 100)
+
+	for i := 0; i < 2; i++ {
+		err := services.TwoFactorService.Disable(context.Background(), user.Id, wrong, "", "10.0.0.1")
+		if assert.NotNil(t, err) {
+			assert.Equal(t, http.StatusUnauthorized, err.Status)
+		}
+	}
+	err := services.TwoFactorService.Disable(context.Background(), user.Id, totpCode(t, secret, 1), "", "10.0.0.1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusTooManyRequests, err.Status)
+	}
+	enabled, _ := services.TwoFactorService.IsEnabled(context.Background(), user.Id)
+	assert.True(t, enabled)
+}
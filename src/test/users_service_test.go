@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/amirnep/shop/src/config"
+	"github.com/amirnep/shop/src/controllers"
+	"github.com/amirnep/shop/src/domain/roles"
+	"github.com/amirnep/shop/src/domain/two_factor"
+	"github.com/amirnep/shop/src/domain/users"
+	"github.com/amirnep/shop/src/notifications"
+	"github.com/amirnep/shop/src/services"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type capturingNotifier struct {
+	messages []notifications.Message
+}
+
+func (n *capturingNotifier) Send(message notifications.Message) error {
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+const testVerificationSecret = "0123456789abcdef0123456789abcdef"
+
+// useMemoryUsers points the users service, and the two-factor settings logins
+// look up, at empty in-memory repositories and captures outgoing
+// notifications.
+func useMemoryUsers(t *testing.T) *capturingNotifier {
+	previousService, previousTwoFactor, previousSender := services.UsersService, services.TwoFactorService, notifications.Sender
+	t.Cleanup(func() {
+		services.UsersService, services.TwoFactorService, notifications.Sender = previousService, previousTwoFactor, previousSender
+	})
+
+	notifier := &capturingNotifier{}
+	services.UsersService = services.NewUsersService(users.NewMemoryRepository())
+	services.TwoFactorService = services.NewTwoFactorService(config.Default().TwoFactor, two_factor.NewMemoryRepository())
+	useVerifications(t, config.Default().EmailVerification)
+	notifications.Sender = notifier
+	return notifier
+}
+
+// useVerifications sets up the email verifications service with cfg, signing
+// with testVerificationSecret.
+func useVerifications(t *testing.T, cfg config.EmailVerification) {
+	previous := services.EmailVerificationsService
+	t.Cleanup(func() { services.EmailVerificationsService = previous })
+
+	cfg.Secret = testVerificationSecret
+	services.EmailVerificationsService = services.NewEmailVerificationsService(cfg)
+}
+
+func newTestUser(email string) *users.User {
+	return &users.User{
+		FirstName:       "amir",
+		LastName:        "nep",
+		Email:           email,
+		Password:        "T@1est12459",
+		ConfirmPassword: "T@1est12459",
+	}
+}
+
+func TestCreateUserInMemory(t *testing.T) {
+	useMemoryUsers(t)
+
+	created, err := services.UsersService.CreateUser(context.Background(), newTestUser(" Test@Test.com "))
+	assert.Nil(t, err)
+	assert.NotZero(t, created.Id)
+
+	stored, err := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "test@test.com", stored.Email)
+	assert.Equal(t, roles.RoleUser, stored.Role)
+	assert.False(t, stored.EmailVerified)
+	assert.Empty(t, stored.Password)
+
+	withHash, err := services.UsersService.GetUserByEmail(context.Background(), "test@test.com")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(withHash.Password, "$argon2id$"))
+}
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	useMemoryUsers(t)
+
+	_, err := services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+	assert.Nil(t, err)
+
+	_, err = services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	useMemoryUsers(t)
+
+	_, err := services.UsersService.GetUser(context.Background(), 42)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusNotFound, err.Status)
+}
+
+func TestUpdateUserPartial(t *testing.T) {
+	useMemoryUsers(t)
+
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+
+	updated, err := services.UsersService.UpdateUser(context.Background(), created.Id, users.ProfilePatch{Document: json.RawMessage(`{"last_name": "doe"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, "amir", updated.FirstName)
+	assert.Equal(t, "doe", updated.LastName)
+
+	it, err := services.UsersService.ExportUsers(context.Background(), users.ListQuery{})
+	assert.Nil(t, err)
+	defer it.Close()
+
+	assert.True(t, it.Next())
+	assert.Equal(t, "doe", it.User().LastName)
+	assert.False(t, it.Next())
+	assert.Nil(t, it.Err())
+}
+
+func TestVerifyEmailFromRegistrationMail(t *testing.T) {
+	notifier := useMemoryUsers(t)
+
+	created, _ := services.UsersService.CreateUser(context.Background(), newTestUser("test@test.com"))
+	assert.Len(t, notifier.messages, 1)
+
+	body := notifier.messages[0].Body
+	link, parseErr := url.Parse(body[strings.LastIndex(body, " ")+1:])
+	assert.Nil(t, parseErr)
+
+	assert.Nil(t, services.EmailVerificationsService.VerifyEmail(context.Background(), link.Query().Get("token")))
+
+	stored, _ := services.UsersService.GetUser(context.Background(), created.Id)
+	assert.True(t, stored.EmailVerified)
+}
+
+func TestListUsersWithCursor(t *testing.T) {
+	useMemoryUsers(t)
+
+	for _, email := range []string{"c@test.com", "a@test.com", "e@test.com", "b@test.com", "d@other.com"} {
+		_, err := services.UsersService.CreateUser(context.Background(), newTestUser(email))
+		assert.Nil(t, err)
+	}
+
+	query := users.ListQuery{Limit: 2, Sort: users.SortEmail, Order: "desc"}
+	var emails []string
+	for {
+		page, err := services.UsersService.ListUsers(context.Background(), query)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 5, page.Total)
+		for _, user := range page.Users {
+			emails = append(emails, user.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"e@test.com", "d@other.com", "c@test.com", "b@test.com", "a@test.com"}, emails)
+
+	_, err := services.UsersService.ListUsers(context.Background(), users.ListQuery{Cursor: query.Cursor, Sort: users.SortId})
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+}
+
+func TestListUsersWithFilterAndOffset(t *testing.T) {
+	useMemoryUsers(t)
+
+	for _, email := range []string{"a@test.com", "b@test.com", "c@test.com", "d@other.com"} {
+		services.UsersService.CreateUser(context.Background(), newTestUser(email))
+	}
+
+	page, err := services.UsersService.ListUsers(context.Background(), users.ListQuery{Email: "@", Offset: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, page.Total)
+
+	page, err = services.UsersService.ListUsers(context.Background(), users.ListQuery{Email: "B", Limit: 10})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, page.Total)
+	assert.Equal(t, "b@test.com", page.Users[0].Email)
+
+	page, err = services.UsersService.ListUsers(context.Background(), users.ListQuery{Role: roles.RoleUser, Offset: 2, Limit: 1})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, page.Total)
+	assert.Len(t, page.Users, 1)
+	assert.Equal(t, "c@test.com", page.Users[0].Email)
+	assert.NotEmpty(t, page.NextCursor)
+
+	_, err = services.UsersService.ListUsers(context.Background(), users.ListQuery{Sort: "password"})
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+}
+
+func TestExportUsers(t *testing.T) {
+	useMemoryUsers(t)
+
+	for _, email := range []string{"a@test.com", "b@test.com", "c@other.com"} {
+		services.UsersService.CreateUser(context.Background(), newTestUser(email))
+	}
+
+	r := gin.New()
+	r.GET("/api/admin/ExportUsers", controllers.UsersController.ExportUsers)
+
+	req, _ := http.NewRequest("GET", "/api/admin/ExportUsers?email=a", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"email":"a@test.com"`)
+
+	req, _ = http.NewRequest("GET", "/api/admin/ExportUsers?format=csv&email=", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	rows, csvErr := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(t, csvErr)
+	assert.Len(t, rows, 4)
+	assert.Equal(t, "email", rows[0][3])
+	assert.Equal(t, "c@other.com", rows[3][3])
+}
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	useMemoryUsers(t)
+	repository := users.NewMemoryRepository()
+	services.UsersService = services.NewUsersService(repository)
+	for _, name := range []string{"=HYPERLINK(\"http://evil\")", "+1", "-1", "@SUM(A1)", "\tx", "\rx", "amir"} {
+		user := newTestUser(name + "@test.com")
+		user.FirstName = name
+		assert.Nil(t, repository.Save(context.Background(), user))
+	}
+
+	r := gin.New()
+	r.GET("/api/admin/ExportUsers", controllers.UsersController.ExportUsers)
+	req, _ := http.NewRequest("GET", "/api/admin/ExportUsers?format=csv", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	rows, csvErr := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(t, csvErr)
+	if !assert.Len(t, rows, 8) {
+		return
+	}
+	for _, row := range rows[1:7] {
+		assert.True(t, strings.HasPrefix(row[1], "'"), row[1])
+		assert.True(t, strings.HasPrefix(row[3], "'"), row[3])
+	}
+	assert.Equal(t, "amir", rows[7][1])
+	assert.Empty(t, w.Header().Get("X-Export-Error"))
+}
+
+// failingWriter is a client that went away: every write fails.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestExportReportsWriteErrors(t *testing.T) {
+	useMemoryUsers(t)
+	services.UsersService.CreateUser(context.Background(), newTestUser("a@test.com"))
+
+	r := gin.New()
+	r.GET("/api/admin/ExportUsers", controllers.UsersController.ExportUsers)
+	req, _ := http.NewRequest("GET", "/api/admin/ExportUsers?format=csv", nil)
+	w := failingWriter{httptest.NewRecorder()}
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, "error when trying to write user export", w.Header().Get("X-Export-Error"))
+}
@@ -1,7 +1,11 @@
 package crypto_utils
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 )
 
@@ -11,4 +15,29 @@ func GetMd5(input string) string {
 	hash.Write([]byte(input))
 	
 	return hex.EncodeToString(hash.Sum(nil))
-}
\ No newline at end of file
+}
+
+func GetSha256(input string) string {
+	hash := sha256.Sum256([]byte(input))
+	return hex.EncodeToString(hash[:])
+}
+
+// GenerateRandomToken returns size random bytes encoded as URL-safe base64.
+func GenerateRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// SignHMAC returns the URL-safe base64 HMAC-SHA256 of payload.
+func SignHMAC(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyHMAC(secret []byte, payload string, signature string) bool {
+	return hmac.Equal([]byte(SignHMAC(secret, payload)), []byte(signature))
+}
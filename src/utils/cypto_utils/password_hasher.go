@@ -0,0 +1,92 @@
+package crypto_utils
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+	legacyMd5Regex = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+	// Hasher is the algorithm used for every newly stored password. Hashes
+	// produced by any other supported algorithm are still verified and get
+	// flagged for rehashing.
+	Hasher PasswordHasher = NewArgon2idHasher(DefaultArgon2idParams)
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encoded string) (bool, error)
+	Supports(encoded string) bool
+	NeedsRehash(encoded string) bool
+}
+
+func HashPassword(password string) (string, error) {
+	return Hasher.Hash(password)
+}
+
+// VerifyPassword checks password against a stored hash of any supported
+// format. rehash is true when the password matched but the stored hash was
+// not produced by the current Hasher with its current parameters.
+func VerifyPassword(password string, encoded string) (match bool, rehash bool, err error) {
+	switch {
+	case Hasher.Supports(encoded):
+		match, err = Hasher.Verify(password, encoded)
+	case isArgon2id(encoded):
+		match, err = NewArgon2idHasher(DefaultArgon2idParams).Verify(password, encoded)
+	case isBcrypt(encoded):
+		match, err = NewBcryptHasher(DefaultBcryptCost).Verify(password, encoded)
+	case IsLegacyMd5(encoded):
+		match = subtle.ConstantTimeCompare([]byte(GetMd5(password)), []byte(encoded)) == 1
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, Hasher.NeedsRehash(encoded), nil
+}
+
+// VerifyDummy spends as much time as verifying a real password, so that a
+// login for an unknown account cannot be told apart by its response time.
+func VerifyDummy(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = Hasher.Hash("dummy password")
+	})
+	Hasher.Verify(password, dummyHash)
+}
+
+func IsLegacyMd5(encoded string) bool {
+	return legacyMd5Regex.MatchString(encoded)
+}
+
+func isArgon2id(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// NewPasswordHasher returns the hasher registered under name, falling back to
+// argon2id when name is empty.
+func NewPasswordHasher(name string) (PasswordHasher, error) {
+	switch strings.ToLower(name) {
+	case "", "argon2id":
+		return NewArgon2idHasher(DefaultArgon2idParams), nil
+	case "bcrypt":
+		return NewBcryptHasher(DefaultBcryptCost), nil
+	default:
+		return nil, fmt.Errorf("unsupported password hasher %q", name)
+	}
+}
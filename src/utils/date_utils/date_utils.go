@@ -17,4 +17,24 @@ func GetNowString() string {
 
 func GetNowDBFormat() string {
 	return GetNow().Format(apiDbLayout)
-}
\ No newline at end of file
+}
+
+func FormatDBTime(t time.Time) string {
+	return t.Format(apiDbLayout)
+}
+
+func ParseDBTime(value string) (time.Time, error) {
+	return time.ParseInLocation(apiDbLayout, value, time.Local)
+}
+
+// ParseQueryDate parses a date given in a query string, either as a day
+// (2006-01-02), in the database layout or as RFC 3339.
+func ParseQueryDate(value string) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return day, nil
+	}
+	if dbTime, err := ParseDBTime(value); err == nil {
+		return dbTime, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
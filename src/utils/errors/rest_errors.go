@@ -16,6 +16,22 @@ func NewBadRequestError(message string) *RestErr {
 	}
 }
 
+func NewUnauthorizedError(message string) *RestErr {
+	return &RestErr{
+		Message: message,
+		Status:  http.StatusUnauthorized,
+		Error:   "unauthorized",
+	}
+}
+
+func NewForbiddenError(message string) *RestErr {
+	return &RestErr{
+		Message: message,
+		Status:  http.StatusForbidden,
+		Error:   "forbidden",
+	}
+}
+
 func NewNotFoundError(message string) *RestErr {
 	return &RestErr{
 		Message: message,
@@ -24,6 +40,30 @@ func NewNotFoundError(message string) *RestErr {
 	}
 }
 
+func NewConflictError(message string) *RestErr {
+	return &RestErr{
+		Message: message,
+		Status:  http.StatusConflict,
+		Error:   "conflict",
+	}
+}
+
+func NewUnsupportedMediaTypeError(message string) *RestErr {
+	return &RestErr{
+		Message: message,
+		Status:  http.StatusUnsupportedMediaType,
+		Error:   "unsupported_media_type",
+	}
+}
+
+func NewTooManyRequestsError(message string) *RestErr {
+	return &RestErr{
+		Message: message,
+		Status:  http.StatusTooManyRequests,
+		Error:   "too_many_requests",
+	}
+}
+
 func NewInternalServerError(message string) *RestErr {
 	return &RestErr{
 		Message: message,
@@ -0,0 +1,43 @@
+package json_utils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to the target document: a
+// member of the patch that is null removes the member from the target, an
+// object is merged recursively and any other value replaces the member. A
+// patch that is not an object replaces the whole target.
+func MergePatch(target []byte, patch []byte) ([]byte, error) {
+	var targetValue, patchValue interface{}
+	if len(bytes.TrimSpace(target)) > 0 {
+		if err := json.Unmarshal(target, &targetValue); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(targetValue, patchValue))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{}, len(patchObject))
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergeValue(targetObject[name], value)
+	}
+	return targetObject
+}